@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"net/http"
+	"procsman_backend/db"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scope is the permission level of a credential. Every scope includes the ones below it.
+type Scope string
+
+const (
+	// ScopeRead allows reading processes, groups, stats, events and logs.
+	ScopeRead Scope = "read"
+	// ScopeOperate additionally allows starting, stopping and restarting processes and writing to stdin.
+	ScopeOperate Scope = "operate"
+	// ScopeAdmin allows everything, including changing configuration and managing credentials.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) level() int {
+	switch s {
+	case ScopeRead:
+		return 1
+	case ScopeOperate:
+		return 2
+	case ScopeAdmin:
+		return 3
+	}
+	return 0
+}
+
+func (s Scope) Valid() bool {
+	return s.level() > 0
+}
+
+func (s Scope) Allows(required Scope) bool {
+	return s.level() >= required.level()
+}
+
+// Credential is the authenticated caller of a request.
+type Credential struct {
+	// Actor identifies the credential in the audit log.
+	Actor string
+	Scope Scope
+	// GroupIDs restricts the credential to processes of these groups. nil means no restriction.
+	GroupIDs []int32
+	ApiKeyID pgtype.Int4
+	// UserID and SessionID are set for session tokens of users.
+	UserID    pgtype.Int4
+	SessionID pgtype.Int4
+}
+
+// AllowsGroup reports whether the credential may access a process or group with the given group id.
+func (c *Credential) AllowsGroup(groupID pgtype.Int4) bool {
+	if c.GroupIDs == nil {
+		return true
+	}
+	return groupID.Valid && slices.Contains(c.GroupIDs, groupID.Int32)
+}
+
+// groupScopedRoutes are the routes without a process or group in the path that group-restricted credentials may use.
+// Their handlers filter the results by Credential.AllowsGroup.
+var groupScopedRoutes = []string{
+	"GET /processes",
+	"GET /groups",
+	"GET /check_auth",
+	"GET /default_config",
+	"POST /auth/logout",
+	"POST /auth/password",
+}
+
+// authenticateKey returns the credential of a raw (decoded) API key, or nil if it's not valid.
+// The legacy key from auth.key is always an admin key.
+func (srv *HttpServer) authenticateKey(ctx context.Context, rawKey []byte) (*Credential, error) {
+	keyHash := sha256.Sum256(rawKey)
+	if srv.legacyKeyHash != nil && hmac.Equal(keyHash[:], srv.legacyKeyHash[:]) {
+		return &Credential{Actor: "auth_key", Scope: ScopeAdmin}, nil
+	}
+
+	key, err := srv.ProcessManager.Queries.GetApiKeyByHash(ctx, keyHash[:])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if key.ExpiresAt.Valid && time.Now().UTC().After(key.ExpiresAt.Time) {
+		return nil, nil
+	}
+	if err = srv.ProcessManager.Queries.TouchApiKey(ctx, key.ID); err != nil {
+		srv.Logger.Errorf("Error updating last use of api key %d: %v\n", key.ID, err)
+	}
+	return &Credential{
+		Actor:    "api_key:" + strconv.Itoa(int(key.ID)) + ":" + key.Name,
+		Scope:    Scope(key.Scope),
+		GroupIDs: key.GroupIds,
+		ApiKeyID: pgtype.Int4{Int32: key.ID, Valid: true},
+	}, nil
+}
+
+// authorizeGroups checks that a group-restricted credential only accesses processes and groups it's allowed to.
+func (srv *HttpServer) authorizeGroups(r *http.Request, cred *Credential) (bool, error) {
+	if cred.GroupIDs == nil {
+		return true, nil
+	}
+	pattern := srv.routePattern(r)
+	if slices.Contains(groupScopedRoutes, pattern) {
+		return true, nil
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	switch {
+	case strings.Contains(pattern, "/processes/by_id/{id}"):
+		if err != nil {
+			// let the handler report the invalid id.
+			return true, nil
+		}
+		process, err := srv.ProcessManager.Queries.GetProcess(r.Context(), int32(id))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return false, nil
+			}
+			return false, err
+		}
+		return cred.AllowsGroup(process.ProcessGroupID), nil
+	case strings.Contains(pattern, "/groups/by_id/{id}"):
+		if err != nil {
+			return true, nil
+		}
+		return cred.AllowsGroup(pgtype.Int4{Int32: int32(id), Valid: true}), nil
+	}
+	return false, nil
+}
+
+type CreateApiKeyRequest struct {
+	Name     string  `json:"name"`
+	Scope    Scope   `json:"scope"`
+	GroupIDs []int32 `json:"group_ids"`
+	// ExpiresAt is a unix timestamp, 0 means the key never expires.
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (c *CreateApiKeyRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if c.Name == "" {
+		return MakeE(MessageCodeNameRequired, "name is required", http.StatusBadRequest, "name is required")
+	}
+	if !c.Scope.Valid() {
+		return MakeE(MessageCodeInvalidScope, "invalid scope", http.StatusBadRequest, "scope must be read, operate or admin")
+	}
+	if c.ExpiresAt != 0 && time.Unix(c.ExpiresAt, 0).Before(time.Now()) {
+		return MakeE(MessageCodeInvalidTimeFrame, "expires_at is in the past", http.StatusBadRequest, "expires_at is in the past")
+	}
+	for _, groupID := range c.GroupIDs {
+		if _, err := srv.ProcessManager.Queries.GetProcessGroup(ctx, groupID); err != nil {
+			return MakeE(MessageCodeInvalidGroup, "invalid group", http.StatusBadRequest, "group "+strconv.Itoa(int(groupID))+" does not exist")
+		}
+	}
+	return nil
+}
+
+// ApiKeyInfo is an API key without its hash.
+type ApiKeyInfo struct {
+	ID         int32       `json:"id"`
+	Name       string      `json:"name"`
+	Scope      Scope       `json:"scope"`
+	GroupIDs   []int32     `json:"group_ids"`
+	CreatedAt  int64       `json:"created_at"`
+	ExpiresAt  pgtype.Int8 `json:"expires_at"`
+	LastUsedAt pgtype.Int8 `json:"last_used_at"`
+	RevokedAt  pgtype.Int8 `json:"revoked_at"`
+}
+
+func newApiKeyInfo(key db.ApiKey) ApiKeyInfo {
+	unix := func(t pgtype.Timestamp) pgtype.Int8 {
+		return pgtype.Int8{Int64: t.Time.Unix(), Valid: t.Valid}
+	}
+	return ApiKeyInfo{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scope:      Scope(key.Scope),
+		GroupIDs:   key.GroupIds,
+		CreatedAt:  key.CreatedAt.Time.Unix(),
+		ExpiresAt:  unix(key.ExpiresAt),
+		LastUsedAt: unix(key.LastUsedAt),
+		RevokedAt:  unix(key.RevokedAt),
+	}
+}
+
+type CreateApiKeyResponse struct {
+	ApiKeyInfo
+	// Key is sent in the X-Auth-Key header. It's only returned once.
+	Key string `json:"key"`
+}
+
+func (srv *HttpServer) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*CreateApiKeyRequest)
+
+	rawKey := make([]byte, 32)
+	if _, err := rand.Read(rawKey); err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	keyHash := sha256.Sum256(rawKey)
+
+	params := db.CreateApiKeyParams{
+		Name:     req.Name,
+		KeyHash:  keyHash[:],
+		Scope:    string(req.Scope),
+		GroupIds: req.GroupIDs,
+	}
+	if req.ExpiresAt != 0 {
+		params.ExpiresAt = pgtype.Timestamp{Time: time.Unix(req.ExpiresAt, 0).UTC(), Valid: true}
+	}
+	key, err := srv.ProcessManager.Queries.CreateApiKey(r.Context(), params)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	info := newApiKeyInfo(key)
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "api_key.create", AuditTargetApiKey, key.ID, nil, info)
+
+	rw.MarshalAndRespond(CreateApiKeyResponse{
+		ApiKeyInfo: info,
+		Key:        base64.StdEncoding.EncodeToString(rawKey),
+	})
+}
+
+func (srv *HttpServer) GetApiKeys(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	keys, err := srv.ProcessManager.Queries.GetApiKeys(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	res := make([]ApiKeyInfo, len(keys))
+	for i, key := range keys {
+		res[i] = newApiKeyInfo(key)
+	}
+	rw.MarshalAndRespond(res)
+}
+
+func (srv *HttpServer) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	existing, err := srv.ProcessManager.Queries.GetApiKey(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeApiKeyNotFound, "API key not found", http.StatusNotFound, "API key not found")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	revoked, err := srv.ProcessManager.Queries.RevokeApiKey(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// already revoked
+			rw.MarshalAndRespond(newApiKeyInfo(existing))
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "api_key.revoke", AuditTargetApiKey, revoked.ID, newApiKeyInfo(existing), newApiKeyInfo(revoked))
+	rw.MarshalAndRespond(newApiKeyInfo(revoked))
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"net/http"
+	"procsman_backend/db"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	AuditTargetProcess       = "process"
+	AuditTargetGroup         = "group"
+	AuditTargetNotifications = "notification_config"
+	AuditTargetApiKey        = "api_key"
+)
+
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// AuditChange is a single changed field in audit_log.changes.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// auditChanges compares the JSON representations of before and after, field by field.
+// Either of them may be nil, for objects that were created or deleted.
+func auditChanges(before, after any) ([]byte, error) {
+	toMap := func(v any) (map[string]any, error) {
+		m := make(map[string]any)
+		if v == nil {
+			return m, nil
+		}
+		marshalled, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return m, json.Unmarshal(marshalled, &m)
+	}
+	beforeMap, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterMap, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]AuditChange)
+	for key, b := range beforeMap {
+		if a, ok := afterMap[key]; !ok || !reflect.DeepEqual(a, b) {
+			changes[key] = AuditChange{Before: b, After: afterMap[key]}
+		}
+	}
+	for key, a := range afterMap {
+		if _, ok := beforeMap[key]; !ok {
+			changes[key] = AuditChange{After: a}
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
+
+// Audit records the request in audit_log and returns the id of the entry.
+// queries should be the transaction the change is made in, if there is one.
+// Failures are logged and result in an invalid id, so the request itself is not failed because of them.
+func (rw *ReqWrapper) Audit(ctx context.Context, queries *db.Queries, action, targetType string, targetID int32, before, after any) pgtype.Int4 {
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		rw.Errorf("Error computing audit changes: %v\n", err)
+	}
+	entry, err := queries.InsertAuditLog(ctx, db.InsertAuditLogParams{
+		Actor:      rw.Actor,
+		RemoteAddr: rw.r.RemoteAddr,
+		RequestID:  rw.Id,
+		Method:     rw.r.Method,
+		Route:      rw.Srv.routePattern(rw.r),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   pgtype.Int4{Int32: targetID, Valid: targetID != 0},
+		Changes:    changes,
+	})
+	if err != nil {
+		rw.Errorf("Error writing audit log: %v\n", err)
+		return pgtype.Int4{}
+	}
+	return pgtype.Int4{Int32: entry.ID, Valid: true}
+}
+
+// routePattern returns the pattern of the route that handles r, e.g. "GET /processes/by_id/{id}".
+func (srv *HttpServer) routePattern(r *http.Request) string {
+	_, pattern := srv.Mux.Handler(r)
+	return pattern
+}
+
+type AuditEntry struct {
+	ID         int32           `json:"id"`
+	Time       int64           `json:"time"`
+	Actor      string          `json:"actor"`
+	RemoteAddr string          `json:"remote_addr"`
+	RequestID  string          `json:"request_id"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   pgtype.Int4     `json:"target_id"`
+	Changes    json.RawMessage `json:"changes"`
+}
+
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	// NextCursor is passed as cursor to get the next (older) page. It's null on the last page.
+	NextCursor pgtype.Int4 `json:"next_cursor"`
+}
+
+// GetAudit returns audit_log entries, newest first, filtered by actor, action, target_type, target_id and from/to.
+func (srv *HttpServer) GetAudit(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	query := r.URL.Query()
+
+	from, to, tfErr := parseTimeFrame(r, time.Unix(0, 0).UTC(), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+
+	optionalText := func(name string) pgtype.Text {
+		v := query.Get(name)
+		return pgtype.Text{String: v, Valid: v != ""}
+	}
+	params := db.GetAuditLogParams{
+		Actor:      optionalText("actor"),
+		Action:     optionalText("action"),
+		TargetType: optionalText("target_type"),
+		RangeFrom:  pgtype.Timestamp{Time: from.UTC(), Valid: true},
+		RangeTo:    pgtype.Timestamp{Time: to.UTC(), Valid: true},
+	}
+
+	if query.Get("target_id") != "" {
+		targetID, err := strconv.Atoi(query.Get("target_id"))
+		if err != nil {
+			rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Could not convert target_id to int")
+			return
+		}
+		params.TargetID = pgtype.Int4{Int32: int32(targetID), Valid: true}
+	}
+
+	if query.Get("cursor") != "" {
+		cursor, err := strconv.Atoi(query.Get("cursor"))
+		if err != nil {
+			rw.E(MessageCodeInvalidCursor, "Invalid cursor", http.StatusBadRequest, "Could not convert cursor to int")
+			return
+		}
+		params.BeforeID = pgtype.Int4{Int32: int32(cursor), Valid: true}
+	}
+
+	limit := DefaultAuditLimit
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > MaxAuditLimit {
+			rw.E(MessageCodeInvalidLimit, "Invalid limit", http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxAuditLimit))
+			return
+		}
+	}
+	// one extra row tells whether there is a next page.
+	params.RowLimit = int32(limit + 1)
+
+	entries, err := srv.ProcessManager.Queries.GetAuditLog(r.Context(), params)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := AuditResponse{Entries: make([]AuditEntry, 0, len(entries))}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		res.NextCursor = pgtype.Int4{Int32: entries[limit-1].ID, Valid: true}
+	}
+	for _, e := range entries {
+		entry := AuditEntry{
+			ID:         e.ID,
+			Time:       e.CreatedAt.Time.Unix(),
+			Actor:      e.Actor,
+			RemoteAddr: e.RemoteAddr,
+			RequestID:  e.RequestID,
+			Method:     e.Method,
+			Route:      e.Route,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetID:   e.TargetID,
+		}
+		if len(e.Changes) > 0 {
+			entry.Changes = e.Changes
+		}
+		res.Entries = append(res.Entries, entry)
+	}
+	rw.MarshalAndRespond(res)
+}
@@ -33,6 +33,29 @@ const (
 	MessageCodeInvalidTimeFrame        MessageCode = "invalid_time_frame"
 	MessageCodeInvalidLimit            MessageCode = "invalid_limit"
 	MessageCodeTextRequired            MessageCode = "text_required"
+	MessageCodeInvalidFormat           MessageCode = "invalid_format"
+	MessageCodeNoProcessesSelected     MessageCode = "no_processes_selected"
+	MessageCodeInvalidStep             MessageCode = "invalid_step"
+	MessageCodeInvalidThresholdRule    MessageCode = "invalid_threshold_rule"
+	MessageCodeInvalidEventType        MessageCode = "invalid_event_type"
+	MessageCodeInvalidEventKind        MessageCode = "invalid_event_kind"
+	MessageCodeInvalidCursor           MessageCode = "invalid_cursor"
+	MessageCodeInvalidScope            MessageCode = "invalid_scope"
+	MessageCodeApiKeyNotFound          MessageCode = "api_key_not_found"
+	MessageCodeInvalidCredentials      MessageCode = "invalid_credentials"
+	MessageCodeInvalidRefreshToken     MessageCode = "invalid_refresh_token"
+	MessageCodeInvalidPassword         MessageCode = "invalid_password"
+	MessageCodeSessionRequired         MessageCode = "session_required"
+	MessageCodeUserAlreadyExists       MessageCode = "user_already_exists"
+	MessageCodeUserNotFound            MessageCode = "user_not_found"
+	MessageCodeRateLimited             MessageCode = "rate_limited"
+	MessageCodeInvalidChannel          MessageCode = "invalid_channel"
+	MessageCodeInvalidTemplate         MessageCode = "invalid_template"
+	MessageCodeInvalidRoute            MessageCode = "invalid_route"
+	MessageCodeRouteNotFound           MessageCode = "route_not_found"
+	MessageCodeInvalidStatus           MessageCode = "invalid_status"
+	MessageCodeChannelNotFound         MessageCode = "channel_not_found"
+	MessageCodeChannelInUse            MessageCode = "channel_in_use"
 )
 
 type Error struct {
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"procsman_backend/procsmanager"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EventStreamKeepAlive is how often a comment is sent to idle event streams, so proxies don't close them.
+const EventStreamKeepAlive = time.Second * 15
+
+var knownBusEventKinds = []procsmanager.BusEventKind{
+	procsmanager.BusEventStatus,
+	procsmanager.BusEventEvent,
+	procsmanager.BusEventStats,
+	procsmanager.BusEventConfig,
+}
+
+func parseBusFilter(r *http.Request) (procsmanager.BusFilter, *Error) {
+	var filter procsmanager.BusFilter
+	var err *Error
+	if filter.ProcessIDs, err = parseIdList(r.URL.Query().Get("process_ids")); err != nil {
+		return filter, err
+	}
+	if filter.GroupIDs, err = parseIdList(r.URL.Query().Get("group_ids")); err != nil {
+		return filter, err
+	}
+	if filter.EventTypes, err = parseEventTypes(r.URL.Query().Get("event_types")); err != nil {
+		return filter, err
+	}
+	if kinds := r.URL.Query().Get("kinds"); kinds != "" {
+		for _, part := range strings.Split(kinds, ",") {
+			kind := procsmanager.BusEventKind(strings.TrimSpace(part))
+			if !slices.Contains(knownBusEventKinds, kind) {
+				return filter, MakeE(MessageCodeInvalidEventKind, "Invalid event kind", http.StatusBadRequest, fmt.Sprintf("Unknown event kind %q", part))
+			}
+			filter.Kinds = append(filter.Kinds, kind)
+		}
+	}
+	return filter, nil
+}
+
+// StreamEvents streams events of all processes as server-sent events.
+// Every event carries its resume token as the SSE id. Reconnecting clients send it back in
+// the Last-Event-ID header or the resume query parameter and receive the events they missed.
+// If those are no longer available, a "reset" event is sent first, and the client should reload its state.
+func (srv *HttpServer) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	filter, filterErr := parseBusFilter(r)
+	if filterErr != nil {
+		rw.WriteError(filterErr)
+		return
+	}
+
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("resume")
+	}
+
+	bus := srv.ProcessManager.Events
+	sub, missed, complete := bus.Subscribe(filter, resume)
+	defer sub.Unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	_ = rw.WriteHeader(http.StatusOK)
+
+	write := func(event, id string, data any) bool {
+		_ = rc.SetWriteDeadline(time.Now().Add(ExportIdleTimeout))
+		marshalled, err := json.Marshal(data)
+		if err != nil {
+			rw.Errorf("Error marshalling event: %v\n", err)
+			return false
+		}
+		if _, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, marshalled); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	// "ready" tells the client that it's up to date. Its id is the newest event, so it comes after the missed ones.
+	head := bus.Token(sub.Head)
+	if !complete {
+		if !write("reset", head, map[string]string{"resume": head}) {
+			return
+		}
+	} else {
+		for _, e := range missed {
+			if !write(string(e.Kind), bus.Token(e.Seq), e) {
+				return
+			}
+		}
+		if !write("ready", head, map[string]string{"resume": head}) {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(EventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-srv.shutdown:
+			return
+		case <-keepAlive.C:
+			_ = rc.SetWriteDeadline(time.Now().Add(ExportIdleTimeout))
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				// the client fell behind, it will reconnect and catch up from the last id it got.
+				rw.Infof("Event stream subscriber fell behind, closing\n")
+				return
+			}
+			if !write(string(e.Kind), bus.Token(e.Seq), e) {
+				return
+			}
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"bufio"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"net/http"
@@ -104,3 +107,184 @@ func (srv *HttpServer) GetProcessEvents(w http.ResponseWriter, r *http.Request)
 
 	rw.MarshalAndRespond(res)
 }
+
+const (
+	DefaultEventsLimit = 100
+	MaxEventsLimit     = 1000
+)
+
+// EventRecord is a full process_event row.
+type EventRecord struct {
+	ID             int32               `json:"id"`
+	ProcessID      pgtype.Int4         `json:"process_id"`
+	Event          db.ProcessEventType `json:"event"`
+	Time           int64               `json:"time"`
+	AdditionalInfo json.RawMessage     `json:"additional_info"`
+}
+
+func newEventRecord(e db.ProcessEvent) EventRecord {
+	rec := EventRecord{
+		ID:        e.ID,
+		ProcessID: e.ProcessID,
+		Event:     e.Event,
+		Time:      e.CreatedAt.Time.Unix(),
+	}
+	if len(e.AdditionalInfo) > 0 {
+		rec.AdditionalInfo = e.AdditionalInfo
+	}
+	return rec
+}
+
+type AllEventsResponse struct {
+	Events []EventRecord `json:"events"`
+	// NextCursor is passed as cursor to get the next (older) page. It's null on the last page.
+	NextCursor pgtype.Int4 `json:"next_cursor"`
+}
+
+// GetEvents returns events of all processes, newest first, filtered by process_ids, group_ids, event_types and from/to.
+// Pages are requested with the cursor from the previous response. With format=ndjson all matching
+// events are streamed instead, one per line, and limit caps the total number of events.
+func (srv *HttpServer) GetEvents(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	query := r.URL.Query()
+
+	processIDs, idsErr := parseIdList(query.Get("process_ids"))
+	if idsErr != nil {
+		rw.WriteError(idsErr)
+		return
+	}
+	groupIDs, idsErr := parseIdList(query.Get("group_ids"))
+	if idsErr != nil {
+		rw.WriteError(idsErr)
+		return
+	}
+	eventTypes, typesErr := parseEventTypes(query.Get("event_types"))
+	if typesErr != nil {
+		rw.WriteError(typesErr)
+		return
+	}
+	from, to, tfErr := parseTimeFrame(r, time.Unix(0, 0).UTC(), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+
+	ndjson := false
+	switch query.Get("format") {
+	case "", "json":
+	case "ndjson":
+		ndjson = true
+	default:
+		rw.E(MessageCodeInvalidFormat, "Invalid format", http.StatusBadRequest, "format must be json or ndjson")
+		return
+	}
+
+	limit := DefaultEventsLimit
+	if ndjson {
+		limit = 0
+	}
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || (!ndjson && limit > MaxEventsLimit) {
+			details := fmt.Sprintf("limit must be between 1 and %d", MaxEventsLimit)
+			if ndjson {
+				// ndjson exports have no upper bound.
+				details = "limit must be positive"
+			}
+			rw.E(MessageCodeInvalidLimit, "Invalid limit", http.StatusBadRequest, details)
+			return
+		}
+	}
+
+	var cursor pgtype.Int4
+	if query.Get("cursor") != "" {
+		c, err := strconv.Atoi(query.Get("cursor"))
+		if err != nil {
+			rw.E(MessageCodeInvalidCursor, "Invalid cursor", http.StatusBadRequest, "Could not convert cursor to int")
+			return
+		}
+		cursor = pgtype.Int4{Int32: int32(c), Valid: true}
+	}
+
+	types := make([]string, len(eventTypes))
+	for i, t := range eventTypes {
+		types[i] = string(t)
+	}
+	params := db.GetEventsParams{
+		ProcessIds: processIDs,
+		GroupIds:   groupIDs,
+		EventTypes: types,
+		RangeFrom:  pgtype.Timestamp{Time: from.UTC(), Valid: true},
+		RangeTo:    pgtype.Timestamp{Time: to.UTC(), Valid: true},
+		BeforeID:   cursor,
+	}
+
+	if ndjson {
+		srv.streamEventsNdjson(rw, w, r, params, limit)
+		return
+	}
+
+	// one extra row tells whether there is a next page.
+	params.RowLimit = int32(limit + 1)
+	events, err := srv.ProcessManager.Queries.GetEvents(r.Context(), params)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := AllEventsResponse{Events: make([]EventRecord, 0, len(events))}
+	if len(events) > limit {
+		events = events[:limit]
+		res.NextCursor = pgtype.Int4{Int32: events[limit-1].ID, Valid: true}
+	}
+	for _, e := range events {
+		res.Events = append(res.Events, newEventRecord(e))
+	}
+	rw.MarshalAndRespond(res)
+}
+
+// streamEventsNdjson writes all events matching params page by page. limit of 0 means no limit.
+func (srv *HttpServer) streamEventsNdjson(rw *ReqWrapper, w http.ResponseWriter, r *http.Request, params db.GetEventsParams, limit int) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(ExportIdleTimeout))
+	w.Header().Set("Content-Type", ExportFormatNdjson.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=events.%s", ExportFormatNdjson.Extension()))
+
+	bw := bufio.NewWriterSize(&deadlineWriter{w: w, rc: rc}, 32*1024)
+	enc := json.NewEncoder(bw)
+	written := 0
+	for limit == 0 || written < limit {
+		params.RowLimit = MaxEventsLimit
+		if limit != 0 && limit-written < MaxEventsLimit {
+			params.RowLimit = int32(limit - written)
+		}
+		events, err := srv.ProcessManager.Queries.GetEvents(r.Context(), params)
+		if err != nil {
+			if written == 0 {
+				rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+				return
+			}
+			rw.Errorf("Error getting events: %v\n", err)
+			panic(http.ErrAbortHandler)
+		}
+		if written == 0 {
+			_ = rw.WriteHeader(http.StatusOK)
+		}
+		for _, e := range events {
+			if err = enc.Encode(newEventRecord(e)); err != nil {
+				rw.Errorf("Error writing events: %v\n", err)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		written += len(events)
+		if len(events) < int(params.RowLimit) {
+			break
+		}
+		params.BeforeID = pgtype.Int4{Int32: events[len(events)-1].ID, Valid: true}
+	}
+	if err := bw.Flush(); err != nil {
+		rw.Errorf("Error flushing events: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"procsman_backend/db"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatZip    ExportFormat = "zip"
+	ExportFormatTarGz  ExportFormat = "tar.gz"
+	ExportFormatTxt    ExportFormat = "txt"
+	ExportFormatNdjson ExportFormat = "ndjson"
+)
+
+// ExportIdleTimeout is how long a single write to the client may take while streaming an export.
+// The deadline is pushed forward on every write, so the whole download is not limited by http.Server.WriteTimeout.
+const ExportIdleTimeout = time.Second * 30
+
+func ParseExportFormat(s string) (ExportFormat, bool) {
+	switch ExportFormat(s) {
+	case "":
+		return ExportFormatZip, true
+	case ExportFormatZip, ExportFormatTarGz, ExportFormatTxt, ExportFormatNdjson:
+		return ExportFormat(s), true
+	}
+	return "", false
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportFormatZip:
+		return "application/zip"
+	case ExportFormatTarGz:
+		return "application/gzip"
+	case ExportFormatNdjson:
+		return "application/x-ndjson"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+func (f ExportFormat) Extension() string {
+	return string(f)
+}
+
+// ExportFile is a single log file that goes into an export.
+type ExportFile struct {
+	Process db.Process
+	Log     db.Log
+	// Partial is set when the file also contains lines from outside the requested time frame.
+	Partial bool
+}
+
+// archiveName is the name of the file inside zip and tar.gz archives.
+// Files are grouped in a folder per process, so multi-process exports don't collide.
+func (ef *ExportFile) archiveName() string {
+	return fmt.Sprintf("%d-%s/%s", ef.Process.ID, sanitizeFileName(ef.Process.Name), filepath.Base(ef.Log.Path))
+}
+
+func sanitizeFileName(name string) string {
+	out := []rune(name)
+	for i, r := range out {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
+
+// logExporter writes log files into the response in one of the ExportFormat's.
+// AddFile receives a reader that is limited to the size of the file at the moment it was opened,
+// so files that are still being written to do not change size in the middle of the export.
+type logExporter interface {
+	AddFile(ef *ExportFile, src *io.SectionReader) error
+	Close() error
+}
+
+func newLogExporter(format ExportFormat, w io.Writer) logExporter {
+	switch format {
+	case ExportFormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzExporter{gz: gz, tw: tar.NewWriter(gz)}
+	case ExportFormatTxt:
+		return &txtExporter{w: w}
+	case ExportFormatNdjson:
+		return &ndjsonExporter{enc: json.NewEncoder(w)}
+	default:
+		return &zipExporter{zw: zip.NewWriter(w)}
+	}
+}
+
+type zipExporter struct {
+	zw *zip.Writer
+}
+
+func (e *zipExporter) AddFile(ef *ExportFile, src *io.SectionReader) error {
+	f, err := e.zw.CreateHeader(&zip.FileHeader{
+		Name:     ef.archiveName(),
+		Method:   zip.Deflate,
+		Modified: ef.Log.StartTime.Time,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = copyAndMarkRepeatedLines(f, src)
+	return err
+}
+
+func (e *zipExporter) Close() error {
+	return e.zw.Close()
+}
+
+type tarGzExporter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// countingWriter only counts bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (e *tarGzExporter) AddFile(ef *ExportFile, src *io.SectionReader) error {
+	// tar needs the size before the content, and marking repeated lines changes it,
+	// so the file is read twice instead of being buffered in memory.
+	counter := &countingWriter{}
+	if _, err := copyAndMarkRepeatedLines(counter, src); err != nil {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := e.tw.WriteHeader(&tar.Header{
+		Name:    ef.archiveName(),
+		Mode:    0644,
+		Size:    counter.n,
+		ModTime: ef.Log.StartTime.Time,
+	}); err != nil {
+		return err
+	}
+	_, err := copyAndMarkRepeatedLines(e.tw, src)
+	return err
+}
+
+func (e *tarGzExporter) Close() error {
+	if err := e.tw.Close(); err != nil {
+		return err
+	}
+	return e.gz.Close()
+}
+
+type txtExporter struct {
+	w io.Writer
+}
+
+func (e *txtExporter) AddFile(ef *ExportFile, src *io.SectionReader) error {
+	to := "now"
+	if ef.Log.EndTime.Valid {
+		to = ef.Log.EndTime.Time.Format(time.RFC3339)
+	}
+	partial := ""
+	if ef.Partial {
+		partial = " (partial)"
+	}
+	if _, err := fmt.Fprintf(e.w, "===== %s (id %d) | %s - %s%s =====\n", ef.Process.Name, ef.Process.ID, ef.Log.StartTime.Time.Format(time.RFC3339), to, partial); err != nil {
+		return err
+	}
+	_, err := copyAndMarkRepeatedLines(e.w, src)
+	return err
+}
+
+func (e *txtExporter) Close() error {
+	return nil
+}
+
+// NdjsonLogLine is a single line of an NDJSON export.
+// Lines are not timestamped individually, so every line carries the time frame of the file it came from.
+type NdjsonLogLine struct {
+	ProcessID   int32  `json:"process_id"`
+	ProcessName string `json:"process_name"`
+	LogID       int32  `json:"log_id"`
+	FileFrom    int64  `json:"file_from"`
+	FileTo      int64  `json:"file_to,omitempty"`
+	Partial     bool   `json:"partial"`
+	Line        int    `json:"line"`
+	Text        string `json:"text"`
+}
+
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) AddFile(ef *ExportFile, src *io.SectionReader) error {
+	line := NdjsonLogLine{
+		ProcessID:   ef.Process.ID,
+		ProcessName: ef.Process.Name,
+		LogID:       ef.Log.ID,
+		FileFrom:    ef.Log.StartTime.Time.Unix(),
+		Partial:     ef.Partial,
+	}
+	if ef.Log.EndTime.Valid {
+		line.FileTo = ef.Log.EndTime.Time.Unix()
+	}
+
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line.Line++
+		line.Text = scanner.Text()
+		if err := e.enc.Encode(&line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (e *ndjsonExporter) Close() error {
+	return nil
+}
+
+// deadlineWriter pushes the write deadline of the response forward on every write.
+type deadlineWriter struct {
+	w            io.Writer
+	rc           *http.ResponseController
+	lastDeadline time.Time
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if now.Sub(dw.lastDeadline) > time.Second {
+		_ = dw.rc.SetWriteDeadline(now.Add(ExportIdleTimeout))
+		dw.lastDeadline = now
+	}
+	return dw.w.Write(p)
+}
+
+// streamExport writes files to the response in the given format.
+// Once the first byte is written the status can no longer be changed, so errors after that point abort the connection,
+// which lets the client notice that the download is incomplete.
+func (srv *HttpServer) streamExport(rw *ReqWrapper, w http.ResponseWriter, format ExportFormat, fileName string, files []ExportFile) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(ExportIdleTimeout))
+
+	// process names can contain spaces, quotes or ";", which must be quoted or escaped.
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName + "." + format.Extension()}))
+	w.Header().Set("Content-Type", format.ContentType())
+	_ = rw.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriterSize(&deadlineWriter{w: w, rc: rc}, 32*1024)
+	exporter := newLogExporter(format, bw)
+
+	for i := range files {
+		if err := srv.exportFile(exporter, &files[i]); err != nil {
+			rw.Errorf("Error exporting %s: %v\n", files[i].Log.Path, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	if err := exporter.Close(); err != nil {
+		rw.Errorf("Error finishing export: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := bw.Flush(); err != nil {
+		rw.Errorf("Error flushing export: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (srv *HttpServer) exportFile(exporter logExporter, ef *ExportFile) error {
+	f, err := os.Open(ef.Log.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// this sucks, but we still want to return whatever logs we have.
+			// however this should only happen if the log file was deleted while we were reading it,
+			// or if it was deleted manually.
+			srv.Logger.Warningf("Log file %s does not exist\n", ef.Log.Path)
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return exporter.AddFile(ef, io.NewSectionReader(f, 0, info.Size()))
+}
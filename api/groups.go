@@ -7,6 +7,7 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"net/http"
 	"procsman_backend/db"
+	"slices"
 	"strconv"
 )
 
@@ -29,6 +30,9 @@ func (r *CreateProcessGroupRequest) Validate(ctx context.Context, srv *HttpServe
 	if exists {
 		return MakeE(MessageCodeGroupAlreadyExists, "group already exists", http.StatusBadRequest, "group already exists")
 	}
+	if validateErr := validateNotificationTemplates("config.notification_templates", r.Config.NotificationTemplates); validateErr != nil {
+		return validateErr
+	}
 
 	return nil
 }
@@ -45,6 +49,7 @@ func (srv *HttpServer) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeCouldNotCreateGroup, "Could not create process group", http.StatusInternalServerError, err.Error())
 		return
 	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "group.create", AuditTargetGroup, group.ID, nil, group)
 
 	rw.MarshalAndRespond(group)
 }
@@ -57,6 +62,9 @@ func (srv *HttpServer) GetGroups(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 		return
 	}
+	groups = slices.DeleteFunc(groups, func(g db.ProcessGroup) bool {
+		return !rw.Credential.AllowsGroup(pgtype.Int4{Int32: g.ID, Valid: true})
+	})
 
 	rw.MarshalAndRespond(groups)
 }
@@ -99,7 +107,7 @@ func (srv *HttpServer) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(idInt))
+	group, err := srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(idInt))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			rw.E(MessageCodeGroupNotFound, "Group not found", http.StatusNotFound, "Group not found")
@@ -114,6 +122,7 @@ func (srv *HttpServer) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 		return
 	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "group.delete", AuditTargetGroup, group.ID, group, nil)
 	rw.WriteHeader(http.StatusNoContent)
 }
 
@@ -127,6 +136,9 @@ func (u *UpdateProcessGroupRequest) Validate(ctx context.Context, srv *HttpServe
 	if u.Name == "" {
 		return MakeE(MessageCodeNameRequired, "Name required", http.StatusBadRequest, "Name required")
 	}
+	if validateErr := validateNotificationTemplates("config.notification_templates", u.Config.NotificationTemplates); validateErr != nil {
+		return validateErr
+	}
 
 	return nil
 }
@@ -159,7 +171,7 @@ func (srv *HttpServer) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	_, err = srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(id))
+	existingGroup, err := srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			rw.E(MessageCodeGroupNotFound, "Group not found", http.StatusNotFound, "Group not found")
@@ -179,6 +191,7 @@ func (srv *HttpServer) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeCouldNotCreateGroup, "Could not create process group", http.StatusInternalServerError, err.Error())
 		return
 	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "group.update", AuditTargetGroup, group.ID, existingGroup, group)
 
 	rw.MarshalAndRespond(group)
 }
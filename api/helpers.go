@@ -7,7 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type ReqWrapper struct {
@@ -17,6 +22,9 @@ type ReqWrapper struct {
 	// TODO: implement responded everywhere
 	responded bool
 	Id        string
+	// Actor identifies the caller in the audit log. It's set by AuthMiddleware, together with Credential.
+	Actor      string
+	Credential *Credential
 }
 
 func (rw *ReqWrapper) Debugf(format string, args ...interface{}) {
@@ -88,3 +96,80 @@ func (rw *ReqWrapper) MarshalAndRespondWithStatus(resp interface{}, status int)
 	rw.WriteHeader(status)
 	_, _ = gz.Write(jsonResp)
 }
+
+// parseTimeFrame reads "from" and "to" (RFC3339) from the query, falling back to the given defaults.
+func parseTimeFrame(r *http.Request, from, to time.Time) (time.Time, time.Time, *Error) {
+	var err error
+	if r.URL.Query().Get("from") != "" {
+		from, err = time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+		if err != nil {
+			return from, to, MakeE(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse from time")
+		}
+	}
+
+	if r.URL.Query().Get("to") != "" {
+		to, err = time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+		if err != nil {
+			return from, to, MakeE(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse to time")
+		}
+	}
+	return from, to, nil
+}
+
+// parseIdList parses a comma separated list of ids, e.g. "1,2,3". An empty string results in an empty list.
+func parseIdList(s string) ([]int32, *Error) {
+	ids := make([]int32, 0)
+	if s == "" {
+		return ids, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, MakeE(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, fmt.Sprintf("Could not convert %q to int", part))
+		}
+		ids = append(ids, int32(id))
+	}
+	return ids, nil
+}
+
+var knownEventTypes = []db.ProcessEventType{
+	db.ProcessEventTypeUNKNOWN,
+	db.ProcessEventTypeSTART,
+	db.ProcessEventTypeSTOP,
+	db.ProcessEventTypeCRASH,
+	db.ProcessEventTypeFULLSTOP,
+	db.ProcessEventTypeFULLCRASH,
+	db.ProcessEventTypeMANUALLYSTOPPED,
+	db.ProcessEventTypeRESTART,
+	db.ProcessEventTypeTHRESHOLD,
+}
+
+// validateNotificationTemplates checks that templates are keyed by known event types and parse.
+// field is the name of the templates in the request, for the error details.
+func validateNotificationTemplates(field string, templates map[string]string) *Error {
+	for event, text := range templates {
+		if !slices.Contains(knownEventTypes, db.ProcessEventType(event)) {
+			return MakeE(MessageCodeInvalidEventType, "Invalid event type", http.StatusBadRequest, fmt.Sprintf("Unknown event type %q in %s", event, field))
+		}
+		if _, err := config.ParseNotificationTemplate(text); err != nil {
+			return MakeE(MessageCodeInvalidTemplate, "Invalid template", http.StatusBadRequest, fmt.Sprintf("%s[%s]: %v", field, event, err))
+		}
+	}
+	return nil
+}
+
+// parseEventTypes parses a comma separated list of event types, e.g. "START,CRASH".
+func parseEventTypes(s string) ([]db.ProcessEventType, *Error) {
+	types := make([]db.ProcessEventType, 0)
+	if s == "" {
+		return types, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		t := db.ProcessEventType(strings.ToUpper(strings.TrimSpace(part)))
+		if !slices.Contains(knownEventTypes, t) {
+			return nil, MakeE(MessageCodeInvalidEventType, "Invalid event type", http.StatusBadRequest, fmt.Sprintf("Unknown event type %q", part))
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"github.com/apepenkov/yalog"
 	"net/http"
 	"procsman_backend/procsmanager"
@@ -16,6 +17,16 @@ type HttpServer struct {
 	ProcessManager *procsmanager.ProcessManager
 	Logger         *yalog.Logger
 	AllowOrigin    string
+
+	// legacyKeyHash is the sha256 of the key in auth.key, nil if there is none.
+	legacyKeyHash *[32]byte
+	// sessionKey signs access tokens.
+	sessionKey []byte
+
+	requestDurations requestDurations
+	rateLimiter      *rateLimiter
+	// shutdown is closed when the server shuts down, so long-lived streams can end.
+	shutdown chan struct{}
 }
 
 type ModelWithValidation interface {
@@ -33,18 +44,23 @@ func NewHttpServer(processManager *procsmanager.ProcessManager, serveAddr string
 		ProcessManager: processManager,
 		Logger:         processManager.Logger.NewLogger("http"),
 		AllowOrigin:    allowOrigin,
+		shutdown:       make(chan struct{}),
+		rateLimiter:    newRateLimiter(processManager.Config.RateLimits),
 	}
+	srv.Server.RegisterOnShutdown(func() {
+		close(srv.shutdown)
+	})
 
 	hf := func(a func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
 		return a
 	}
 
-	WrapAuth := func(a func(http.ResponseWriter, *http.Request)) http.Handler {
-		return srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.AuthMiddleware(hf(a))))
+	WrapAuth := func(scope Scope, a func(http.ResponseWriter, *http.Request)) http.Handler {
+		return srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.RateLimitMiddleware(srv.AuthMiddleware(scope, hf(a)))))
 	}
 
-	WrapAuthAndJson := func(a func(http.ResponseWriter, *http.Request), toGetter InterfaceGetter) http.Handler {
-		return srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.AuthMiddleware(srv.MustUnmarshalJsonMiddleware(hf(a), toGetter))))
+	WrapAuthAndJson := func(scope Scope, a func(http.ResponseWriter, *http.Request), toGetter InterfaceGetter) http.Handler {
+		return srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.RateLimitMiddleware(srv.AuthMiddleware(scope, srv.MustUnmarshalJsonMiddleware(hf(a), toGetter)))))
 	}
 
 	GetAddProcessRequest := func() ModelWithValidation {
@@ -63,37 +79,101 @@ func NewHttpServer(processManager *procsmanager.ProcessManager, serveAddr string
 		return &StdInRequest{}
 	}
 
-	srv.Mux.Handle("GET /processes", WrapAuth(srv.GetProcesses))
-	srv.Mux.Handle("POST /processes", WrapAuthAndJson(srv.AddProcess, GetAddProcessRequest))
-	srv.Mux.Handle("GET /processes/by_id/{id}", WrapAuth(srv.GetProcess))
-	srv.Mux.Handle("DELETE /processes/by_id/{id}", WrapAuth(srv.DeleteProcess))
-	srv.Mux.Handle("PATCH /processes/by_id/{id}", WrapAuthAndJson(srv.UpdateProcess, GetUpdateProcessRequest))
-
-	srv.Mux.Handle("POST /processes/by_id/{id}/stop", WrapAuth(srv.StopProcess))
-	srv.Mux.Handle("POST /processes/by_id/{id}/start", WrapAuth(srv.StartProcess))
-	srv.Mux.Handle("POST /processes/by_id/{id}/restart", WrapAuth(srv.RestartProcess))
-
-	srv.Mux.Handle("GET /processes/by_id/{id}/stats", WrapAuth(srv.GetProcessStats))
-	srv.Mux.Handle("GET /processes/by_id/{id}/events", WrapAuth(srv.GetProcessEvents))
-	srv.Mux.Handle("GET /processes/by_id/{id}/logs", WrapAuth(srv.GetProcessLogs))
-	srv.Mux.Handle("GET /processes/by_id/{id}/export_logs", WrapAuth(srv.ExportLogsAsZip))
-	srv.Mux.Handle("PUT /processes/by_id/{id}/stdin", WrapAuthAndJson(srv.PostStdin, GetStdInRequest))
-
-	srv.Mux.Handle("GET /groups", WrapAuth(srv.GetGroups))
-	srv.Mux.Handle("POST /groups", WrapAuthAndJson(srv.CreateGroup, GetAddGroupRequest))
-	srv.Mux.Handle("GET /groups/by_id/{id}", WrapAuth(srv.GetGroup))
-	srv.Mux.Handle("DELETE /groups/by_id/{id}", WrapAuth(srv.DeleteGroup))
-	srv.Mux.Handle("PATCH /groups/by_id/{id}", WrapAuthAndJson(srv.UpdateGroup, GetUpdateGroupRequest))
-
-	srv.Mux.Handle("GET /notification_config", WrapAuth(srv.GetNotificationSettings))
-	srv.Mux.Handle("PATCH /notification_config", WrapAuthAndJson(srv.UpdateNotificationSettings, func() ModelWithValidation {
+	srv.Mux.Handle("GET /processes", WrapAuth(ScopeRead, srv.GetProcesses))
+	srv.Mux.Handle("POST /processes", WrapAuthAndJson(ScopeAdmin, srv.AddProcess, GetAddProcessRequest))
+	srv.Mux.Handle("GET /processes/by_id/{id}", WrapAuth(ScopeRead, srv.GetProcess))
+	srv.Mux.Handle("DELETE /processes/by_id/{id}", WrapAuth(ScopeAdmin, srv.DeleteProcess))
+	srv.Mux.Handle("PATCH /processes/by_id/{id}", WrapAuthAndJson(ScopeAdmin, srv.UpdateProcess, GetUpdateProcessRequest))
+
+	srv.Mux.Handle("POST /processes/by_id/{id}/stop", WrapAuth(ScopeOperate, srv.StopProcess))
+	srv.Mux.Handle("POST /processes/by_id/{id}/start", WrapAuth(ScopeOperate, srv.StartProcess))
+	srv.Mux.Handle("POST /processes/by_id/{id}/restart", WrapAuth(ScopeOperate, srv.RestartProcess))
+
+	srv.Mux.Handle("GET /processes/by_id/{id}/stats", WrapAuth(ScopeRead, srv.GetProcessStats))
+	srv.Mux.Handle("GET /processes/by_id/{id}/events", WrapAuth(ScopeRead, srv.GetProcessEvents))
+	srv.Mux.Handle("GET /processes/by_id/{id}/logs", WrapAuth(ScopeRead, srv.GetProcessLogs))
+	srv.Mux.Handle("GET /processes/by_id/{id}/export_logs", WrapAuth(ScopeRead, srv.ExportLogs))
+	srv.Mux.Handle("PUT /processes/by_id/{id}/stdin", WrapAuthAndJson(ScopeOperate, srv.PostStdin, GetStdInRequest))
+
+	srv.Mux.Handle("GET /export_logs", WrapAuth(ScopeRead, srv.ExportLogsMulti))
+
+	srv.Mux.Handle("GET /groups", WrapAuth(ScopeRead, srv.GetGroups))
+	srv.Mux.Handle("POST /groups", WrapAuthAndJson(ScopeAdmin, srv.CreateGroup, GetAddGroupRequest))
+	srv.Mux.Handle("GET /groups/by_id/{id}", WrapAuth(ScopeRead, srv.GetGroup))
+	srv.Mux.Handle("DELETE /groups/by_id/{id}", WrapAuth(ScopeAdmin, srv.DeleteGroup))
+	srv.Mux.Handle("PATCH /groups/by_id/{id}", WrapAuthAndJson(ScopeAdmin, srv.UpdateGroup, GetUpdateGroupRequest))
+	srv.Mux.Handle("GET /groups/by_id/{id}/stats", WrapAuth(ScopeRead, srv.GetGroupStats))
+
+	srv.Mux.Handle("GET /stats/host", WrapAuth(ScopeRead, srv.GetHostStats))
+
+	srv.Mux.Handle("GET /audit", WrapAuth(ScopeAdmin, srv.GetAudit))
+	srv.Mux.Handle("GET /rate_limits", WrapAuth(ScopeAdmin, srv.GetRateLimits))
+
+	srv.Mux.Handle("GET /api_keys", WrapAuth(ScopeAdmin, srv.GetApiKeys))
+	srv.Mux.Handle("POST /api_keys", WrapAuthAndJson(ScopeAdmin, srv.CreateApiKey, func() ModelWithValidation {
+		return &CreateApiKeyRequest{}
+	}))
+	srv.Mux.Handle("DELETE /api_keys/by_id/{id}", WrapAuth(ScopeAdmin, srv.RevokeApiKey))
+
+	srv.Mux.Handle("POST /auth/login", srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.RateLimitMiddleware(srv.MustUnmarshalJsonMiddleware(hf(srv.Login), func() ModelWithValidation {
+		return &LoginRequest{}
+	})))))
+	srv.Mux.Handle("POST /auth/refresh", srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.RateLimitMiddleware(srv.MustUnmarshalJsonMiddleware(hf(srv.RefreshSession), func() ModelWithValidation {
+		return &RefreshRequest{}
+	})))))
+	srv.Mux.Handle("POST /auth/logout", WrapAuth(ScopeRead, srv.Logout))
+	srv.Mux.Handle("POST /auth/password", WrapAuthAndJson(ScopeRead, srv.ChangePassword, func() ModelWithValidation {
+		return &ChangePasswordRequest{}
+	}))
+
+	srv.Mux.Handle("GET /users", WrapAuth(ScopeAdmin, srv.GetUsers))
+	srv.Mux.Handle("POST /users", WrapAuthAndJson(ScopeAdmin, srv.CreateUser, func() ModelWithValidation {
+		return &CreateUserRequest{}
+	}))
+	srv.Mux.Handle("DELETE /users/by_id/{id}", WrapAuth(ScopeAdmin, srv.DeleteUser))
+
+	srv.Mux.Handle("GET /events", WrapAuth(ScopeRead, srv.GetEvents))
+	srv.Mux.Handle("GET /events/stream", WrapAuth(ScopeRead, srv.StreamEvents))
+
+	srv.Mux.Handle("GET /notification_config", WrapAuth(ScopeAdmin, srv.GetNotificationSettings))
+	srv.Mux.Handle("PATCH /notification_config", WrapAuthAndJson(ScopeAdmin, srv.UpdateNotificationSettings, func() ModelWithValidation {
 		return &PatchNotificationsConfig{}
 	}))
+	srv.Mux.Handle("POST /notification_config/preview", WrapAuthAndJson(ScopeAdmin, srv.PreviewTemplate, func() ModelWithValidation {
+		return &PreviewTemplateRequest{}
+	}))
+	srv.Mux.Handle("POST /notification_config/test", WrapAuthAndJson(ScopeAdmin, srv.TestNotification, func() ModelWithValidation {
+		return &TestMessage{}
+	}))
+
+	srv.Mux.Handle("GET /notification_channels", WrapAuth(ScopeAdmin, srv.GetNotificationChannels))
+	srv.Mux.Handle("POST /notification_channels", WrapAuthAndJson(ScopeAdmin, srv.CreateNotificationChannel, func() ModelWithValidation {
+		return &NotificationChannelRequest{}
+	}))
+	srv.Mux.Handle("PUT /notification_channels/by_id/{id}", WrapAuthAndJson(ScopeAdmin, srv.UpdateNotificationChannel, func() ModelWithValidation {
+		return &NotificationChannelRequest{}
+	}))
+	srv.Mux.Handle("DELETE /notification_channels/by_id/{id}", WrapAuth(ScopeAdmin, srv.DeleteNotificationChannel))
+
+	srv.Mux.Handle("GET /notifications/history", WrapAuth(ScopeAdmin, srv.GetNotificationHistory))
+
+	srv.Mux.Handle("GET /notification_routes", WrapAuth(ScopeAdmin, srv.GetNotificationRoutes))
+	srv.Mux.Handle("POST /notification_routes", WrapAuthAndJson(ScopeAdmin, srv.CreateNotificationRoute, func() ModelWithValidation {
+		return &NotificationRouteRequest{}
+	}))
+	srv.Mux.Handle("PUT /notification_routes/by_id/{id}", WrapAuthAndJson(ScopeAdmin, srv.UpdateNotificationRoute, func() ModelWithValidation {
+		return &NotificationRouteRequest{}
+	}))
+	srv.Mux.Handle("DELETE /notification_routes/by_id/{id}", WrapAuth(ScopeAdmin, srv.DeleteNotificationRoute))
 
 	srv.Mux.Handle("GET /health", srv.WrapAccessControl(srv.WrapRequestMiddleware(http.HandlerFunc(srv.HealthCheck))))
-	srv.Mux.Handle("GET /check_auth", WrapAuth(srv.HealthCheck))
+	srv.Mux.Handle("GET /check_auth", WrapAuth(ScopeRead, srv.HealthCheck))
 
-	srv.Mux.Handle("GET /default_config", WrapAuth(srv.GetDefaultConfiguration))
+	srv.Mux.Handle("GET /default_config", WrapAuth(ScopeRead, srv.GetDefaultConfiguration))
+
+	if prom := processManager.Config.Prometheus; prom != nil && prom.Enabled {
+		srv.Mux.Handle("GET /metrics", srv.WrapAccessControl(srv.WrapRequestMiddleware(srv.MetricsAuthMiddleware(hf(srv.GetMetrics)))))
+	}
 
 	srv.Mux.Handle("OPTIONS /", srv.WrapAccessControl(srv.WrapRequestMiddleware(http.HandlerFunc(srv.OPTIONS))))
 
@@ -103,7 +183,7 @@ func NewHttpServer(processManager *procsmanager.ProcessManager, serveAddr string
 func (srv *HttpServer) OPTIONS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", srv.AllowOrigin)
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS, PATCH, PUT")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Auth-Key")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Auth-Key, Authorization")
 	w.WriteHeader(http.StatusOK)
 }
 func (srv *HttpServer) HealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -115,6 +195,33 @@ func (srv *HttpServer) Close() {
 }
 
 func (srv *HttpServer) ListenAndServe() error {
-	srv.Server.Handler = srv.Mux
+	srv.Server.Handler = srv.WrapRequestDuration(srv.Mux)
+	cfg := srv.ProcessManager.Config
+	if cfg.TlsCert != "" {
+		reloader, err := newTlsReloader(srv, cfg)
+		if err != nil {
+			return err
+		}
+		// the certificate comes from TLSConfig, so it's reloaded when it changes.
+		srv.Server.TLSConfig = reloader.TLSConfig()
+	}
+
+	if cfg.UnixSocket != nil {
+		listener, err := listenUnix(cfg.UnixSocket)
+		if err != nil {
+			return err
+		}
+		srv.Server.ConnContext = srv.connContext
+		srv.Logger.Infof("Serving on unix socket %s\n", cfg.UnixSocket.Path)
+		go func() {
+			if err := srv.Server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				srv.Logger.Errorf("Error serving on unix socket: %v\n", err)
+			}
+		}()
+	}
+
+	if cfg.TlsCert != "" {
+		return srv.Server.ListenAndServeTLS("", "")
+	}
 	return srv.Server.ListenAndServe()
 }
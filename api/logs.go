@@ -1,7 +1,6 @@
 package api
 
 import (
-	"archive/zip"
 	"bufio"
 	"bytes"
 	"context"
@@ -12,7 +11,6 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"procsman_backend/db"
 	"strconv"
 	"time"
@@ -98,6 +96,8 @@ type LogPiece struct {
 	To      int64  `json:"to"`
 	Text    string `json:"text"`
 	Missing bool   `json:"missing"`
+	// Partial is set when the file also contains lines from outside the requested time frame.
+	Partial bool `json:"partial"`
 }
 
 type LogsResponse struct {
@@ -146,21 +146,7 @@ func (srv *HttpServer) GetProcessLogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	logs, err := srv.ProcessManager.Queries.GetLogFilesFromTo(r.Context(), db.GetLogFilesFromToParams{
-		ProcessID: pgtype.Int4{
-			Int32: int32(idInt),
-			Valid: true,
-		},
-		StartTime: pgtype.Timestamp{
-			Time:  from.UTC(),
-			Valid: true,
-		},
-		EndTime: pgtype.Timestamp{
-			Time:  to.UTC(),
-			Valid: true,
-		},
-	})
-
+	logs, err := srv.logFilesFromTo(r.Context(), int32(idInt), from, to)
 	if err != nil {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 		return
@@ -175,6 +161,7 @@ func (srv *HttpServer) GetProcessLogs(w http.ResponseWriter, r *http.Request) {
 		if log.EndTime.Valid {
 			res.Logs[i].To = log.EndTime.Time.Unix()
 		}
+		res.Logs[i].Partial = isPartialLog(log, from, to)
 
 		f, err := os.OpenFile(log.Path, os.O_RDONLY, 0)
 		if err != nil {
@@ -245,16 +232,19 @@ func (srv *HttpServer) PostStdin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the text itself is not stored, it may contain secrets.
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "process.stdin", AuditTargetProcess, process.ID, nil, nil)
 	runner.StdIn <- req.Text
 	_ = rw.WriteHeader(http.StatusAccepted)
 }
 
-func (srv *HttpServer) ExportLogsAsZip(w http.ResponseWriter, r *http.Request) {
+func (srv *HttpServer) ExportLogs(w http.ResponseWriter, r *http.Request) {
 	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
 
 	id := r.PathValue("id")
 	if id == "" {
 		rw.E(MessageCodeNoIdProvided, "no id provided", http.StatusBadRequest, "")
+		return
 	}
 
 	idInt, err := strconv.Atoi(id)
@@ -263,127 +253,167 @@ func (srv *HttpServer) ExportLogsAsZip(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = srv.ProcessManager.Queries.GetProcess(r.Context(), int32(idInt))
+	format, ok := ParseExportFormat(r.URL.Query().Get("format"))
+	if !ok {
+		rw.E(MessageCodeInvalidFormat, "Invalid format", http.StatusBadRequest, "format must be one of zip, tar.gz, txt, ndjson")
+		return
+	}
+
+	process, err := srv.ProcessManager.Queries.GetProcess(r.Context(), int32(idInt))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			rw.E(MessageCodeProcessNotFound, "Process not found", http.StatusNotFound, "Process not found")
 			return
 		}
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	from := time.Now().UTC().Add(-24 * time.Hour)
-	to := time.Now().UTC()
-
-	if r.URL.Query().Get("from") != "" {
-		from, err = time.Parse(time.RFC3339, r.URL.Query().Get("from"))
-		if err != nil {
-			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse from time")
-			return
-		}
+	from, to, tfErr := parseTimeFrame(r, time.Now().UTC().Add(-24*time.Hour), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
 	}
 
-	if r.URL.Query().Get("to") != "" {
-		to, err = time.Parse(time.RFC3339, r.URL.Query().Get("to"))
-		if err != nil {
-			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse to time")
-			return
-		}
+	files, err := srv.collectExportFiles(r.Context(), []db.Process{process}, from, to)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	logs, err := srv.ProcessManager.Queries.GetLogFilesFromTo(r.Context(), db.GetLogFilesFromToParams{
-		ProcessID: pgtype.Int4{
-			Int32: int32(idInt),
-			Valid: true,
-		},
-		StartTime: pgtype.Timestamp{
-			Time:  from.UTC(),
-			Valid: true,
-		},
-		EndTime: pgtype.Timestamp{
-			Time:  to.UTC(),
-			Valid: true,
-		},
-	})
+	srv.streamExport(rw, w, format, fmt.Sprintf("logs-%d", process.ID), files)
+}
 
-	if err != nil {
-		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+// ExportLogsMulti exports logs of several processes at once.
+// Processes are selected with process_ids (comma separated) and/or group_id.
+func (srv *HttpServer) ExportLogsMulti(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	format, ok := ParseExportFormat(r.URL.Query().Get("format"))
+	if !ok {
+		rw.E(MessageCodeInvalidFormat, "Invalid format", http.StatusBadRequest, "format must be one of zip, tar.gz, txt, ndjson")
 		return
 	}
 
-	tmpFile, err := os.CreateTemp("", "logs-*.zip")
-	if err != nil {
-		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+	processIds, idsErr := parseIdList(r.URL.Query().Get("process_ids"))
+	if idsErr != nil {
+		rw.WriteError(idsErr)
 		return
 	}
-	defer os.Remove(tmpFile.Name())
 
-	zipWriter := zip.NewWriter(tmpFile)
-	zipWriterClosed := false
-	defer func() {
-		if !zipWriterClosed {
-			_ = zipWriter.Close()
+	processes := make([]db.Process, 0, len(processIds))
+	seen := make(map[int32]bool)
+	for _, pid := range processIds {
+		if seen[pid] {
+			continue
 		}
-	}()
-
-	wasError := false
-
-	for _, logR := range logs {
-		func(log db.Log) { // Pass log as a parameter to ensure it's correctly captured
-			f, err := os.Open(log.Path)
-			if err != nil {
-				if os.IsNotExist(err) {
-					// this sucks, but we still want to return whatever logs we have.
-					// however this should only happen if the log file was deleted while we were reading it,
-					// or if it was deleted manually.
-					srv.Logger.Warningf("Log file %s does not exist\n", log.Path)
-					return
-				}
-				rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
-				wasError = true
+		process, err := srv.ProcessManager.Queries.GetProcess(r.Context(), pid)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				rw.E(MessageCodeProcessNotFound, "Process not found", http.StatusNotFound, fmt.Sprintf("Process %d not found", pid))
 				return
 			}
-			defer f.Close()
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		seen[pid] = true
+		processes = append(processes, process)
+	}
 
-			zipFCreated, err := zipWriter.Create(filepath.Base(log.Path))
-			if err != nil {
-				rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
-				wasError = true
-				return
-			}
-			_, err = copyAndMarkRepeatedLines(zipFCreated, f)
-			if err != nil {
-				rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
-				wasError = true
+	fileName := "logs"
+	if groupId := r.URL.Query().Get("group_id"); groupId != "" {
+		groupIdInt, err := strconv.Atoi(groupId)
+		if err != nil {
+			rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Could not convert group_id to int")
+			return
+		}
+		group, err := srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(groupIdInt))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				rw.E(MessageCodeGroupNotFound, "Group not found", http.StatusNotFound, "Group not found")
 				return
 			}
-
-		}(logR) // Call the anonymous function with log as argument
-
-		if wasError {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 			return
 		}
+		groupProcesses, err := srv.ProcessManager.Queries.GetProcessesByGroup(r.Context(), pgtype.Int4{Int32: group.ID, Valid: true})
+		if err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, process := range groupProcesses {
+			if !seen[process.ID] {
+				seen[process.ID] = true
+				processes = append(processes, process)
+			}
+		}
+		fileName = "logs-" + sanitizeFileName(group.Name)
 	}
 
-	if err != nil {
-		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+	if len(processes) == 0 {
+		rw.E(MessageCodeNoProcessesSelected, "No processes selected", http.StatusBadRequest, "process_ids or group_id is required")
 		return
 	}
 
-	zipWriter.Close()
-	zipWriterClosed = true
-
-	_, err = tmpFile.Seek(0, 0)
-	if err != nil {
-		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+	from, to, tfErr := parseTimeFrame(r, time.Now().UTC().Add(-24*time.Hour), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename=logs.zip")
-	w.Header().Set("Content-Type", "application/zip")
-	_, err = io.Copy(w, tmpFile)
+	files, err := srv.collectExportFiles(r.Context(), processes, from, to)
 	if err != nil {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 		return
 	}
+
+	srv.streamExport(rw, w, format, fileName, files)
+}
+
+func (srv *HttpServer) collectExportFiles(ctx context.Context, processes []db.Process, from, to time.Time) ([]ExportFile, error) {
+	files := make([]ExportFile, 0)
+	for _, process := range processes {
+		logs, err := srv.logFilesFromTo(ctx, process.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			files = append(files, ExportFile{Process: process, Log: log, Partial: isPartialLog(log, from, to)})
+		}
+	}
+	return files, nil
+}
+
+// logFilesFromTo returns every log file of the process that overlaps [from, to].
+// The file that is currently being written to is always included while the process is running.
+func (srv *HttpServer) logFilesFromTo(ctx context.Context, processID int32, from, to time.Time) ([]db.Log, error) {
+	var currentLogID pgtype.Int4
+	if runner := srv.ProcessManager.GetRunner(processID); runner != nil && runner.Status() == db.ProcessStatusRUNNING {
+		currentLogID = runner.CurrentLogID()
+	}
+
+	return srv.ProcessManager.Queries.GetLogFilesFromTo(ctx, db.GetLogFilesFromToParams{
+		ProcessID: pgtype.Int4{
+			Int32: processID,
+			Valid: true,
+		},
+		RangeTo: pgtype.Timestamp{
+			Time:  to.UTC(),
+			Valid: true,
+		},
+		RangeFrom: pgtype.Timestamp{
+			Time:  from.UTC(),
+			Valid: true,
+		},
+		CurrentLogID: currentLogID,
+	})
+}
+
+// isPartialLog reports whether the file starts before from, or ends (or is still open) after to.
+// Lines are not timestamped, so such files are returned whole.
+func isPartialLog(log db.Log, from, to time.Time) bool {
+	if log.StartTime.Time.Before(from.UTC()) {
+		return true
+	}
+	return !log.EndTime.Valid || log.EndTime.Time.After(to.UTC())
 }
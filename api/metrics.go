@@ -0,0 +1,365 @@
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"procsman_backend/db"
+	"procsman_backend/procsmanager"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsStatuses are all statuses exported by procsman_process_status, so every process always has the full set.
+var metricsStatuses = []db.ProcessStatus{
+	db.ProcessStatusRUNNING,
+	db.ProcessStatusSTOPPED,
+	db.ProcessStatusCRASHED,
+	db.ProcessStatusSTARTING,
+	db.ProcessStatusSTOPPING,
+	db.ProcessStatusSTOPPEDWILLRESTART,
+	db.ProcessStatusCRASHEDWILLRESTART,
+	db.ProcessStatusUNKNOWN,
+}
+
+// requestDurationBuckets are the upper bounds of procsman_http_request_duration_seconds, in seconds.
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestDurationKey struct {
+	method string
+	route  string
+	code   int
+}
+
+type requestDurationValue struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// requestDurations is a histogram of HTTP request durations, labelled by method, route pattern and status code.
+type requestDurations struct {
+	mu     sync.Mutex
+	values map[requestDurationKey]*requestDurationValue
+}
+
+func (rd *requestDurations) Observe(key requestDurationKey, seconds float64) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.values == nil {
+		rd.values = make(map[requestDurationKey]*requestDurationValue)
+	}
+	v, ok := rd.values[key]
+	if !ok {
+		v = &requestDurationValue{buckets: make([]uint64, len(requestDurationBuckets))}
+		rd.values[key] = v
+	}
+	for i, le := range requestDurationBuckets {
+		if seconds <= le {
+			v.buckets[i]++
+		}
+	}
+	v.sum += seconds
+	v.count++
+}
+
+// statusRecorder remembers the status code written to the response.
+// Unwrap lets http.ResponseController reach the underlying writer, exports depend on it.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.code == 0 {
+		sr.code = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.code == 0 {
+		sr.code = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// WrapRequestDuration records the duration of every request into procsman_http_request_duration_seconds.
+// Requests are labelled by the route pattern, not the path, so ids don't blow up the number of series.
+func (srv *HttpServer) WrapRequestDuration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := srv.routePattern(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		sr := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			code := sr.code
+			if code == 0 {
+				code = http.StatusOK
+			}
+			srv.requestDurations.Observe(requestDurationKey{method: r.Method, route: route, code: code}, time.Since(start).Seconds())
+		}()
+		next.ServeHTTP(sr, r)
+	})
+}
+
+// MetricsAuthMiddleware checks the bearer token from the prometheus config, if one is set.
+func (srv *HttpServer) MetricsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := srv.ProcessManager.Config.Prometheus.BearerToken
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Missing authorization", http.StatusUnauthorized)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "Invalid authorization", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// metricsWriter writes the Prometheus text exposition format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw *metricsWriter) Header(name, typ, help string) {
+	_, _ = fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample writes a single sample. labels are name-value pairs.
+func (mw *metricsWriter) Sample(name string, value float64, labels ...string) {
+	_, _ = mw.w.WriteString(name)
+	if len(labels) > 0 {
+		_ = mw.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				_ = mw.w.WriteByte(',')
+			}
+			_, _ = mw.w.WriteString(labels[i])
+			_, _ = mw.w.WriteString(`="`)
+			_, _ = mw.w.WriteString(escapeLabelValue(labels[i+1]))
+			_ = mw.w.WriteByte('"')
+		}
+		_ = mw.w.WriteByte('}')
+	}
+	_ = mw.w.WriteByte(' ')
+	_, _ = mw.w.WriteString(formatMetricValue(value))
+	_ = mw.w.WriteByte('\n')
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GetMetrics returns process, database pool, notification and http metrics in the Prometheus text format.
+func (srv *HttpServer) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	groups, err := srv.ProcessManager.Queries.GetProcessGroups(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	groupNames := make(map[int32]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.ID] = group.Name
+	}
+
+	runners := srv.ProcessManager.Runners()
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].Process.ID < runners[j].Process.ID
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+	defer mw.w.Flush()
+
+	type processSample struct {
+		labels  []string
+		status  db.ProcessStatus
+		metrics procsmanager.RunnerMetrics
+		uptime  float64
+	}
+	now := procsmanager.UtcNow()
+	samples := make([]processSample, 0, len(runners))
+	for _, runner := range runners {
+		group := ""
+		if runner.Process.ProcessGroupID.Valid {
+			group = groupNames[runner.Process.ProcessGroupID.Int32]
+		}
+		s := processSample{
+			labels:  []string{"process_id", strconv.Itoa(int(runner.Process.ID)), "process", runner.Process.Name, "group", group},
+			status:  runner.Status(),
+			metrics: runner.Metrics(),
+		}
+		if s.metrics.Pid != 0 {
+			s.uptime = now.Sub(s.metrics.StartedAt).Seconds()
+		}
+		samples = append(samples, s)
+	}
+
+	mw.Header("procsman_runners", "gauge", "Number of managed processes.")
+	mw.Sample("procsman_runners", float64(len(runners)))
+
+	mw.Header("procsman_process_status", "gauge", "Current status of the process, 1 for the current status and 0 for the others.")
+	for _, s := range samples {
+		for _, status := range metricsStatuses {
+			mw.Sample("procsman_process_status", boolToFloat(s.status == status), append(s.labels, "status", string(status))...)
+		}
+	}
+
+	mw.Header("procsman_process_up", "gauge", "Whether the process is running.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_up", boolToFloat(s.status == db.ProcessStatusRUNNING), s.labels...)
+	}
+
+	mw.Header("procsman_process_pid", "gauge", "Pid of the process, 0 if it's not running.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_pid", float64(s.metrics.Pid), s.labels...)
+	}
+
+	mw.Header("procsman_process_uptime_seconds", "gauge", "Seconds since the process was started, 0 if it's not running.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_uptime_seconds", s.uptime, s.labels...)
+	}
+
+	mw.Header("procsman_process_cpu_percent", "gauge", "Cpu usage of the process and its children at the last recording.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_cpu_percent", s.metrics.CpuUsagePercent, s.labels...)
+	}
+
+	mw.Header("procsman_process_memory_bytes", "gauge", "Memory usage of the process and its children at the last recording.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_memory_bytes", float64(s.metrics.MemUsage), s.labels...)
+	}
+
+	mw.Header("procsman_process_starts_total", "counter", "Number of times the process was started since procsman started.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_starts_total", float64(s.metrics.Starts), s.labels...)
+	}
+
+	mw.Header("procsman_process_crashes_total", "counter", "Number of times the process crashed since procsman started.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_crashes_total", float64(s.metrics.Crashes), s.labels...)
+	}
+
+	mw.Header("procsman_process_restarts_total", "counter", "Number of restarts of the process since procsman started, manual, automatic and by threshold rules.")
+	for _, s := range samples {
+		mw.Sample("procsman_process_restarts_total", float64(s.metrics.Restarts), s.labels...)
+	}
+
+	srv.writeDbPoolMetrics(mw)
+
+	mw.Header("procsman_notification_send_failures_total", "counter", "Number of notifications that could not be sent.")
+	mw.Sample("procsman_notification_send_failures_total", float64(srv.ProcessManager.NotificationFailures.Load()))
+
+	srv.writeRequestDurationMetrics(mw)
+}
+
+func (srv *HttpServer) writeDbPoolMetrics(mw *metricsWriter) {
+	stat := srv.ProcessManager.Db.Stat()
+
+	gauges := []struct {
+		name, help string
+		value      int32
+	}{
+		{"procsman_db_pool_max_conns", "Maximum size of the database pool.", stat.MaxConns()},
+		{"procsman_db_pool_total_conns", "Connections currently in the database pool.", stat.TotalConns()},
+		{"procsman_db_pool_acquired_conns", "Connections currently in use.", stat.AcquiredConns()},
+		{"procsman_db_pool_idle_conns", "Idle connections.", stat.IdleConns()},
+		{"procsman_db_pool_constructing_conns", "Connections that are being established.", stat.ConstructingConns()},
+	}
+	for _, g := range gauges {
+		mw.Header(g.name, "gauge", g.help)
+		mw.Sample(g.name, float64(g.value))
+	}
+
+	counters := []struct {
+		name, help string
+		value      int64
+	}{
+		{"procsman_db_pool_acquires_total", "Successful acquires from the database pool.", stat.AcquireCount()},
+		{"procsman_db_pool_empty_acquires_total", "Acquires that had to wait for a connection.", stat.EmptyAcquireCount()},
+		{"procsman_db_pool_canceled_acquires_total", "Acquires canceled by a context.", stat.CanceledAcquireCount()},
+		{"procsman_db_pool_new_conns_total", "Connections opened.", stat.NewConnsCount()},
+	}
+	for _, c := range counters {
+		mw.Header(c.name, "counter", c.help)
+		mw.Sample(c.name, float64(c.value))
+	}
+
+	mw.Header("procsman_db_pool_acquire_duration_seconds_total", "counter", "Total time spent acquiring connections.")
+	mw.Sample("procsman_db_pool_acquire_duration_seconds_total", stat.AcquireDuration().Seconds())
+}
+
+func (srv *HttpServer) writeRequestDurationMetrics(mw *metricsWriter) {
+	rd := &srv.requestDurations
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	keys := make([]requestDurationKey, 0, len(rd.values))
+	for key := range rd.values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	const name = "procsman_http_request_duration_seconds"
+	mw.Header(name, "histogram", "Duration of HTTP requests.")
+	for _, key := range keys {
+		v := rd.values[key]
+		labels := []string{"method", key.method, "route", key.route, "code", strconv.Itoa(key.code)}
+		for i, le := range requestDurationBuckets {
+			mw.Sample(name+"_bucket", float64(v.buckets[i]), append(labels, "le", formatMetricValue(le))...)
+		}
+		mw.Sample(name+"_bucket", float64(v.count), append(labels, "le", "+Inf")...)
+		mw.Sample(name+"_sum", v.sum, labels...)
+		mw.Sample(name+"_count", float64(v.count), labels...)
+	}
+}
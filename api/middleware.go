@@ -2,48 +2,96 @@ package api
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"reflect"
 	"strings"
 )
 
-var ExpectedAuthKeyHash [32]byte
-
 type ContextKey string
 
 const ContextKeyUnmarshalledJson = ContextKey("unmarshalledJson")
 const ContextKeyWrappedRequest = ContextKey("wrappedRequest")
 
-func (srv *HttpServer) AuthMiddleware(next http.Handler) http.Handler {
+// AuthMiddleware authenticates the request and checks that the credential has the required scope.
+func (srv *HttpServer) AuthMiddleware(scope Scope, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authKey := r.Header.Get("X-Auth-Key")
+		rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
 
-		if authKey == "" {
-			http.Error(w, "Missing authorization", http.StatusUnauthorized)
-			return
+		bearer, isSession := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		peer, isPeer := r.Context().Value(ContextKeyPeerCred).(*peerCred)
+		isPeer = isPeer && !isSession && r.Header.Get("X-Auth-Key") == ""
+
+		var cred *Credential
+		if isPeer {
+			cred = srv.authenticatePeer(peer)
 		}
+		// peers accepted by their credentials don't need to be protected from guessing.
+		if cred == nil {
+			if lockedOut := srv.rateLimiter.LockedOut(clientIP(r)); lockedOut > 0 {
+				rw.tooManyRequests(lockedOut, "Too many failed authentications")
+				return
+			}
+		}
+
+		var err error
+		if isSession {
+			cred, err = srv.authenticateSession(r.Context(), bearer)
+		} else if isPeer {
+			// authenticated above.
+		} else if r.Header.Get("X-Auth-Key") == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			cred = srv.authenticateClientCert(r.TLS)
+		} else {
+			authKey := r.Header.Get("X-Auth-Key")
+			if authKey == "" {
+				http.Error(w, "Missing authorization", http.StatusUnauthorized)
+				return
+			}
 
-		authKeyBase64, err := base64.StdEncoding.DecodeString(authKey)
+			authKeyBase64, decodeErr := base64.StdEncoding.DecodeString(authKey)
+			if decodeErr != nil {
+				srv.authFailed(r)
+				http.Error(w, "Invalid authorization", http.StatusUnauthorized)
+				return
+			}
+			cred, err = srv.authenticateKey(r.Context(), authKeyBase64)
+		}
 		if err != nil {
+			srv.Logger.Errorf("Error authenticating request: %v\n", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if cred == nil {
+			srv.authFailed(r)
 			http.Error(w, "Invalid authorization", http.StatusUnauthorized)
 			return
 		}
 
-		authKeyHash := sha256.Sum256(authKeyBase64)
+		if !cred.Scope.Allows(scope) {
+			http.Error(w, "Insufficient scope", http.StatusForbidden)
+			return
+		}
+		allowed, err := srv.authorizeGroups(r, cred)
+		if err != nil {
+			srv.Logger.Errorf("Error authorizing request: %v\n", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Insufficient scope", http.StatusForbidden)
+			return
+		}
 
-		if !hmac.Equal(authKeyHash[:], ExpectedAuthKeyHash[:]) {
-			http.Error(w, "Invalid authorization", http.StatusUnauthorized)
+		if ok, retryAfter := srv.rateLimiter.Allow("cred:"+cred.Actor, srv.routeClass(r)); !ok {
+			rw.tooManyRequests(retryAfter, "Rate limit exceeded")
 			return
 		}
 
+		rw.Actor = cred.Actor
+		rw.Credential = cred
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -171,27 +219,3 @@ func (srv *HttpServer) WrapAccessControl(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-
-func init() {
-	var err error
-
-	ExpectedAuthKeyBase64Bytes, err := os.ReadFile("auth.key")
-
-	if err != nil || len(ExpectedAuthKeyBase64Bytes) == 0 {
-		if err == nil || os.IsNotExist(err) {
-			// Create a new auth key
-			authKeyBytes := make([]byte, 64)
-			_, _ = rand.Read(authKeyBytes)
-			ExpectedAuthKeyBase64Bytes = []byte(base64.StdEncoding.EncodeToString(authKeyBytes))
-			_ = os.WriteFile("auth.key", ExpectedAuthKeyBase64Bytes, 0600)
-
-			fmt.Printf("Generated new auth key: %s\n", base64.StdEncoding.EncodeToString(authKeyBytes))
-		} else {
-			panic(err)
-		}
-	}
-
-	ExpectedAuthKey, err := base64.StdEncoding.DecodeString(string(ExpectedAuthKeyBase64Bytes))
-	ExpectedAuthKeyHash = sha256.Sum256(ExpectedAuthKey)
-
-}
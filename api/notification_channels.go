@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"procsman_backend/procsmanager"
+	"strconv"
+	"strings"
+)
+
+const AuditTargetNotificationChannel = "notification_channel"
+
+// NotificationChannelRequest creates or replaces a notification channel. Its id is ignored, it's taken from the path.
+type NotificationChannelRequest struct {
+	config.ChannelConfig
+}
+
+func (n *NotificationChannelRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if err := validateChannel(&n.ChannelConfig, "channel"); err != nil {
+		return err
+	}
+	if n.Events == nil {
+		n.Events = make([]string, 0)
+	}
+	if n.Templates == nil {
+		n.Templates = make(map[string]string)
+	}
+	return nil
+}
+
+// redactChannel returns a copy of channel without secrets.
+func redactChannel(channel config.ChannelConfig) config.ChannelConfig {
+	return redactChannels([]config.ChannelConfig{channel})[0]
+}
+
+// channelRoutes returns the names of the notification routes that send to the channel.
+func channelRoutes(ctx context.Context, queries *db.Queries, channel string) ([]string, error) {
+	routes, err := queries.GetNotificationRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, route := range routes {
+		for _, target := range route.Targets {
+			if target.Channel == channel {
+				names = append(names, route.Name)
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// getNotificationChannel returns the channel with the id in the path. It responds with an error if there is none.
+func (srv *HttpServer) getNotificationChannel(rw *ReqWrapper, r *http.Request) (config.ChannelConfig, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return config.ChannelConfig{}, false
+	}
+	row, err := srv.ProcessManager.Queries.GetNotificationChannel(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeChannelNotFound, "Channel not found", http.StatusNotFound, "Channel not found")
+			return config.ChannelConfig{}, false
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return config.ChannelConfig{}, false
+	}
+	channel, err := procsmanager.NotificationChannelConfig(row)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return config.ChannelConfig{}, false
+	}
+	return channel, true
+}
+
+// checkChannelName responds with an error if another channel than id is called name.
+func (srv *HttpServer) checkChannelName(rw *ReqWrapper, r *http.Request, name string, id int32) bool {
+	channels, err := srv.ProcessManager.Queries.GetNotificationChannels(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return false
+	}
+	for _, channel := range channels {
+		if channel.Name == name && channel.ID != id {
+			rw.E(MessageCodeInvalidChannel, "Invalid channel", http.StatusBadRequest, fmt.Sprintf("channel name %q is already used", name))
+			return false
+		}
+	}
+	return true
+}
+
+// checkChannelUnused responds with an error if notification routes send to the channel.
+func (srv *HttpServer) checkChannelUnused(rw *ReqWrapper, r *http.Request, channel string) bool {
+	routes, err := channelRoutes(r.Context(), srv.ProcessManager.Queries, channel)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if len(routes) > 0 {
+		rw.E(MessageCodeChannelInUse, "Channel is in use", http.StatusConflict, fmt.Sprintf("Channel %s is used by notification routes: %s", channel, strings.Join(routes, ", ")))
+		return false
+	}
+	return true
+}
+
+// checkRouteTargets responds with an error if a target of a notification route isn't valid with the channels of nc,
+// because its channel is gone or its recipients don't fit the type of the channel anymore.
+func (srv *HttpServer) checkRouteTargets(rw *ReqWrapper, r *http.Request, nc *config.NotificationsConfig) bool {
+	routes, err := srv.ProcessManager.Queries.GetNotificationRoutes(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return false
+	}
+	for _, route := range routes {
+		for _, target := range route.Targets {
+			if err = nc.ValidateTarget(config.Target(target)); err != nil {
+				rw.E(MessageCodeChannelInUse, "Channel is in use", http.StatusConflict, fmt.Sprintf("Notification route %s: %v", route.Name, err))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (srv *HttpServer) GetNotificationChannels(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	rows, err := srv.ProcessManager.Queries.GetNotificationChannels(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	channels := make([]config.ChannelConfig, 0, len(rows))
+	for _, row := range rows {
+		channel, err := procsmanager.NotificationChannelConfig(row)
+		if err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		channels = append(channels, channel)
+	}
+	rw.MarshalAndRespond(channels)
+}
+
+func (srv *HttpServer) CreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*NotificationChannelRequest)
+
+	if !srv.checkChannelName(rw, r, req.Name, 0) {
+		return
+	}
+	params, err := procsmanager.NotificationChannelParams(&req.ChannelConfig)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var channel config.ChannelConfig
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.reloadNotificationsConfig(r.Context())
+		rw.MarshalAndRespond(channel)
+	}()
+
+	row, err := queries.CreateNotificationChannel(r.Context(), params)
+	if err != nil {
+		return
+	}
+	if channel, err = procsmanager.NotificationChannelConfig(row); err != nil {
+		return
+	}
+	rw.Audit(r.Context(), queries, "notification_channel.create", AuditTargetNotificationChannel, channel.ID, nil, redactChannel(channel))
+}
+
+func (srv *HttpServer) UpdateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*NotificationChannelRequest)
+
+	existing, ok := srv.getNotificationChannel(rw, r)
+	if !ok {
+		return
+	}
+	if !srv.checkChannelName(rw, r, req.Name, existing.ID) {
+		return
+	}
+	// routes refer to channels by name and their recipients depend on the type of the channel.
+	current := srv.ProcessManager.Notifications()
+	nc := *current
+	nc.Channels = make([]config.ChannelConfig, 0, len(current.Channels)+1)
+	for _, channel := range current.Channels {
+		if channel.ID != existing.ID {
+			nc.Channels = append(nc.Channels, channel)
+		}
+	}
+	nc.Channels = append(nc.Channels, req.ChannelConfig)
+	if !srv.checkRouteTargets(rw, r, &nc) {
+		return
+	}
+	params, err := procsmanager.NotificationChannelParams(&req.ChannelConfig)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var channel config.ChannelConfig
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.reloadNotificationsConfig(r.Context())
+		rw.MarshalAndRespond(channel)
+	}()
+
+	row, err := queries.UpdateNotificationChannel(r.Context(), db.UpdateNotificationChannelParams{
+		ID:        existing.ID,
+		Name:      params.Name,
+		Type:      params.Type,
+		Enabled:   params.Enabled,
+		Events:    params.Events,
+		Templates: params.Templates,
+		Settings:  params.Settings,
+	})
+	if err != nil {
+		return
+	}
+	if channel, err = procsmanager.NotificationChannelConfig(row); err != nil {
+		return
+	}
+	rw.Audit(r.Context(), queries, "notification_channel.update", AuditTargetNotificationChannel, channel.ID, redactChannel(existing), redactChannel(channel))
+}
+
+func (srv *HttpServer) DeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	existing, ok := srv.getNotificationChannel(rw, r)
+	if !ok {
+		return
+	}
+	if !srv.checkChannelUnused(rw, r, existing.Name) {
+		return
+	}
+	if err := srv.ProcessManager.Queries.DeleteNotificationChannel(r.Context(), existing.ID); err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.reloadNotificationsConfig(r.Context())
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "notification_channel.delete", AuditTargetNotificationChannel, existing.ID, redactChannel(existing), nil)
+	w.WriteHeader(http.StatusOK)
+}
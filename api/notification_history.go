@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"net/http"
+	"procsman_backend/db"
+	"procsman_backend/procsmanager"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultNotificationHistoryLimit = 100
+	MaxNotificationHistoryLimit     = 1000
+)
+
+// NotificationAttemptInfo is the result of an attempt to deliver a notification to a recipient.
+type NotificationAttemptInfo struct {
+	Attempt   int32       `json:"attempt"`
+	Time      int64       `json:"time"`
+	Success   bool        `json:"success"`
+	Recipient string      `json:"recipient"`
+	ChatID    pgtype.Int8 `json:"chat_id"`
+	MessageID pgtype.Int4 `json:"message_id"`
+	Error     string      `json:"error"`
+}
+
+// NotificationHistoryEntry is a notification delivered, or to be delivered, to a channel.
+type NotificationHistoryEntry struct {
+	ID        int32       `json:"id"`
+	Time      int64       `json:"time"`
+	ProcessID pgtype.Int4 `json:"process_id"`
+	Event     string      `json:"event"`
+	Channel   string      `json:"channel"`
+	// Recipients are the ones left to deliver to, empty means all recipients of the channel.
+	Recipients []string `json:"recipients"`
+	// Status is pending, sent or failed.
+	Status   string `json:"status"`
+	Attempts int32  `json:"attempts"`
+	// NextAttemptAt is only set for pending notifications, FinishedAt for the others.
+	NextAttemptAt pgtype.Int8               `json:"next_attempt_at"`
+	FinishedAt    pgtype.Int8               `json:"finished_at"`
+	Notification  json.RawMessage           `json:"notification"`
+	Results       []NotificationAttemptInfo `json:"results"`
+}
+
+type NotificationHistoryResponse struct {
+	Entries []NotificationHistoryEntry `json:"entries"`
+	// NextCursor is passed as cursor to get the next (older) page. It's null on the last page.
+	NextCursor pgtype.Int4 `json:"next_cursor"`
+}
+
+// GetNotificationHistory returns notifications from the outbox with their delivery attempts, newest first,
+// filtered by process_id, channel, status and from/to.
+func (srv *HttpServer) GetNotificationHistory(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	query := r.URL.Query()
+
+	from, to, tfErr := parseTimeFrame(r, time.Unix(0, 0).UTC(), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+
+	optionalText := func(name string) pgtype.Text {
+		v := query.Get(name)
+		return pgtype.Text{String: v, Valid: v != ""}
+	}
+	params := db.GetNotificationHistoryParams{
+		Channel:   optionalText("channel"),
+		Status:    optionalText("status"),
+		RangeFrom: pgtype.Timestamp{Time: from.UTC(), Valid: true},
+		RangeTo:   pgtype.Timestamp{Time: to.UTC(), Valid: true},
+	}
+	if params.Status.Valid && !slices.Contains([]string{procsmanager.OutboxStatusPending, procsmanager.OutboxStatusSent, procsmanager.OutboxStatusFailed}, params.Status.String) {
+		rw.E(MessageCodeInvalidStatus, "Invalid status", http.StatusBadRequest, "status must be pending, sent or failed")
+		return
+	}
+
+	if query.Get("process_id") != "" {
+		processID, err := strconv.Atoi(query.Get("process_id"))
+		if err != nil {
+			rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Could not convert process_id to int")
+			return
+		}
+		params.ProcessID = pgtype.Int4{Int32: int32(processID), Valid: true}
+	}
+
+	if query.Get("cursor") != "" {
+		cursor, err := strconv.Atoi(query.Get("cursor"))
+		if err != nil {
+			rw.E(MessageCodeInvalidCursor, "Invalid cursor", http.StatusBadRequest, "Could not convert cursor to int")
+			return
+		}
+		params.BeforeID = pgtype.Int4{Int32: int32(cursor), Valid: true}
+	}
+
+	limit := DefaultNotificationHistoryLimit
+	if query.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 || limit > MaxNotificationHistoryLimit {
+			rw.E(MessageCodeInvalidLimit, "Invalid limit", http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxNotificationHistoryLimit))
+			return
+		}
+	}
+	// one extra row tells whether there is a next page.
+	params.RowLimit = int32(limit + 1)
+
+	entries, err := srv.ProcessManager.Queries.GetNotificationHistory(r.Context(), params)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	res := NotificationHistoryResponse{Entries: make([]NotificationHistoryEntry, 0, len(entries))}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		res.NextCursor = pgtype.Int4{Int32: entries[limit-1].ID, Valid: true}
+	}
+
+	ids := make([]int32, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	attempts, err := srv.ProcessManager.Queries.GetNotificationAttempts(r.Context(), ids)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	results := make(map[int32][]NotificationAttemptInfo, len(entries))
+	for _, a := range attempts {
+		results[a.OutboxID] = append(results[a.OutboxID], NotificationAttemptInfo{
+			Attempt:   a.Attempt,
+			Time:      a.CreatedAt.Time.Unix(),
+			Success:   a.Success,
+			Recipient: a.Recipient,
+			ChatID:    a.ChatID,
+			MessageID: a.MessageID,
+			Error:     a.Error,
+		})
+	}
+
+	unix := func(t pgtype.Timestamp) pgtype.Int8 {
+		return pgtype.Int8{Int64: t.Time.Unix(), Valid: t.Valid}
+	}
+	for _, e := range entries {
+		entry := NotificationHistoryEntry{
+			ID:           e.ID,
+			Time:         e.CreatedAt.Time.Unix(),
+			ProcessID:    e.ProcessID,
+			Event:        e.Event,
+			Channel:      e.Channel,
+			Recipients:   e.Recipients,
+			Status:       e.Status,
+			Attempts:     e.Attempts,
+			FinishedAt:   unix(e.FinishedAt),
+			Notification: e.Notification,
+			Results:      results[e.ID],
+		}
+		if e.Status == procsmanager.OutboxStatusPending {
+			entry.NextAttemptAt = unix(e.NextAttemptAt)
+		}
+		if entry.Results == nil {
+			entry.Results = make([]NotificationAttemptInfo, 0)
+		}
+		res.Entries = append(res.Entries, entry)
+	}
+	rw.MarshalAndRespond(res)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+	"strconv"
+)
+
+const AuditTargetNotificationRoute = "notification_route"
+
+// NotificationRouteRequest creates or replaces a notification route.
+type NotificationRouteRequest struct {
+	Name     string `json:"name"`
+	Position int32  `json:"position"`
+	// IsDefault makes this the route used when no other route matches. The previous default route stops being one.
+	IsDefault  bool     `json:"is_default"`
+	ProcessIDs []int32  `json:"process_ids"`
+	GroupIDs   []int32  `json:"group_ids"`
+	Events     []string `json:"events"`
+	// Targets are the channels to send to. Empty drops matching notifications.
+	Targets          db.NotificationTargets `json:"targets"`
+	ContinueMatching bool                   `json:"continue_matching"`
+}
+
+func (n *NotificationRouteRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if n.Name == "" {
+		return MakeE(MessageCodeNameRequired, "name is required", http.StatusBadRequest, "name is required")
+	}
+	if n.IsDefault && (len(n.ProcessIDs) > 0 || len(n.GroupIDs) > 0 || len(n.Events) > 0) {
+		return MakeE(MessageCodeInvalidRoute, "Invalid route", http.StatusBadRequest, "the default route can't have process_ids, group_ids or events")
+	}
+	for _, processID := range n.ProcessIDs {
+		if _, err := srv.ProcessManager.Queries.GetProcess(ctx, processID); err != nil {
+			return MakeE(MessageCodeProcessNotFound, "Process not found", http.StatusBadRequest, "process "+strconv.Itoa(int(processID))+" does not exist")
+		}
+	}
+	for _, groupID := range n.GroupIDs {
+		if _, err := srv.ProcessManager.Queries.GetProcessGroup(ctx, groupID); err != nil {
+			return MakeE(MessageCodeInvalidGroup, "invalid group", http.StatusBadRequest, "group "+strconv.Itoa(int(groupID))+" does not exist")
+		}
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(knownEventTypes, db.ProcessEventType(event)) {
+			return MakeE(MessageCodeInvalidEventType, "Invalid event type", http.StatusBadRequest, fmt.Sprintf("Unknown event type %q", event))
+		}
+	}
+	for _, target := range n.Targets {
+		if err := srv.ProcessManager.Notifications().ValidateTarget(config.Target(target)); err != nil {
+			return MakeE(MessageCodeInvalidRoute, "Invalid route", http.StatusBadRequest, err.Error())
+		}
+	}
+
+	if n.ProcessIDs == nil {
+		n.ProcessIDs = make([]int32, 0)
+	}
+	if n.GroupIDs == nil {
+		n.GroupIDs = make([]int32, 0)
+	}
+	if n.Events == nil {
+		n.Events = make([]string, 0)
+	}
+	if n.Targets == nil {
+		n.Targets = make(db.NotificationTargets, 0)
+	}
+	return nil
+}
+
+func (srv *HttpServer) GetNotificationRoutes(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	routes, err := srv.ProcessManager.Queries.GetNotificationRoutes(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.MarshalAndRespond(routes)
+}
+
+// reloadNotificationRoutes makes the process manager use the routes in the database.
+func (srv *HttpServer) reloadNotificationRoutes(ctx context.Context) {
+	if err := srv.ProcessManager.LoadNotificationRoutes(ctx); err != nil {
+		srv.Logger.Errorf("Error reloading notification routes: %v\n", err)
+	}
+}
+
+func (srv *HttpServer) CreateNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*NotificationRouteRequest)
+
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var route db.NotificationRoute
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.reloadNotificationRoutes(r.Context())
+		rw.MarshalAndRespond(route)
+	}()
+
+	if req.IsDefault {
+		if err = queries.ClearDefaultNotificationRoute(r.Context(), 0); err != nil {
+			return
+		}
+	}
+	route, err = queries.CreateNotificationRoute(r.Context(), db.CreateNotificationRouteParams{
+		Name:             req.Name,
+		Position:         req.Position,
+		IsDefault:        req.IsDefault,
+		ProcessIds:       req.ProcessIDs,
+		GroupIds:         req.GroupIDs,
+		Events:           req.Events,
+		Targets:          req.Targets,
+		ContinueMatching: req.ContinueMatching,
+	})
+	if err != nil {
+		return
+	}
+	rw.Audit(r.Context(), queries, "notification_route.create", AuditTargetNotificationRoute, route.ID, nil, route)
+}
+
+func (srv *HttpServer) UpdateNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*NotificationRouteRequest)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return
+	}
+	existing, err := srv.ProcessManager.Queries.GetNotificationRoute(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeRouteNotFound, "Route not found", http.StatusNotFound, "Route not found")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var route db.NotificationRoute
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.reloadNotificationRoutes(r.Context())
+		rw.MarshalAndRespond(route)
+	}()
+
+	if req.IsDefault {
+		if err = queries.ClearDefaultNotificationRoute(r.Context(), existing.ID); err != nil {
+			return
+		}
+	}
+	route, err = queries.UpdateNotificationRoute(r.Context(), db.UpdateNotificationRouteParams{
+		ID:               existing.ID,
+		Name:             req.Name,
+		Position:         req.Position,
+		IsDefault:        req.IsDefault,
+		ProcessIds:       req.ProcessIDs,
+		GroupIds:         req.GroupIDs,
+		Events:           req.Events,
+		Targets:          req.Targets,
+		ContinueMatching: req.ContinueMatching,
+	})
+	if err != nil {
+		return
+	}
+	rw.Audit(r.Context(), queries, "notification_route.update", AuditTargetNotificationRoute, route.ID, existing, route)
+}
+
+func (srv *HttpServer) DeleteNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return
+	}
+	existing, err := srv.ProcessManager.Queries.GetNotificationRoute(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeRouteNotFound, "Route not found", http.StatusNotFound, "Route not found")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err = srv.ProcessManager.Queries.DeleteNotificationRoute(r.Context(), existing.ID); err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	srv.reloadNotificationRoutes(r.Context())
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "notification_route.delete", AuditTargetNotificationRoute, existing.ID, existing, nil)
+	w.WriteHeader(http.StatusOK)
+}
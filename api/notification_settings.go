@@ -2,14 +2,23 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"net/http"
 	"procsman_backend/config"
+	"procsman_backend/db"
+	"procsman_backend/procsmanager"
+	"slices"
+	"time"
 )
 
 func (srv *HttpServer) GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
 	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
 
-	rw.MarshalAndRespondWithStatus(srv.ProcessManager.Notifications, http.StatusOK)
+	rw.MarshalAndRespondWithStatus(srv.ProcessManager.Notifications(), http.StatusOK)
 }
 
 type PatchNotificationsConfig struct {
@@ -17,42 +26,307 @@ type PatchNotificationsConfig struct {
 	TelegramBotToken      string  `json:"telegram_bot_token"`
 	TelegramTargetChatIDS []int64 `json:"telegram_target_chat_ids"`
 	// maybe save names of chats?
+	// Channels replaces the configured channels. If it's omitted, they are kept.
+	Channels []config.ChannelConfig `json:"channels"`
+	// Templates replaces the global templates. If it's omitted, they are kept.
+	Templates map[string]string `json:"templates"`
+	// TelegramApiUrl is the Bot API server of TelegramBotToken, the default one if it's empty.
+	TelegramApiUrl string `json:"telegram_api_url"`
+	// TelegramCommands replaces the bot command settings. If it's omitted, they are kept.
+	TelegramCommands *config.TelegramCommandsConfig `json:"telegram_commands"`
 }
 
 func (nc *PatchNotificationsConfig) Validate(ctx context.Context, srv *HttpServer) *Error {
-	return nil
+	if err := config.ValidateTelegramApiUrl(nc.TelegramApiUrl); err != nil {
+		return MakeE(MessageCodeInvalidFormat, "Invalid telegram api url", http.StatusBadRequest, "telegram_"+err.Error())
+	}
+	if nc.TelegramCommands != nil {
+		if err := nc.TelegramCommands.Validate(); err != nil {
+			return MakeE(MessageCodeInvalidFormat, "Invalid telegram commands", http.StatusBadRequest, err.Error())
+		}
+		if nc.TelegramCommands.Enabled && nc.TelegramBotToken == "" {
+			return MakeE(MessageCodeInvalidFormat, "Invalid telegram commands", http.StatusBadRequest, "telegram_bot_token is required to enable commands")
+		}
+	}
+	names := make(map[string]bool)
+	for i := range nc.Channels {
+		if err := validateChannel(&nc.Channels[i], fmt.Sprintf("channels[%d]", i)); err != nil {
+			return err
+		}
+		if names[nc.Channels[i].Name] {
+			return MakeE(MessageCodeInvalidChannel, "Invalid channel", http.StatusBadRequest, fmt.Sprintf("channel name %q is already used", nc.Channels[i].Name))
+		}
+		names[nc.Channels[i].Name] = true
+	}
+	return validateNotificationTemplates("templates", nc.Templates)
+}
+
+// validateChannel validates the settings, events and templates of a channel. field names it in errors.
+func validateChannel(channel *config.ChannelConfig, field string) *Error {
+	if err := channel.Validate(); err != nil {
+		return MakeE(MessageCodeInvalidChannel, "Invalid channel", http.StatusBadRequest, err.Error())
+	}
+	// "telegram" is the channel of TelegramBotToken.
+	if channel.Name == "telegram" {
+		return MakeE(MessageCodeInvalidChannel, "Invalid channel", http.StatusBadRequest, `channel name "telegram" is reserved`)
+	}
+	for _, event := range channel.Events {
+		if !slices.Contains(knownEventTypes, db.ProcessEventType(event)) {
+			return MakeE(MessageCodeInvalidEventType, "Invalid event type", http.StatusBadRequest, fmt.Sprintf("Unknown event type %q in channel %s", event, channel.Name))
+		}
+	}
+	return validateNotificationTemplates(field+".templates", channel.Templates)
+}
+
+// redactSecret replaces a secret with a short hash of it, so audit entries show that it changed, but not its value.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return fmt.Sprintf("redacted:%x", sha256.Sum256([]byte(secret)))[:17]
+}
+
+// redactChannels returns a copy of channels without secrets.
+func redactChannels(channels []config.ChannelConfig) []config.ChannelConfig {
+	redacted := make([]config.ChannelConfig, len(channels))
+	for i, channel := range channels {
+		if channel.Telegram != nil {
+			telegram := *channel.Telegram
+			telegram.BotToken = redactSecret(telegram.BotToken)
+			channel.Telegram = &telegram
+		}
+		if channel.Webhook != nil {
+			webhook := *channel.Webhook
+			webhook.Secret = redactSecret(webhook.Secret)
+			webhook.Headers = make(map[string]string, len(channel.Webhook.Headers))
+			for name, value := range channel.Webhook.Headers {
+				webhook.Headers[name] = redactSecret(value)
+			}
+			channel.Webhook = &webhook
+		}
+		if channel.ChatWebhook != nil {
+			// incoming webhook urls contain the token.
+			chatWebhook := *channel.ChatWebhook
+			chatWebhook.URL = redactSecret(chatWebhook.URL)
+			channel.ChatWebhook = &chatWebhook
+		}
+		if channel.Email != nil {
+			email := *channel.Email
+			email.Password = redactSecret(email.Password)
+			channel.Email = &email
+		}
+		redacted[i] = channel
+	}
+	return redacted
+}
+
+// reloadNotificationsConfig makes the process manager use the notification settings and channels in the database.
+func (srv *HttpServer) reloadNotificationsConfig(ctx context.Context) {
+	if err := srv.ProcessManager.LoadNotificationsConfig(ctx); err != nil {
+		srv.Logger.Errorf("Error reloading notification settings: %v\n", err)
+	}
 }
 
 func (srv *HttpServer) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
 	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
 	req := r.Context().Value(ContextKeyUnmarshalledJson).(*PatchNotificationsConfig)
 
-	srv.ProcessManager.Notifications.Enabled = req.Enabled
-	srv.ProcessManager.Notifications.TelegramBotToken = req.TelegramBotToken
-	srv.ProcessManager.Notifications.TelegramTargetChatIDS = req.TelegramTargetChatIDS
+	// secrets are only recorded as changed, not their values.
+	redacted := func(nc PatchNotificationsConfig) PatchNotificationsConfig {
+		nc.TelegramBotToken = redactSecret(nc.TelegramBotToken)
+		nc.Channels = redactChannels(nc.Channels)
+		return nc
+	}
+	current := srv.ProcessManager.Notifications()
+	before := redacted(PatchNotificationsConfig{
+		Enabled:               current.Enabled,
+		TelegramBotToken:      current.TelegramBotToken,
+		TelegramTargetChatIDS: current.TelegramTargetChatIDS,
+		Channels:              current.Channels,
+		Templates:             current.Templates,
+		TelegramApiUrl:        current.TelegramApiUrl,
+		TelegramCommands:      current.TelegramCommands,
+	})
+
+	replaceChannels := req.Channels != nil
+	if replaceChannels {
+		// the channels used by routes must be kept, with a type that fits their recipients.
+		nc := *current
+		nc.Channels = req.Channels
+		if !srv.checkRouteTargets(rw, r, &nc) {
+			return
+		}
+	} else {
+		req.Channels = current.Channels
+	}
+	if req.Templates == nil {
+		req.Templates = current.Templates
+	}
+	if req.TelegramCommands == nil {
+		req.TelegramCommands = current.TelegramCommands
+	}
+	settings := current.NotificationSettings
+	settings.Enabled = req.Enabled
+	settings.TelegramBotToken = req.TelegramBotToken
+	settings.TelegramTargetChatIDS = req.TelegramTargetChatIDS
+	settings.Templates = req.Templates
+	settings.TelegramApiUrl = req.TelegramApiUrl
+	settings.TelegramCommands = req.TelegramCommands
+	settingsJson, err := json.Marshal(settings)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	if err := srv.ProcessManager.Notifications.Save(); err != nil {
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 		return
 	}
-	rw.MarshalAndRespondWithStatus(srv.ProcessManager.Notifications, http.StatusOK)
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		srv.reloadNotificationsConfig(r.Context())
+		rw.MarshalAndRespondWithStatus(srv.ProcessManager.Notifications(), http.StatusOK)
+	}()
+
+	if err = queries.UpdateNotificationSettings(r.Context(), settingsJson); err != nil {
+		return
+	}
+	if replaceChannels {
+		if err = queries.DeleteAllNotificationChannels(r.Context()); err != nil {
+			return
+		}
+		for i := range req.Channels {
+			var params db.CreateNotificationChannelParams
+			if params, err = procsmanager.NotificationChannelParams(&req.Channels[i]); err != nil {
+				return
+			}
+			if _, err = queries.CreateNotificationChannel(r.Context(), params); err != nil {
+				return
+			}
+		}
+	}
+	rw.Audit(r.Context(), queries, "notification_config.update", AuditTargetNotifications, 0, before, redacted(*req))
 }
 
 type TestMessage struct {
-	SendEverywhere bool   `json:"send_everywhere"`
-	SendToChatId   int64  `json:"send_to_chat_id"`
-	Text           string `json:"text"`
+	SendEverywhere bool  `json:"send_everywhere"`
+	SendToChatId   int64 `json:"send_to_chat_id"`
+	// SendToChannel sends the message to a single channel by name, instead of a telegram chat.
+	SendToChannel string `json:"send_to_channel"`
+	Text          string `json:"text"`
+}
+
+func (t *TestMessage) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if t.Text == "" {
+		return MakeE(MessageCodeTextRequired, "text is required", http.StatusBadRequest, "text is required")
+	}
+	if !t.SendEverywhere && t.SendToChannel == "" && t.SendToChatId == 0 {
+		return MakeE(MessageCodeInvalidChannel, "No destination", http.StatusBadRequest, "one of send_everywhere, send_to_channel or send_to_chat_id is required")
+	}
+	return nil
 }
 
+// TestNotification sends a message right away, bypassing routes, the dispatcher and the outbox.
 func (srv *HttpServer) TestNotification(w http.ResponseWriter, r *http.Request) {
 	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
 	req := r.Context().Value(ContextKeyUnmarshalledJson).(*TestMessage)
 
+	notifications := srv.ProcessManager.Notifications()
 	var res []config.SendResult
 	if req.SendEverywhere {
-		res = srv.ProcessManager.Notifications.SendMessage(req.Text)
+		res = notifications.SendMessage(req.Text)
+	} else if req.SendToChannel != "" {
+		notifier := notifications.Notifier(req.SendToChannel)
+		if notifier == nil {
+			rw.E(MessageCodeInvalidChannel, "Channel not found", http.StatusNotFound, fmt.Sprintf("There is no enabled channel %q", req.SendToChannel))
+			return
+		}
+		res = notifier.Send(&config.Notification{Text: req.Text, Time: time.Now().Unix()})
 	} else {
-		res = []config.SendResult{srv.ProcessManager.Notifications.SendTelegramMessage(req.Text, req.SendToChatId)}
+		res = []config.SendResult{notifications.SendTelegramMessage(req.Text, req.SendToChatId)}
+	}
+	if res == nil {
+		res = make([]config.SendResult, 0)
 	}
 	rw.MarshalAndRespondWithStatus(res, http.StatusOK)
 }
+
+type PreviewTemplateRequest struct {
+	// Template is rendered as is. If it's empty, the template that would be used for the event is rendered.
+	Template string `json:"template"`
+	Event    string `json:"event"`
+	// EventID renders a real event instead of a sample one, Event and ProcessID are taken from it.
+	EventID int32 `json:"event_id"`
+	// ProcessID fills in the sample event with the process, its group and templates.
+	ProcessID int32 `json:"process_id"`
+	// Channel applies the templates of a channel.
+	Channel string `json:"channel"`
+
+	event *db.ProcessEvent
+}
+
+func (p *PreviewTemplateRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if p.EventID != 0 {
+		event, err := srv.ProcessManager.Queries.GetProcessEvent(ctx, p.EventID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return MakeE(MessageCodeInvalidId, "Event not found", http.StatusNotFound, fmt.Sprintf("There is no event %d", p.EventID))
+			}
+			return MakeE(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		}
+		p.event = &event
+		p.Event = string(event.Event)
+		if event.ProcessID.Valid {
+			p.ProcessID = event.ProcessID.Int32
+		}
+	}
+	if !slices.Contains(knownEventTypes, db.ProcessEventType(p.Event)) {
+		return MakeE(MessageCodeInvalidEventType, "Invalid event type", http.StatusBadRequest, fmt.Sprintf("Unknown event type %q", p.Event))
+	}
+	if p.Template != "" {
+		if _, err := config.ParseNotificationTemplate(p.Template); err != nil {
+			return MakeE(MessageCodeInvalidTemplate, "Invalid template", http.StatusBadRequest, err.Error())
+		}
+	}
+	return nil
+}
+
+type PreviewTemplateResponse struct {
+	Text string `json:"text"`
+}
+
+// PreviewTemplate renders a template against a sample or a real event, without sending anything.
+func (srv *HttpServer) PreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*PreviewTemplateRequest)
+
+	n := config.SampleNotification(req.Event)
+	if req.ProcessID != 0 {
+		runner := srv.ProcessManager.GetRunner(req.ProcessID)
+		if runner == nil {
+			rw.E(MessageCodeProcessNotFound, "Process not found", http.StatusNotFound, fmt.Sprintf("There is no process %d", req.ProcessID))
+			return
+		}
+		n = runner.NewNotification(db.ProcessEventType(req.Event), n.AdditionalInfo, n.Text)
+	}
+	if req.event != nil {
+		n.AdditionalInfo = req.event.AdditionalInfo
+		n.Time = req.event.CreatedAt.Time.Unix()
+	}
+
+	text, err := srv.ProcessManager.Notifications().Preview(req.Template, n, req.Channel)
+	if err != nil {
+		rw.E(MessageCodeInvalidTemplate, "Invalid template", http.StatusBadRequest, err.Error())
+		return
+	}
+	rw.MarshalAndRespondWithStatus(PreviewTemplateResponse{Text: text}, http.StatusOK)
+}
@@ -14,6 +14,7 @@ import (
 	"procsman_backend/db"
 	"procsman_backend/procsmanager"
 	"runtime"
+	"slices"
 	"strconv"
 )
 
@@ -32,6 +33,9 @@ func (srv *HttpServer) GetProcesses(w http.ResponseWriter, r *http.Request) {
 	if processes == nil {
 		processes = make([]db.Process, 0)
 	}
+	processes = slices.DeleteFunc(processes, func(p db.Process) bool {
+		return !rw.Credential.AllowsGroup(p.ProcessGroupID)
+	})
 
 	rw.MarshalAndRespond(GetProcessesResponse{
 		Processes: processes,
@@ -111,6 +115,24 @@ func CheckPath(source string) (string, *Error) {
 	return fullPath, nil
 }
 
+func validateThresholdRules(rules []db.ThresholdRule) *Error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			details := fmt.Sprintf("threshold_rules[%d]: %v", i, err)
+			return MakeE(MessageCodeInvalidThresholdRule, "invalid threshold rule", http.StatusBadRequest, details)
+		}
+	}
+	return nil
+}
+
+func validateCrashOutputLines(cfg db.Configuration) *Error {
+	if cfg.CrashOutputLines.Valid && (cfg.CrashOutputLines.Int32 < 0 || cfg.CrashOutputLines.Int32 > db.MaxCrashOutputLines) {
+		details := fmt.Sprintf("config.crash_output_lines must be between 0 and %d", db.MaxCrashOutputLines)
+		return MakeE(MessageCodeInvalidLimit, "invalid crash_output_lines", http.StatusBadRequest, details)
+	}
+	return nil
+}
+
 func (a *AddProcessRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
 	if a.Name == "" {
 		return MakeE(MessageCodeNameRequired, "name is required", http.StatusBadRequest, "name is required")
@@ -152,6 +174,16 @@ func (a *AddProcessRequest) Validate(ctx context.Context, srv *HttpServer) *Erro
 		a.Environment = make(map[string]string)
 	}
 
+	if validateErr := validateThresholdRules(a.Config.ThresholdRules); validateErr != nil {
+		return validateErr
+	}
+	if validateErr := validateCrashOutputLines(a.Config); validateErr != nil {
+		return validateErr
+	}
+	if validateErr := validateNotificationTemplates("config.notification_templates", a.Config.NotificationTemplates); validateErr != nil {
+		return validateErr
+	}
+
 	//if a.Color == nil {
 	//	a.Color = &db.Color{}
 	//}
@@ -215,6 +247,7 @@ func (srv *HttpServer) AddProcess(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeCouldNotCreateProcess, "Could not create process", http.StatusInternalServerError, err.Error())
 		return
 	}
+	rw.Audit(r.Context(), queries, "process.create", AuditTargetProcess, created.ID, nil, created)
 
 	go srv.ProcessManager.AddRunner(&created).Work()
 	rw.MarshalAndRespond(created)
@@ -234,7 +267,7 @@ func (srv *HttpServer) DeleteProcess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = srv.ProcessManager.Queries.GetProcess(r.Context(), int32(idInt))
+	process, err := srv.ProcessManager.Queries.GetProcess(r.Context(), int32(idInt))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			rw.E(MessageCodeProcessNotFound, "Process not found", http.StatusNotFound, "Process not found")
@@ -243,9 +276,10 @@ func (srv *HttpServer) DeleteProcess(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeErrorGettingProcess, "Error getting process", http.StatusInternalServerError, err.Error())
 		return
 	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "process.delete", AuditTargetProcess, process.ID, process, nil)
 
 	runner := srv.ProcessManager.GetRunner(int32(idInt))
-	runner.SignalIn <- procsmanager.Deleted
+	runner.SendSignal(procsmanager.Deleted, pgtype.Int4{})
 
 	err = srv.ProcessManager.Queries.DeleteProcess(r.Context(), int32(idInt))
 	if err != nil {
@@ -295,11 +329,14 @@ func (srv *HttpServer) startStopProcess(w http.ResponseWriter, r *http.Request,
 
 	switch what {
 	case Start:
-		runner.SignalIn <- procsmanager.Start
+		auditID := rw.Audit(r.Context(), srv.ProcessManager.Queries, "process.start", AuditTargetProcess, int32(idInt), nil, nil)
+		runner.SendSignal(procsmanager.Start, auditID)
 	case Stop:
-		runner.SignalIn <- procsmanager.Stop
+		auditID := rw.Audit(r.Context(), srv.ProcessManager.Queries, "process.stop", AuditTargetProcess, int32(idInt), nil, nil)
+		runner.SendSignal(procsmanager.Stop, auditID)
 	case Restart:
-		runner.SignalIn <- procsmanager.Restart
+		auditID := rw.Audit(r.Context(), srv.ProcessManager.Queries, "process.restart", AuditTargetProcess, int32(idInt), nil, nil)
+		runner.SendSignal(procsmanager.Restart, auditID)
 	}
 	rw.MarshalAndRespond(ProcessStatusChange{Enabled: what == Start || what == Restart})
 }
@@ -363,6 +400,16 @@ func (u *UpdateProcessRequest) Validate(ctx context.Context, srv *HttpServer) *E
 		u.WorkingDir = filepath.Dir(realPath)
 	}
 
+	// a group-restricted credential can't move the process out of its groups.
+	if cred := ctx.Value(ContextKeyWrappedRequest).(*ReqWrapper).Credential; cred.GroupIDs != nil {
+		if u.CreateNewGroup {
+			return MakeE(MessageCodeInvalidGroup, "invalid group", http.StatusForbidden, "create_new_group is not allowed for group-restricted credentials")
+		}
+		if !cred.AllowsGroup(u.Group) {
+			return MakeE(MessageCodeInvalidGroup, "invalid group", http.StatusForbidden, "group_id must be one of the groups of the credential")
+		}
+	}
+
 	if u.Group.Valid {
 		group, err := srv.ProcessManager.Queries.GetProcessGroup(ctx, u.Group.Int32)
 		if err != nil {
@@ -380,6 +427,16 @@ func (u *UpdateProcessRequest) Validate(ctx context.Context, srv *HttpServer) *E
 		u.Environment = make(map[string]string)
 	}
 
+	if validateErr := validateThresholdRules(u.Config.ThresholdRules); validateErr != nil {
+		return validateErr
+	}
+	if validateErr := validateCrashOutputLines(u.Config); validateErr != nil {
+		return validateErr
+	}
+	if validateErr := validateNotificationTemplates("config.notification_templates", u.Config.NotificationTemplates); validateErr != nil {
+		return validateErr
+	}
+
 	//if u.Color == nil {
 	//	u.Color = &db.Color{}
 	//}
@@ -487,9 +544,12 @@ func (srv *HttpServer) UpdateProcess(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeCouldNotCreateProcess, "Could not edit process", http.StatusInternalServerError, err.Error())
 		return
 	}
+	auditID := rw.Audit(r.Context(), queries, "process.update", AuditTargetProcess, process.ID, existingProcess, process)
 	runner := srv.ProcessManager.GetRunner(int32(idInt))
 	if needsRestart {
-		runner.SignalIn <- procsmanager.Refresh
+		runner.SendSignal(procsmanager.Refresh, auditID)
+	} else {
+		runner.SendSignal(procsmanager.Reload, auditID)
 	}
 
 	rw.MarshalAndRespond(process)
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"procsman_backend/config"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type RouteClass string
+
+const (
+	RouteClassRead   RouteClass = "read"
+	RouteClassWrite  RouteClass = "write"
+	RouteClassExport RouteClass = "export"
+)
+
+// rateLimitPruneInterval is how often full (idle) buckets and expired lockouts are dropped.
+const rateLimitPruneInterval = time.Minute
+
+// routeClass returns the rate limit class of the route that handles r.
+func (srv *HttpServer) routeClass(r *http.Request) RouteClass {
+	if strings.Contains(srv.routePattern(r), "export_logs") {
+		return RouteClassExport
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return RouteClassRead
+	}
+	return RouteClassWrite
+}
+
+// clientIP returns the IP of the request. Requests on the unix socket are keyed by the uid of the peer,
+// so one local user can't lock out the others.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || host == "" {
+		if peer, ok := r.Context().Value(ContextKeyPeerCred).(*peerCred); ok {
+			return "unix:uid:" + strconv.FormatUint(uint64(peer.Uid), 10)
+		}
+		return "unix"
+	}
+	return host
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// BucketState is a bucket as shown by GetRateLimits.
+type BucketState struct {
+	Key    string     `json:"key"`
+	Class  RouteClass `json:"class"`
+	Tokens float64    `json:"tokens"`
+}
+
+type bucketKey struct {
+	key   string
+	class RouteClass
+}
+
+type failureState struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// LockoutState is an IP with failed authentications as shown by GetRateLimits.
+type LockoutState struct {
+	IP          string `json:"ip"`
+	Failures    int    `json:"failures"`
+	LockedUntil int64  `json:"locked_until,omitempty"`
+}
+
+// rateLimiter keeps a token bucket per key (an IP or a credential) and route class, and counts authentication failures per IP.
+type rateLimiter struct {
+	cfg *config.RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	failures  map[string]*failureState
+	lastPrune time.Time
+}
+
+func newRateLimiter(cfg *config.RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		cfg:       cfg,
+		buckets:   make(map[bucketKey]*tokenBucket),
+		failures:  make(map[string]*failureState),
+		lastPrune: time.Now(),
+	}
+}
+
+func (rl *rateLimiter) limit(class RouteClass) config.RateLimit {
+	switch class {
+	case RouteClassExport:
+		return rl.cfg.Export
+	case RouteClassWrite:
+		return rl.cfg.Write
+	}
+	return rl.cfg.Read
+}
+
+// refill adds the tokens accumulated since the bucket was last used. rl.mu must be held.
+func (rl *rateLimiter) refill(b *tokenBucket, limit config.RateLimit, now time.Time) {
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+}
+
+// prune drops buckets that are full again and failures that no longer matter. rl.mu must be held.
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	rl.lastPrune = now
+	for key, b := range rl.buckets {
+		limit := rl.limit(key.class)
+		rl.refill(b, limit, now)
+		if b.tokens >= float64(limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+	for ip, f := range rl.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.windowStart) > rl.cfg.AuthFailureWindow {
+			delete(rl.failures, ip)
+		}
+	}
+}
+
+// Allow takes a token from the bucket of key and class. If there is none, it returns how long to wait for the next one.
+func (rl *rateLimiter) Allow(key string, class RouteClass) (bool, time.Duration) {
+	return rl.allow(key, class, time.Now())
+}
+
+func (rl *rateLimiter) allow(key string, class RouteClass, now time.Time) (bool, time.Duration) {
+	if !rl.cfg.Enabled {
+		return true, 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.prune(now)
+	limit := rl.limit(class)
+	b, ok := rl.buckets[bucketKey{key, class}]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[bucketKey{key, class}] = b
+	}
+	rl.refill(b, limit, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// LockedOut returns how long ip is still locked out, 0 if it isn't.
+func (rl *rateLimiter) LockedOut(ip string) time.Duration {
+	return rl.lockedOut(ip, time.Now())
+}
+
+func (rl *rateLimiter) lockedOut(ip string, now time.Time) time.Duration {
+	if !rl.cfg.Enabled {
+		return 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if f, ok := rl.failures[ip]; ok {
+		if left := f.lockedUntil.Sub(now); left > 0 {
+			return left
+		}
+	}
+	return 0
+}
+
+// AuthFailed records a failed authentication of ip and reports whether it caused a lockout.
+func (rl *rateLimiter) AuthFailed(ip string) bool {
+	return rl.authFailed(ip, time.Now())
+}
+
+func (rl *rateLimiter) authFailed(ip string, now time.Time) bool {
+	if !rl.cfg.Enabled {
+		return false
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	f, ok := rl.failures[ip]
+	if !ok || now.Sub(f.windowStart) > rl.cfg.AuthFailureWindow {
+		f = &failureState{windowStart: now}
+		rl.failures[ip] = f
+	}
+	f.count++
+	if f.count < rl.cfg.MaxAuthFailures {
+		return false
+	}
+	f.count = 0
+	f.windowStart = now
+	f.lockedUntil = now.Add(rl.cfg.LockoutDuration)
+	return true
+}
+
+// State returns the buckets that are not full and the IPs with failed authentications.
+func (rl *rateLimiter) State() ([]BucketState, []LockoutState) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	buckets := make([]BucketState, 0, len(rl.buckets))
+	for key, b := range rl.buckets {
+		limit := rl.limit(key.class)
+		rl.refill(b, limit, now)
+		if b.tokens >= float64(limit.Burst) {
+			continue
+		}
+		buckets = append(buckets, BucketState{Key: key.key, Class: key.class, Tokens: b.tokens})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Tokens < buckets[j].Tokens
+	})
+
+	lockouts := make([]LockoutState, 0, len(rl.failures))
+	for ip, f := range rl.failures {
+		state := LockoutState{IP: ip, Failures: f.count}
+		if now.Before(f.lockedUntil) {
+			state.LockedUntil = f.lockedUntil.Unix()
+		}
+		lockouts = append(lockouts, state)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].IP < lockouts[j].IP
+	})
+	return buckets, lockouts
+}
+
+func (rw *ReqWrapper) tooManyRequests(retryAfter time.Duration, details string) {
+	rw.w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	rw.E(MessageCodeRateLimited, "Too many requests", http.StatusTooManyRequests, details)
+}
+
+// RateLimitMiddleware limits requests per IP. Limits per credential are applied by AuthMiddleware.
+func (srv *HttpServer) RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+		if ok, retryAfter := srv.rateLimiter.Allow("ip:"+clientIP(r), srv.routeClass(r)); !ok {
+			rw.tooManyRequests(retryAfter, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authFailed records a failed authentication, and logs when it locks the IP out.
+func (srv *HttpServer) authFailed(r *http.Request) {
+	ip := clientIP(r)
+	if srv.rateLimiter.AuthFailed(ip) {
+		srv.ProcessManager.Logger.Errorf("Locked out %s for %s after %d failed authentications\n", ip, srv.ProcessManager.Config.RateLimits.LockoutDuration, srv.ProcessManager.Config.RateLimits.MaxAuthFailures)
+	}
+}
+
+type RateLimitsResponse struct {
+	Enabled  bool           `json:"enabled"`
+	Buckets  []BucketState  `json:"buckets"`
+	Lockouts []LockoutState `json:"lockouts"`
+}
+
+func (srv *HttpServer) GetRateLimits(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	buckets, lockouts := srv.rateLimiter.State()
+	rw.MarshalAndRespond(RateLimitsResponse{
+		Enabled:  srv.ProcessManager.Config.RateLimits.Enabled,
+		Buckets:  buckets,
+		Lockouts: lockouts,
+	})
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"procsman_backend/config"
+	"testing"
+	"time"
+)
+
+var testBase = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestRateLimiter() *rateLimiter {
+	rl := newRateLimiter(&config.RateLimitConfig{
+		Enabled:           true,
+		Read:              config.RateLimit{Rate: 1, Burst: 3},
+		Write:             config.RateLimit{Rate: 0.5, Burst: 1},
+		Export:            config.RateLimit{Rate: 0.001, Burst: 1},
+		MaxAuthFailures:   3,
+		AuthFailureWindow: time.Minute,
+		LockoutDuration:   2 * time.Minute,
+	})
+	rl.lastPrune = testBase
+	return rl
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type request struct {
+		at         time.Duration
+		key        string
+		class      RouteClass
+		allowed    bool
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "burst then refill",
+			requests: []request{
+				{0, "a", RouteClassRead, true, 0},
+				{0, "a", RouteClassRead, true, 0},
+				{0, "a", RouteClassRead, true, 0},
+				{0, "a", RouteClassRead, false, time.Second},
+				{500 * time.Millisecond, "a", RouteClassRead, false, 500 * time.Millisecond},
+				{time.Second, "a", RouteClassRead, true, 0},
+				{time.Second, "a", RouteClassRead, false, time.Second},
+			},
+		},
+		{
+			name: "refill is capped at burst",
+			requests: []request{
+				{0, "a", RouteClassWrite, true, 0},
+				{time.Hour, "a", RouteClassWrite, true, 0},
+				{time.Hour, "a", RouteClassWrite, false, 2 * time.Second},
+			},
+		},
+		{
+			name: "keys and classes have their own buckets",
+			requests: []request{
+				{0, "a", RouteClassWrite, true, 0},
+				{0, "a", RouteClassWrite, false, 2 * time.Second},
+				{0, "b", RouteClassWrite, true, 0},
+				{0, "a", RouteClassRead, true, 0},
+				{0, "a", RouteClassExport, true, 0},
+				{0, "a", RouteClassExport, false, 1000 * time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newTestRateLimiter()
+			for i, req := range tt.requests {
+				allowed, retryAfter := rl.allow(req.key, req.class, testBase.Add(req.at))
+				if allowed != req.allowed || retryAfter.Round(time.Millisecond) != req.retryAfter {
+					t.Fatalf("request %d: got (%v, %s), want (%v, %s)", i, allowed, retryAfter, req.allowed, req.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	rl := newTestRateLimiter()
+	rl.cfg.Enabled = false
+	for i := 0; i < 10; i++ {
+		if allowed, _ := rl.allow("a", RouteClassWrite, testBase); !allowed {
+			t.Fatalf("request %d was limited", i)
+		}
+		if rl.authFailed("a", testBase) {
+			t.Fatalf("failure %d caused a lockout", i)
+		}
+	}
+}
+
+func TestRateLimiterLockout(t *testing.T) {
+	type step struct {
+		at time.Duration
+		// fail records a failed authentication, otherwise the lockout is checked.
+		fail bool
+		// lockout is the expected result of a failure.
+		lockout bool
+		// left is the expected time left of the lockout.
+		left time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "locked out after max failures until it expires",
+			steps: []step{
+				{at: 0, fail: true},
+				{at: time.Second, fail: true},
+				{at: time.Second, left: 0},
+				{at: 2 * time.Second, fail: true, lockout: true},
+				{at: 2 * time.Second, left: 2 * time.Minute},
+				{at: time.Minute, left: time.Minute + 2*time.Second},
+				{at: 2*time.Minute + 2*time.Second, left: 0},
+			},
+		},
+		{
+			name: "failures outside the window are forgotten",
+			steps: []step{
+				{at: 0, fail: true},
+				{at: time.Second, fail: true},
+				{at: 2 * time.Minute, fail: true},
+				{at: 2 * time.Minute, left: 0},
+				{at: 2*time.Minute + time.Second, fail: true},
+				{at: 2*time.Minute + 2*time.Second, fail: true, lockout: true},
+			},
+		},
+		{
+			name: "the count starts over after a lockout",
+			steps: []step{
+				{at: 0, fail: true},
+				{at: 0, fail: true},
+				{at: 0, fail: true, lockout: true},
+				{at: 3 * time.Minute, fail: true},
+				{at: 3 * time.Minute, left: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newTestRateLimiter()
+			for i, s := range tt.steps {
+				now := testBase.Add(s.at)
+				if s.fail {
+					if lockout := rl.authFailed("ip", now); lockout != s.lockout {
+						t.Fatalf("step %d: lockout %v, want %v", i, lockout, s.lockout)
+					}
+					continue
+				}
+				if left := rl.lockedOut("ip", now); left != s.left {
+					t.Fatalf("step %d: %s left, want %s", i, left, s.left)
+				}
+			}
+			if left := rl.lockedOut("other", testBase); left != 0 {
+				t.Fatalf("another ip is locked out for %s", left)
+			}
+		})
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	rl := newTestRateLimiter()
+	rl.allow("idle", RouteClassRead, testBase)
+	rl.allow("busy", RouteClassExport, testBase)
+	rl.authFailed("old", testBase)
+	for i := 0; i < 3; i++ {
+		rl.authFailed("locked", testBase)
+	}
+
+	// not pruned before rateLimitPruneInterval.
+	rl.allow("other", RouteClassRead, testBase.Add(rateLimitPruneInterval/2))
+	if len(rl.buckets) != 3 || len(rl.failures) != 2 {
+		t.Fatalf("pruned early: %d buckets, %d failures", len(rl.buckets), len(rl.failures))
+	}
+
+	rl.prune(testBase.Add(rateLimitPruneInterval + 30*time.Second))
+	if _, ok := rl.buckets[bucketKey{"idle", RouteClassRead}]; ok {
+		t.Fatal("full bucket was not pruned")
+	}
+	if _, ok := rl.buckets[bucketKey{"busy", RouteClassExport}]; !ok {
+		t.Fatal("bucket that is not full was pruned")
+	}
+	if _, ok := rl.failures["old"]; ok {
+		t.Fatal("failure outside the window was not pruned")
+	}
+	if _, ok := rl.failures["locked"]; !ok {
+		t.Fatal("lockout was pruned before it expired")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		peer       *peerCred
+		want       string
+	}{
+		{"tcp", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"ipv6", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"unix socket peer", "@", &peerCred{Uid: 1000}, "unix:uid:1000"},
+		{"unix socket root", "", &peerCred{Uid: 0}, "unix:uid:0"},
+		{"unix socket without credentials", "@", nil, "unix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/health", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.peer != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ContextKeyPeerCred, tt.peer))
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
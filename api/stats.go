@@ -6,12 +6,38 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 	"net/http"
 	"procsman_backend/db"
+	"procsman_backend/procsmanager"
 	"strconv"
 	"time"
 )
 
+// MaxProcessStatsTimeFrame limits requests for raw stats.
 const MaxProcessStatsTimeFrame = time.Hour * 24 * 30
 
+// MaxStatsPoints is how many points an automatically chosen resolution may return at most.
+// An explicit step may return up to MaxStatsPointsExplicit points.
+const (
+	MaxStatsPoints         = 1500
+	MaxStatsPointsExplicit = 20000
+)
+
+// StatsSteps maps values of the "step" query parameter to resolutions.
+var StatsSteps = map[string]int32{
+	"raw": procsmanager.ResolutionRaw,
+	"1m":  procsmanager.ResolutionMinute,
+	"1h":  procsmanager.ResolutionHour,
+	"1d":  procsmanager.ResolutionDay,
+}
+
+func stepName(resolution int32) string {
+	for name, res := range StatsSteps {
+		if res == resolution {
+			return name
+		}
+	}
+	return ""
+}
+
 type StatsResponseCpu struct {
 	RecordTime   int64   `json:"record_time"`
 	UsagePercent float64 `json:"usage_percent"`
@@ -23,9 +49,43 @@ type StatsResponseMemory struct {
 	UsageBytes int64 `json:"usage_bytes"`
 }
 
+// StatsResponseValue is a point of one of the extended metrics.
+// Points are only present for samples where the metric was collected.
+type StatsResponseValue struct {
+	RecordTime int64 `json:"record_time"`
+	Value      int64 `json:"value"`
+}
+
+// StatsResponseRollup is a single bucket of a downsampled response.
+type StatsResponseRollup struct {
+	RecordTime int64   `json:"record_time"`
+	Samples    int32   `json:"samples"`
+	CpuAvg     float64 `json:"cpu_avg"`
+	CpuMin     float64 `json:"cpu_min"`
+	CpuMax     float64 `json:"cpu_max"`
+	CpuP95     float64 `json:"cpu_p95"`
+	MemoryAvg  int64   `json:"memory_avg"`
+	MemoryMin  int64   `json:"memory_min"`
+	MemoryMax  int64   `json:"memory_max"`
+	MemoryP95  int64   `json:"memory_p95"`
+}
+
+// StatsResponse contains raw samples if Step is "raw".
+// Otherwise Cpu and Memory contain averages of every bucket, Rollup has the rest of the aggregates,
+// and the extended metrics are empty, as they are not rolled up.
 type StatsResponse struct {
+	Step   string                `json:"step"`
 	Cpu    []StatsResponseCpu    `json:"cpu"`
 	Memory []StatsResponseMemory `json:"memory"`
+	Rollup []StatsResponseRollup `json:"rollup"`
+
+	Threads                []StatsResponseValue `json:"threads"`
+	OpenFds                []StatsResponseValue `json:"open_fds"`
+	IoReadBytes            []StatsResponseValue `json:"io_read_bytes"`
+	IoWriteBytes           []StatsResponseValue `json:"io_write_bytes"`
+	CtxSwitchesVoluntary   []StatsResponseValue `json:"ctx_switches_voluntary"`
+	CtxSwitchesInvoluntary []StatsResponseValue `json:"ctx_switches_involuntary"`
+	Children               []StatsResponseValue `json:"children"`
 }
 
 func (srv *HttpServer) GetProcessStats(w http.ResponseWriter, r *http.Request) {
@@ -51,27 +111,38 @@ func (srv *HttpServer) GetProcessStats(w http.ResponseWriter, r *http.Request) {
 		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
 	}
 
-	from := time.Now().UTC().Add(-1 * time.Hour)
-	to := time.Now().UTC()
+	from, to, tfErr := parseTimeFrame(r, time.Now().UTC().Add(-1*time.Hour), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+	if !to.After(from) {
+		rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "to must be after from")
+		return
+	}
 
-	if r.URL.Query().Get("from") != "" {
-		from, err = time.Parse(time.RFC3339, r.URL.Query().Get("from"))
-		if err != nil {
-			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse from time")
+	var resolution int32
+	if step := r.URL.Query().Get("step"); step != "" && step != "auto" {
+		var ok bool
+		resolution, ok = StatsSteps[step]
+		if !ok {
+			rw.E(MessageCodeInvalidStep, "Invalid step", http.StatusBadRequest, "step must be one of auto, raw, 1m, 1h, 1d")
 			return
 		}
-	}
-
-	if r.URL.Query().Get("to") != "" {
-		to, err = time.Parse(time.RFC3339, r.URL.Query().Get("to"))
-		if err != nil {
-			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Could not parse to time")
+		if resolution == procsmanager.ResolutionRaw && to.Sub(from) > MaxProcessStatsTimeFrame {
+			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Time frame too large")
 			return
 		}
+		if srv.expectedStatsPoints(resolution, from, to) > MaxStatsPointsExplicit {
+			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Time frame too large for this step")
+			return
+		}
+	} else {
+		resolution = srv.chooseStatsResolution(from, to)
 	}
 
-	if to.Sub(from) > MaxProcessStatsTimeFrame {
-		rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Time frame too large")
+	if resolution != procsmanager.ResolutionRaw {
+		srv.respondWithRollups(rw, r, int32(idInt), resolution, from, to)
 		return
 	}
 
@@ -96,20 +167,338 @@ func (srv *HttpServer) GetProcessStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	res := StatsResponse{
-		Cpu:    make([]StatsResponseCpu, len(stats)),
-		Memory: make([]StatsResponseMemory, len(stats)),
+		Step:                   stepName(procsmanager.ResolutionRaw),
+		Cpu:                    make([]StatsResponseCpu, len(stats)),
+		Memory:                 make([]StatsResponseMemory, len(stats)),
+		Rollup:                 make([]StatsResponseRollup, 0),
+		Threads:                make([]StatsResponseValue, 0),
+		OpenFds:                make([]StatsResponseValue, 0),
+		IoReadBytes:            make([]StatsResponseValue, 0),
+		IoWriteBytes:           make([]StatsResponseValue, 0),
+		CtxSwitchesVoluntary:   make([]StatsResponseValue, 0),
+		CtxSwitchesInvoluntary: make([]StatsResponseValue, 0),
+		Children:               make([]StatsResponseValue, 0),
+	}
+
+	appendInt4 := func(series []StatsResponseValue, recordTime int64, v pgtype.Int4) []StatsResponseValue {
+		if !v.Valid {
+			return series
+		}
+		return append(series, StatsResponseValue{RecordTime: recordTime, Value: int64(v.Int32)})
+	}
+	appendInt8 := func(series []StatsResponseValue, recordTime int64, v pgtype.Int8) []StatsResponseValue {
+		if !v.Valid {
+			return series
+		}
+		return append(series, StatsResponseValue{RecordTime: recordTime, Value: v.Int64})
 	}
 
 	for i, stat := range stats {
+		recordTime := stat.CreatedAt.Time.Unix()
 		res.Cpu[i] = StatsResponseCpu{
-			RecordTime:   stat.CreatedAt.Time.Unix(),
+			RecordTime:   recordTime,
 			UsagePercent: stat.CpuUsagePercentage,
 			UsageNs:      stat.CpuUsage,
 		}
 		res.Memory[i] = StatsResponseMemory{
-			RecordTime: stat.CreatedAt.Time.Unix(),
+			RecordTime: recordTime,
 			UsageBytes: stat.MemoryUsage,
 		}
+		res.Threads = appendInt4(res.Threads, recordTime, stat.ThreadCount)
+		res.OpenFds = appendInt4(res.OpenFds, recordTime, stat.OpenFds)
+		res.IoReadBytes = appendInt8(res.IoReadBytes, recordTime, stat.IoReadBytes)
+		res.IoWriteBytes = appendInt8(res.IoWriteBytes, recordTime, stat.IoWriteBytes)
+		res.CtxSwitchesVoluntary = appendInt8(res.CtxSwitchesVoluntary, recordTime, stat.CtxSwitchesVoluntary)
+		res.CtxSwitchesInvoluntary = appendInt8(res.CtxSwitchesInvoluntary, recordTime, stat.CtxSwitchesInvoluntary)
+		res.Children = appendInt4(res.Children, recordTime, stat.ChildCount)
+	}
+
+	rw.MarshalAndRespond(res)
+}
+
+func (srv *HttpServer) expectedStatsPoints(resolution int32, from, to time.Time) int64 {
+	interval := time.Duration(resolution) * time.Second
+	if resolution == procsmanager.ResolutionRaw {
+		interval = srv.ProcessManager.Config.ProcessStatsInterval
+	}
+	return int64(to.Sub(from) / interval)
+}
+
+// chooseStatsResolution picks the finest resolution that still has data for from, and returns at most MaxStatsPoints points.
+func (srv *HttpServer) chooseStatsResolution(from, to time.Time) int32 {
+	now := time.Now().UTC()
+	for _, resolution := range []int32{procsmanager.ResolutionRaw, procsmanager.ResolutionMinute, procsmanager.ResolutionHour} {
+		retention := srv.ProcessManager.StatsRetention(resolution)
+		if retention != 0 && from.Before(now.Add(-retention)) {
+			continue
+		}
+		if srv.expectedStatsPoints(resolution, from, to) <= MaxStatsPoints {
+			return resolution
+		}
+	}
+	return procsmanager.ResolutionDay
+}
+
+func (srv *HttpServer) respondWithRollups(rw *ReqWrapper, r *http.Request, processID int32, resolution int32, from, to time.Time) {
+	rollups, err := srv.ProcessManager.Queries.GetProcessStatsRollupFromTo(r.Context(), db.GetProcessStatsRollupFromToParams{
+		ProcessID:  processID,
+		Resolution: resolution,
+		Bucket: pgtype.Timestamp{
+			Time:  from.UTC().Truncate(time.Duration(resolution) * time.Second),
+			Valid: true,
+		},
+		Bucket_2: pgtype.Timestamp{
+			Time:  to.UTC(),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := StatsResponse{
+		Step:                   stepName(resolution),
+		Cpu:                    make([]StatsResponseCpu, len(rollups)),
+		Memory:                 make([]StatsResponseMemory, len(rollups)),
+		Rollup:                 make([]StatsResponseRollup, len(rollups)),
+		Threads:                make([]StatsResponseValue, 0),
+		OpenFds:                make([]StatsResponseValue, 0),
+		IoReadBytes:            make([]StatsResponseValue, 0),
+		IoWriteBytes:           make([]StatsResponseValue, 0),
+		CtxSwitchesVoluntary:   make([]StatsResponseValue, 0),
+		CtxSwitchesInvoluntary: make([]StatsResponseValue, 0),
+		Children:               make([]StatsResponseValue, 0),
+	}
+	for i, rollup := range rollups {
+		recordTime := rollup.Bucket.Time.Unix()
+		res.Cpu[i] = StatsResponseCpu{
+			RecordTime:   recordTime,
+			UsagePercent: rollup.CpuAvg,
+			UsageNs:      rollup.CpuUsage,
+		}
+		res.Memory[i] = StatsResponseMemory{
+			RecordTime: recordTime,
+			UsageBytes: rollup.MemoryAvg,
+		}
+		res.Rollup[i] = StatsResponseRollup{
+			RecordTime: recordTime,
+			Samples:    rollup.Samples,
+			CpuAvg:     rollup.CpuAvg,
+			CpuMin:     rollup.CpuMin,
+			CpuMax:     rollup.CpuMax,
+			CpuP95:     rollup.CpuP95,
+			MemoryAvg:  rollup.MemoryAvg,
+			MemoryMin:  rollup.MemoryMin,
+			MemoryMax:  rollup.MemoryMax,
+			MemoryP95:  rollup.MemoryP95,
+		}
+	}
+
+	rw.MarshalAndRespond(res)
+}
+
+type GroupStatsPoint struct {
+	RecordTime   int64   `json:"record_time"`
+	UsagePercent float64 `json:"usage_percent"`
+	UsageBytes   int64   `json:"usage_bytes"`
+	// Processes is how many processes of the group had samples in the bucket.
+	Processes int32 `json:"processes"`
+}
+
+type GroupStatsResponse struct {
+	Step   string            `json:"step"`
+	Points []GroupStatsPoint `json:"points"`
+}
+
+// GetGroupStats sums CPU and memory usage of every process in the group.
+// Samples are aligned to buckets of the chosen step, so processes that sample at different moments add up.
+func (srv *HttpServer) GetGroupStats(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	id := r.PathValue("id")
+	if id == "" {
+		rw.E(MessageCodeNoIdProvided, "No id provided", http.StatusBadRequest, "No id provided")
+		return
+	}
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	_, err = srv.ProcessManager.Queries.GetProcessGroup(r.Context(), int32(idInt))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeGroupNotFound, "Group not found", http.StatusNotFound, "Group not found")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	from, to, tfErr := parseTimeFrame(r, time.Now().UTC().Add(-1*time.Hour), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+	if !to.After(from) {
+		rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "to must be after from")
+		return
+	}
+
+	var resolution int32
+	if step := r.URL.Query().Get("step"); step != "" && step != "auto" {
+		var ok bool
+		resolution, ok = StatsSteps[step]
+		if !ok {
+			rw.E(MessageCodeInvalidStep, "Invalid step", http.StatusBadRequest, "step must be one of auto, raw, 1m, 1h, 1d")
+			return
+		}
+		if srv.expectedStatsPoints(resolution, from, to) > MaxStatsPointsExplicit {
+			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Time frame too large for this step")
+			return
+		}
+	} else {
+		resolution = srv.chooseStatsResolution(from, to)
+	}
+
+	groupID := pgtype.Int4{Int32: int32(idInt), Valid: true}
+	rangeFrom := pgtype.Timestamp{Time: from.UTC(), Valid: true}
+	rangeTo := pgtype.Timestamp{Time: to.UTC(), Valid: true}
+
+	res := GroupStatsResponse{
+		Step:   stepName(resolution),
+		Points: make([]GroupStatsPoint, 0),
+	}
+
+	if resolution == procsmanager.ResolutionRaw {
+		rows, err := srv.ProcessManager.Queries.GetGroupStatsFromTo(r.Context(), db.GetGroupStatsFromToParams{
+			BucketSize: int32(srv.ProcessManager.Config.ProcessStatsInterval / time.Second),
+			GroupID:    groupID,
+			RangeFrom:  rangeFrom,
+			RangeTo:    rangeTo,
+		})
+		if err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, row := range rows {
+			res.Points = append(res.Points, GroupStatsPoint{
+				RecordTime:   row.Bucket.Time.Unix(),
+				UsagePercent: row.CpuUsagePercentage,
+				UsageBytes:   row.MemoryUsage,
+				Processes:    row.Processes,
+			})
+		}
+	} else {
+		rangeFrom.Time = rangeFrom.Time.Truncate(time.Duration(resolution) * time.Second)
+		rows, err := srv.ProcessManager.Queries.GetGroupStatsRollupFromTo(r.Context(), db.GetGroupStatsRollupFromToParams{
+			GroupID:    groupID,
+			Resolution: resolution,
+			RangeFrom:  rangeFrom,
+			RangeTo:    rangeTo,
+		})
+		if err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, row := range rows {
+			res.Points = append(res.Points, GroupStatsPoint{
+				RecordTime:   row.Bucket.Time.Unix(),
+				UsagePercent: row.CpuUsagePercentage,
+				UsageBytes:   row.MemoryUsage,
+				Processes:    row.Processes,
+			})
+		}
+	}
+
+	rw.MarshalAndRespond(res)
+}
+
+type HostStatsPoint struct {
+	RecordTime         int64   `json:"record_time"`
+	CpuUsagePercent    float64 `json:"cpu_usage_percent"`
+	MemoryTotalBytes   int64   `json:"memory_total_bytes"`
+	MemoryUsedBytes    int64   `json:"memory_used_bytes"`
+	SwapTotalBytes     int64   `json:"swap_total_bytes"`
+	SwapUsedBytes      int64   `json:"swap_used_bytes"`
+	Load1              float64 `json:"load_1"`
+	Load5              float64 `json:"load_5"`
+	Load15             float64 `json:"load_15"`
+	LogsDiskTotalBytes int64   `json:"logs_disk_total_bytes"`
+	LogsDiskUsedBytes  int64   `json:"logs_disk_used_bytes"`
+}
+
+type HostStatsResponse struct {
+	// BucketSize is the size of every bucket in seconds. Values in a bucket are averaged.
+	BucketSize int32            `json:"bucket_size"`
+	Points     []HostStatsPoint `json:"points"`
+}
+
+// GetHostStats returns host-wide usage. Samples are averaged into buckets, so at most MaxStatsPoints are returned,
+// unless the step is given explicitly.
+func (srv *HttpServer) GetHostStats(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	from, to, tfErr := parseTimeFrame(r, time.Now().UTC().Add(-1*time.Hour), time.Now().UTC())
+	if tfErr != nil {
+		rw.WriteError(tfErr)
+		return
+	}
+	if !to.After(from) {
+		rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "to must be after from")
+		return
+	}
+
+	interval := srv.ProcessManager.Config.ProcessStatsInterval
+	bucketSize := max(interval, to.Sub(from)/MaxStatsPoints)
+	if step := r.URL.Query().Get("step"); step != "" && step != "auto" {
+		resolution, ok := StatsSteps[step]
+		if !ok {
+			rw.E(MessageCodeInvalidStep, "Invalid step", http.StatusBadRequest, "step must be one of auto, raw, 1m, 1h, 1d")
+			return
+		}
+		if resolution == procsmanager.ResolutionRaw {
+			bucketSize = interval
+		} else {
+			bucketSize = time.Duration(resolution) * time.Second
+		}
+		if int64(to.Sub(from)/bucketSize) > MaxStatsPointsExplicit {
+			rw.E(MessageCodeInvalidTimeFrame, "Invalid time frame", http.StatusBadRequest, "Time frame too large for this step")
+			return
+		}
+	}
+	bucketSeconds := int32((bucketSize + time.Second - 1) / time.Second)
+
+	rows, err := srv.ProcessManager.Queries.GetHostStatsFromTo(r.Context(), db.GetHostStatsFromToParams{
+		BucketSize: bucketSeconds,
+		RangeFrom:  pgtype.Timestamp{Time: from.UTC(), Valid: true},
+		RangeTo:    pgtype.Timestamp{Time: to.UTC(), Valid: true},
+	})
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := HostStatsResponse{
+		BucketSize: bucketSeconds,
+		Points:     make([]HostStatsPoint, len(rows)),
+	}
+	for i, row := range rows {
+		res.Points[i] = HostStatsPoint{
+			RecordTime:         row.Bucket.Time.Unix(),
+			CpuUsagePercent:    row.CpuUsagePercentage,
+			MemoryTotalBytes:   row.MemoryTotal,
+			MemoryUsedBytes:    row.MemoryUsed,
+			SwapTotalBytes:     row.SwapTotal,
+			SwapUsedBytes:      row.SwapUsed,
+			Load1:              row.Load1,
+			Load5:              row.Load5,
+			Load15:             row.Load15,
+			LogsDiskTotalBytes: row.DiskTotal,
+			LogsDiskUsedBytes:  row.DiskUsed,
+		}
 	}
 
 	rw.MarshalAndRespond(res)
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"procsman_backend/config"
+	"sync"
+	"time"
+)
+
+// tlsReloadInterval is how often the certificate files are checked for changes.
+const tlsReloadInterval = 10 * time.Second
+
+// tlsReloader serves the certificate and client CA from their files, and reloads them when they change,
+// so renewed certificates are picked up without a restart.
+type tlsReloader struct {
+	certFile, keyFile, caFile string
+	srv                       *HttpServer
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	modTimes  [3]time.Time
+	config    *tls.Config
+}
+
+func newTlsReloader(srv *HttpServer, cfg *config.Config) (*tlsReloader, error) {
+	tr := &tlsReloader{
+		certFile: cfg.TlsCert,
+		keyFile:  cfg.TlsKey,
+		caFile:   cfg.ClientCa,
+		srv:      srv,
+	}
+	modTimes, err := tr.stat()
+	if err != nil {
+		return nil, err
+	}
+	if tr.config, err = tr.load(); err != nil {
+		return nil, err
+	}
+	tr.modTimes = modTimes
+	tr.lastCheck = time.Now()
+	return tr, nil
+}
+
+func (tr *tlsReloader) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, name := range []string{tr.certFile, tr.keyFile, tr.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (tr *tlsReloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tr.certFile, tr.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if tr.caFile != "" {
+		pem, err := os.ReadFile(tr.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + tr.caFile)
+		}
+		cfg.ClientCAs = pool
+		// clients without a certificate still authenticate with a key or session.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// current returns the TLS config, reloading it first if the files changed.
+// If reloading fails, the previous config is kept.
+func (tr *tlsReloader) current() *tls.Config {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if time.Since(tr.lastCheck) < tlsReloadInterval {
+		return tr.config
+	}
+	tr.lastCheck = time.Now()
+
+	modTimes, err := tr.stat()
+	if err != nil {
+		tr.srv.Logger.Errorf("Error checking TLS files: %v\n", err)
+		return tr.config
+	}
+	if modTimes == tr.modTimes {
+		return tr.config
+	}
+	cfg, err := tr.load()
+	if err != nil {
+		// the files may be in the middle of being replaced, try again on the next check.
+		tr.srv.Logger.Errorf("Error reloading TLS files: %v\n", err)
+		return tr.config
+	}
+	tr.config = cfg
+	tr.modTimes = modTimes
+	tr.srv.Logger.Infof("Reloaded TLS certificate from %s\n", tr.certFile)
+	return tr.config
+}
+
+// TLSConfig returns the config for http.Server.TLSConfig.
+func (tr *tlsReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return tr.current(), nil
+		},
+		// never used for handshakes because of GetConfigForClient, but http.Server requires a certificate source.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &tr.current().Certificates[0], nil
+		},
+	}
+}
+
+// authenticateClientCert returns the credential of the verified client certificate of the request, or nil if there is none
+// or its subject is not in client_cert_identities.
+func (srv *HttpServer) authenticateClientCert(state *tls.ConnectionState) *Credential {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	subject := leaf.Subject.String()
+	for _, identity := range srv.ProcessManager.Config.ClientCertIdentities {
+		if identity.Subject != subject && identity.Subject != leaf.Subject.CommonName {
+			continue
+		}
+		return &Credential{
+			Actor:    "cert:" + identity.Name,
+			Scope:    Scope(identity.Role),
+			GroupIDs: identity.GroupIDs,
+		}
+	}
+	return nil
+}
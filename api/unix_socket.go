@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"net"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// ContextKeyPeerCred holds the *peerCred of requests on the unix socket.
+const ContextKeyPeerCred = ContextKey("peerCred")
+
+// peerCred is the process on the other end of a unix socket connection.
+type peerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+// connContext adds the peer credentials of unix socket connections to the request context.
+func (srv *HttpServer) connContext(ctx context.Context, c net.Conn) context.Context {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := getPeerCred(unixConn)
+	if err != nil {
+		srv.Logger.Errorf("Error getting peer credentials: %v\n", err)
+		return ctx
+	}
+	return context.WithValue(ctx, ContextKeyPeerCred, cred)
+}
+
+// authenticatePeer returns an admin credential if the peer is root or a member of unix_socket.admin_group, nil otherwise.
+func (srv *HttpServer) authenticatePeer(cred *peerCred) *Credential {
+	cfg := srv.ProcessManager.Config.UnixSocket
+	if cfg == nil {
+		return nil
+	}
+	admin := &Credential{
+		Actor: "peer:uid:" + strconv.FormatUint(uint64(cred.Uid), 10),
+		Scope: ScopeAdmin,
+	}
+	if cred.Uid == 0 {
+		return admin
+	}
+	if cfg.AdminGroup == "" {
+		return nil
+	}
+	adminGid, err := lookupGroupId(cfg.AdminGroup)
+	if err != nil {
+		srv.Logger.Errorf("Error looking up unix_socket.admin_group: %v\n", err)
+		return nil
+	}
+	if strconv.FormatUint(uint64(cred.Gid), 10) == adminGid {
+		return admin
+	}
+	u, err := user.LookupId(strconv.FormatUint(uint64(cred.Uid), 10))
+	if err != nil {
+		return nil
+	}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		srv.Logger.Errorf("Error looking up groups of uid %d: %v\n", cred.Uid, err)
+		return nil
+	}
+	if slices.Contains(groupIds, adminGid) {
+		return admin
+	}
+	return nil
+}
+
+// lookupUserId and lookupGroupId accept a name or a numeric id.
+func lookupUserId(name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroupId(name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
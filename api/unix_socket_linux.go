@@ -0,0 +1,77 @@
+//go:build linux
+
+package api
+
+import (
+	"errors"
+	"net"
+	"os"
+	"procsman_backend/config"
+	"strconv"
+	"syscall"
+)
+
+func getPeerCred(conn *net.UnixConn) (*peerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &peerCred{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}, nil
+}
+
+func listenUnix(cfg *config.UnixSocketConfig) (net.Listener, error) {
+	// a socket left over from an unclean shutdown would make listening fail.
+	if info, err := os.Lstat(cfg.Path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(cfg.Path + " exists and is not a socket")
+		}
+		if err = os.Remove(cfg.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	closeWithErr := func(err error) (net.Listener, error) {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	if err = os.Chmod(cfg.Path, cfg.FileMode); err != nil {
+		return closeWithErr(err)
+	}
+	if cfg.Owner != "" || cfg.Group != "" {
+		uid, gid := -1, -1
+		if cfg.Owner != "" {
+			id, err := lookupUserId(cfg.Owner)
+			if err != nil {
+				return closeWithErr(err)
+			}
+			uid, _ = strconv.Atoi(id)
+		}
+		if cfg.Group != "" {
+			id, err := lookupGroupId(cfg.Group)
+			if err != nil {
+				return closeWithErr(err)
+			}
+			gid, _ = strconv.Atoi(id)
+		}
+		if err = os.Chown(cfg.Path, uid, gid); err != nil {
+			return closeWithErr(err)
+		}
+	}
+	return listener, nil
+}
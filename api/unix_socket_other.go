@@ -0,0 +1,19 @@
+//go:build !linux
+
+package api
+
+import (
+	"errors"
+	"net"
+	"procsman_backend/config"
+)
+
+var errUnixSocketUnsupported = errors.New("the unix socket listener is only supported on linux")
+
+func getPeerCred(conn *net.UnixConn) (*peerCred, error) {
+	return nil, errUnixSocketUnsupported
+}
+
+func listenUnix(cfg *config.UnixSocketConfig) (net.Listener, error) {
+	return nil, errUnixSocketUnsupported
+}
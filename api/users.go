@@ -0,0 +1,581 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"os"
+	"procsman_backend/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MinPasswordLength = 8
+	// MaxPasswordLength is the limit of bcrypt, longer passwords would be silently truncated.
+	MaxPasswordLength = 72
+	MaxUsernameLength = 255
+)
+
+const AuditTargetUser = "user"
+
+// accessTokenPrefix is the version of the session token format.
+const accessTokenPrefix = "v1."
+
+// dummyPasswordHash is compared against on logins of unknown users, so they take as long as logins with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("procsman-dummy-password"), bcrypt.DefaultCost)
+
+// HashPassword validates password and returns its bcrypt hash.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return "", fmt.Errorf("password must be at most %d bytes long", MaxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CreateAdmin creates a user with the admin role. It's used for the bootstrap file and the -create-admin flag.
+func CreateAdmin(ctx context.Context, queries *db.Queries, username, password string) (db.User, error) {
+	if username == "" || len(username) > MaxUsernameLength {
+		return db.User{}, errors.New("invalid username")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return db.User{}, err
+	}
+	return queries.CreateUser(ctx, db.CreateUserParams{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         string(ScopeAdmin),
+	})
+}
+
+// LoadAuth loads the legacy auth key and the session key, and creates the first admin from the bootstrap file.
+func (srv *HttpServer) LoadAuth(ctx context.Context) error {
+	cfg := srv.ProcessManager.Config.Auth
+
+	if cfg.LegacyKeyFile != "" {
+		encoded, err := os.ReadFile(cfg.LegacyKeyFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(encoded) > 0 {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+			if err != nil {
+				return fmt.Errorf("could not decode %s: %w", cfg.LegacyKeyFile, err)
+			}
+			hash := sha256.Sum256(key)
+			srv.legacyKeyHash = &hash
+			srv.Logger.Infof("Accepting the legacy auth key from %s\n", cfg.LegacyKeyFile)
+		}
+	}
+
+	sessionKey, err := os.ReadFile(cfg.SessionKeyFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		sessionKey = make([]byte, 64)
+		if _, err = rand.Read(sessionKey); err != nil {
+			return err
+		}
+		if err = os.WriteFile(cfg.SessionKeyFile, sessionKey, 0600); err != nil {
+			return err
+		}
+		srv.Logger.Infof("Generated new session key in %s\n", cfg.SessionKeyFile)
+	}
+	if len(sessionKey) < 32 {
+		return fmt.Errorf("%s must contain at least 32 bytes", cfg.SessionKeyFile)
+	}
+	srv.sessionKey = sessionKey
+
+	count, err := srv.ProcessManager.Queries.CountUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	noAdmin := func() error {
+		if srv.legacyKeyHash == nil {
+			srv.Logger.Errorln("There are no users and no legacy auth key, create an admin with -create-admin or auth.bootstrap_file")
+		}
+		return nil
+	}
+	if cfg.BootstrapFile == "" {
+		return noAdmin()
+	}
+	f, err := os.Open(cfg.BootstrapFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return noAdmin()
+		}
+		return err
+	}
+	defer f.Close()
+	var bootstrap struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err = json.NewDecoder(f).Decode(&bootstrap); err != nil {
+		return fmt.Errorf("could not decode %s: %w", cfg.BootstrapFile, err)
+	}
+	user, err := CreateAdmin(ctx, srv.ProcessManager.Queries, bootstrap.Username, bootstrap.Password)
+	if err != nil {
+		return fmt.Errorf("could not create admin from %s: %w", cfg.BootstrapFile, err)
+	}
+	srv.Logger.Infof("Created admin %q from %s, the file can be removed now\n", user.Username, cfg.BootstrapFile)
+	return nil
+}
+
+// accessTokenClaims is the payload of an access token.
+type accessTokenClaims struct {
+	SessionID int32 `json:"sid"`
+	UserID    int32 `json:"uid"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+func (srv *HttpServer) signAccessToken(claims accessTokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, srv.sessionKey)
+	mac.Write([]byte(accessTokenPrefix + encoded))
+	return accessTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseAccessToken checks the signature and expiry of token. It doesn't check whether the session was revoked.
+func (srv *HttpServer) parseAccessToken(token string) (*accessTokenClaims, bool) {
+	rest, ok := strings.CutPrefix(token, accessTokenPrefix)
+	if !ok {
+		return nil, false
+	}
+	encoded, signature, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, srv.sessionKey)
+	mac.Write([]byte(accessTokenPrefix + encoded))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	var claims accessTokenClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// authenticateSession returns the credential of an access token, or nil if it's not valid.
+func (srv *HttpServer) authenticateSession(ctx context.Context, token string) (*Credential, error) {
+	claims, ok := srv.parseAccessToken(token)
+	if !ok {
+		return nil, nil
+	}
+	session, err := srv.ProcessManager.Queries.GetUserSession(ctx, claims.SessionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.UserID != claims.UserID || time.Now().UTC().After(session.ExpiresAt.Time) {
+		return nil, nil
+	}
+	user, err := srv.ProcessManager.Queries.GetUser(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return userCredential(user, session.ID), nil
+}
+
+func userCredential(user db.User, sessionID int32) *Credential {
+	return &Credential{
+		Actor:     "user:" + strconv.Itoa(int(user.ID)) + ":" + user.Username,
+		Scope:     Scope(user.Role),
+		UserID:    pgtype.Int4{Int32: user.ID, Valid: true},
+		SessionID: pgtype.Int4{Int32: sessionID, Valid: true},
+	}
+}
+
+// UserInfo is a user without the password hash.
+type UserInfo struct {
+	ID                int32  `json:"id"`
+	Username          string `json:"username"`
+	Role              Scope  `json:"role"`
+	CreatedAt         int64  `json:"created_at"`
+	PasswordChangedAt int64  `json:"password_changed_at"`
+}
+
+func newUserInfo(user db.User) UserInfo {
+	return UserInfo{
+		ID:                user.ID,
+		Username:          user.Username,
+		Role:              Scope(user.Role),
+		CreatedAt:         user.CreatedAt.Time.Unix(),
+		PasswordChangedAt: user.PasswordChangedAt.Time.Unix(),
+	}
+}
+
+type SessionResponse struct {
+	AccessToken string `json:"access_token"`
+	// AccessTokenExpiresAt and RefreshTokenExpiresAt are unix timestamps.
+	AccessTokenExpiresAt  int64    `json:"access_token_expires_at"`
+	RefreshToken          string   `json:"refresh_token"`
+	RefreshTokenExpiresAt int64    `json:"refresh_token_expires_at"`
+	User                  UserInfo `json:"user"`
+}
+
+func newRefreshToken() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	hash := sha256.Sum256([]byte(token))
+	return token, hash[:], nil
+}
+
+func (srv *HttpServer) sessionResponse(user db.User, sessionID int32, refreshToken string, refreshExpiresAt time.Time) (*SessionResponse, error) {
+	expiresAt := time.Now().Add(srv.ProcessManager.Config.Auth.AccessTokenTTL)
+	accessToken, err := srv.signAccessToken(accessTokenClaims{
+		SessionID: sessionID,
+		UserID:    user.ID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SessionResponse{
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  expiresAt.Unix(),
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshExpiresAt.Unix(),
+		User:                  newUserInfo(user),
+	}, nil
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (l *LoginRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if l.Username == "" || l.Password == "" {
+		return MakeE(MessageCodeInvalidCredentials, "Invalid username or password", http.StatusBadRequest, "username and password are required")
+	}
+	return nil
+}
+
+func (srv *HttpServer) Login(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*LoginRequest)
+
+	if lockedOut := srv.rateLimiter.LockedOut(clientIP(r)); lockedOut > 0 {
+		rw.tooManyRequests(lockedOut, "Too many failed authentications")
+		return
+	}
+
+	user, err := srv.ProcessManager.Queries.GetUserByUsername(r.Context(), req.Username)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		srv.authFailed(r)
+		rw.E(MessageCodeInvalidCredentials, "Invalid username or password", http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		rw.Infof("Failed login of %q from %s\n", req.Username, r.RemoteAddr)
+		srv.authFailed(r)
+		rw.E(MessageCodeInvalidCredentials, "Invalid username or password", http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	refreshExpiresAt := time.Now().UTC().Add(srv.ProcessManager.Config.Auth.RefreshTokenTTL)
+	session, err := srv.ProcessManager.Queries.CreateUserSession(r.Context(), db.CreateUserSessionParams{
+		UserID:      user.ID,
+		RefreshHash: refreshHash,
+		ExpiresAt:   pgtype.Timestamp{Time: refreshExpiresAt, Valid: true},
+	})
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp, err := srv.sessionResponse(user, session.ID, refreshToken, refreshExpiresAt)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.Actor = userCredential(user, session.ID).Actor
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "auth.login", AuditTargetUser, user.ID, nil, nil)
+	rw.MarshalAndRespond(resp)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (rr *RefreshRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if rr.RefreshToken == "" {
+		return MakeE(MessageCodeInvalidRefreshToken, "Invalid refresh token", http.StatusBadRequest, "refresh_token is required")
+	}
+	return nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token. The refresh token is rotated,
+// so every refresh token can only be used once.
+func (srv *HttpServer) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*RefreshRequest)
+
+	if lockedOut := srv.rateLimiter.LockedOut(clientIP(r)); lockedOut > 0 {
+		rw.tooManyRequests(lockedOut, "Too many failed authentications")
+		return
+	}
+
+	refreshToken, refreshHash, err := newRefreshToken()
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	// the token is checked and replaced in one update, so concurrent refreshes with the same token can't both succeed.
+	hash := sha256.Sum256([]byte(req.RefreshToken))
+	now := time.Now().UTC()
+	refreshExpiresAt := now.Add(srv.ProcessManager.Config.Auth.RefreshTokenTTL)
+	session, err := srv.ProcessManager.Queries.RotateUserSession(r.Context(), db.RotateUserSessionParams{
+		NewRefreshHash: refreshHash,
+		ExpiresAt:      pgtype.Timestamp{Time: refreshExpiresAt, Valid: true},
+		RefreshHash:    hash[:],
+		Now:            pgtype.Timestamp{Time: now, Valid: true},
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			srv.authFailed(r)
+			rw.E(MessageCodeInvalidRefreshToken, "Invalid refresh token", http.StatusUnauthorized, "Invalid or expired refresh token")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, err := srv.ProcessManager.Queries.GetUser(r.Context(), session.UserID)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp, err := srv.sessionResponse(user, session.ID, refreshToken, refreshExpiresAt)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.MarshalAndRespond(resp)
+}
+
+func (srv *HttpServer) Logout(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	if !rw.Credential.SessionID.Valid {
+		rw.E(MessageCodeSessionRequired, "Not logged in with a session", http.StatusBadRequest, "Only session tokens can be logged out")
+		return
+	}
+	if err := srv.ProcessManager.Queries.RevokeUserSession(r.Context(), rw.Credential.SessionID.Int32); err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "auth.logout", AuditTargetUser, rw.Credential.UserID.Int32, nil, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (c *ChangePasswordRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if len(c.NewPassword) < MinPasswordLength || len(c.NewPassword) > MaxPasswordLength {
+		return MakeE(MessageCodeInvalidPassword, "Invalid password", http.StatusBadRequest, fmt.Sprintf("new_password must be %d to %d characters long", MinPasswordLength, MaxPasswordLength))
+	}
+	return nil
+}
+
+// ChangePassword changes the password of the logged in user and revokes all of its other sessions.
+func (srv *HttpServer) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*ChangePasswordRequest)
+
+	if !rw.Credential.UserID.Valid {
+		rw.E(MessageCodeSessionRequired, "Not logged in with a session", http.StatusBadRequest, "Only users can change their password")
+		return
+	}
+	user, err := srv.ProcessManager.Queries.GetUser(r.Context(), rw.Credential.UserID.Int32)
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)) != nil {
+		rw.E(MessageCodeInvalidCredentials, "Invalid password", http.StatusForbidden, "old_password is wrong")
+		return
+	}
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		rw.E(MessageCodeInvalidPassword, "Invalid password", http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, queries, err := srv.ProcessManager.OpenTx(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(r.Context())
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err = tx.Commit(r.Context()); err != nil {
+			rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}()
+
+	if err = queries.SetUserPassword(r.Context(), db.SetUserPasswordParams{ID: user.ID, PasswordHash: hash}); err != nil {
+		return
+	}
+	err = queries.RevokeOtherUserSessions(r.Context(), db.RevokeOtherUserSessionsParams{UserID: user.ID, ID: rw.Credential.SessionID.Int32})
+	if err != nil {
+		return
+	}
+	rw.Audit(r.Context(), queries, "user.change_password", AuditTargetUser, user.ID, nil, nil)
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Scope  `json:"role"`
+}
+
+func (c *CreateUserRequest) Validate(ctx context.Context, srv *HttpServer) *Error {
+	if c.Username == "" || len(c.Username) > MaxUsernameLength {
+		return MakeE(MessageCodeNameRequired, "username is required", http.StatusBadRequest, fmt.Sprintf("username must be 1 to %d characters long", MaxUsernameLength))
+	}
+	if len(c.Password) < MinPasswordLength || len(c.Password) > MaxPasswordLength {
+		return MakeE(MessageCodeInvalidPassword, "Invalid password", http.StatusBadRequest, fmt.Sprintf("password must be %d to %d characters long", MinPasswordLength, MaxPasswordLength))
+	}
+	if !c.Role.Valid() {
+		return MakeE(MessageCodeInvalidScope, "invalid role", http.StatusBadRequest, "role must be read, operate or admin")
+	}
+	if _, err := srv.ProcessManager.Queries.GetUserByUsername(ctx, c.Username); err == nil {
+		return MakeE(MessageCodeUserAlreadyExists, "User already exists", http.StatusConflict, "User already exists")
+	}
+	return nil
+}
+
+func (srv *HttpServer) CreateUser(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+	req := r.Context().Value(ContextKeyUnmarshalledJson).(*CreateUserRequest)
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		rw.E(MessageCodeInvalidPassword, "Invalid password", http.StatusBadRequest, err.Error())
+		return
+	}
+	user, err := srv.ProcessManager.Queries.CreateUser(r.Context(), db.CreateUserParams{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Role:         string(req.Role),
+	})
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	info := newUserInfo(user)
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "user.create", AuditTargetUser, user.ID, nil, info)
+	rw.MarshalAndRespond(info)
+}
+
+func (srv *HttpServer) GetUsers(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	users, err := srv.ProcessManager.Queries.GetUsers(r.Context())
+	if err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	res := make([]UserInfo, len(users))
+	for i, user := range users {
+		res[i] = newUserInfo(user)
+	}
+	rw.MarshalAndRespond(res)
+}
+
+func (srv *HttpServer) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	rw := r.Context().Value(ContextKeyWrappedRequest).(*ReqWrapper)
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		rw.E(MessageCodeInvalidId, "Invalid id", http.StatusBadRequest, "Invalid id")
+		return
+	}
+	if rw.Credential.UserID.Valid && rw.Credential.UserID.Int32 == int32(id) {
+		rw.E(MessageCodeInvalidId, "Cannot delete yourself", http.StatusBadRequest, "Cannot delete the logged in user")
+		return
+	}
+	user, err := srv.ProcessManager.Queries.GetUser(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			rw.E(MessageCodeUserNotFound, "User not found", http.StatusNotFound, "User not found")
+			return
+		}
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	// sessions are deleted with the user.
+	if err = srv.ProcessManager.Queries.DeleteUser(r.Context(), user.ID); err != nil {
+		rw.E(MessageCodeInternalError, "Internal error", http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.Audit(r.Context(), srv.ProcessManager.Queries, "user.delete", AuditTargetUser, user.ID, newUserInfo(user), nil)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ChatWebhookChannelConfig configures slack, discord and mattermost channels, which all post to an incoming webhook.
+type ChatWebhookChannelConfig struct {
+	URL string `json:"url"`
+	// Username overrides the name the message is posted as, if the webhook allows it.
+	Username string `json:"username"`
+	// Timeout is in seconds, DefaultWebhookTimeout is used if it's 0.
+	Timeout int `json:"timeout"`
+}
+
+func (c *ChatWebhookChannelConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("chat_webhook.url must be an http or https url")
+	}
+	if c.Timeout < 0 {
+		return errors.New("chat_webhook.timeout must not be negative")
+	}
+	return nil
+}
+
+func (c *ChatWebhookChannelConfig) GetTimeout() time.Duration {
+	if c.Timeout == 0 {
+		return DefaultWebhookTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// eventColor is the colour of the message for an event type, as 0xRRGGBB.
+func eventColor(event string) int {
+	switch event {
+	case "CRASH", "FULL_CRASH":
+		return 0xd32f2f
+	case "THRESHOLD":
+		return 0xf57c00
+	case "STOP", "FULL_STOP", "MANUALLY_STOPPED":
+		return 0x757575
+	case "START":
+		return 0x388e3c
+	case "RESTART":
+		return 0x1976d2
+	}
+	return 0x9e9e9e
+}
+
+// chatField is a name/value pair shown in the message.
+type chatField struct {
+	Name  string
+	Value string
+}
+
+// chatFields returns the details of n that are shown in rich messages.
+func chatFields(n *Notification) []chatField {
+	fields := make([]chatField, 0, 4)
+	if n.Event != "" {
+		fields = append(fields, chatField{"Event", n.Event})
+	}
+	if n.GroupName != "" {
+		fields = append(fields, chatField{"Group", n.GroupName})
+	}
+	var info struct {
+		ExitCode       *int   `json:"exit_code"`
+		Signal         string `json:"signal"`
+		Uptime         int64  `json:"uptime"`
+		RestartAttempt int    `json:"restart_attempt"`
+	}
+	if len(n.AdditionalInfo) == 0 || json.Unmarshal(n.AdditionalInfo, &info) != nil {
+		return fields
+	}
+	if info.Signal != "" {
+		fields = append(fields, chatField{"Signal", info.Signal})
+	} else if info.ExitCode != nil {
+		fields = append(fields, chatField{"Exit code", strconv.Itoa(*info.ExitCode)})
+	}
+	if info.Uptime > 0 {
+		fields = append(fields, chatField{"Uptime", (time.Duration(info.Uptime) * time.Second).String()})
+	}
+	if info.RestartAttempt > 0 {
+		fields = append(fields, chatField{"Restart attempt", strconv.Itoa(info.RestartAttempt)})
+	}
+	return fields
+}
+
+// ChatWebhookNotifier posts rich messages to slack or mattermost (Discord=false), or discord (Discord=true) incoming webhooks.
+type ChatWebhookNotifier struct {
+	ChannelName string
+	Discord     bool
+	Config      ChatWebhookChannelConfig
+}
+
+func (c *ChatWebhookNotifier) Name() string {
+	return c.ChannelName
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title,omitempty"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text"`
+	Fields    []slackField `json:"fields,omitempty"`
+	Ts        int64        `json:"ts"`
+}
+
+type slackMessage struct {
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordMessage struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+const (
+	// discordMaxDescriptionLength is the limit of embed descriptions.
+	discordMaxDescriptionLength = 4096
+	// slackMaxTextLength keeps attachments short enough not to be collapsed or cut by slack and mattermost.
+	slackMaxTextLength = 4000
+)
+
+func (c *ChatWebhookNotifier) payload(n *Notification) ([]byte, error) {
+	fields := chatFields(n)
+	if c.Discord {
+		embed := discordEmbed{
+			Title:       n.ProcessName,
+			URL:         n.Link,
+			Description: appendOutput(n.Text, n, discordMaxDescriptionLength, true),
+			Color:       eventColor(n.Event),
+			Timestamp:   time.Unix(n.Time, 0).UTC().Format(time.RFC3339),
+		}
+		for _, field := range fields {
+			embed.Fields = append(embed.Fields, discordField{Name: field.Name, Value: field.Value, Inline: true})
+		}
+		return json.Marshal(discordMessage{Username: c.Config.Username, Embeds: []discordEmbed{embed}})
+	}
+
+	attachment := slackAttachment{
+		Fallback:  n.Text,
+		Color:     fmt.Sprintf("#%06x", eventColor(n.Event)),
+		Title:     n.ProcessName,
+		TitleLink: n.Link,
+		Text:      appendOutput(n.Text, n, slackMaxTextLength, true),
+		Ts:        n.Time,
+	}
+	for _, field := range fields {
+		attachment.Fields = append(attachment.Fields, slackField{Title: field.Name, Value: field.Value, Short: true})
+	}
+	return json.Marshal(slackMessage{Username: c.Config.Username, Attachments: []slackAttachment{attachment}})
+}
+
+func (c *ChatWebhookNotifier) Send(n *Notification) []SendResult {
+	body, err := c.payload(n)
+	if err != nil {
+		return []SendResult{{Channel: c.ChannelName, Error: err.Error()}}
+	}
+	res := postJson(c.Config.URL, nil, c.Config.GetTimeout(), body)
+	res.Channel = c.ChannelName
+	return []SendResult{res}
+}
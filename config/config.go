@@ -2,8 +2,10 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +15,188 @@ type Config struct {
 	LogFileTimespan      time.Duration `json:"log_file_timespan"`
 	FlushInterval        time.Duration `json:"flush_interval"`
 	ProcessStatsInterval time.Duration `json:"process_stats_interval"`
+	// Metrics selects which metric groups are collected in addition to CPU and memory.
+	// If it's omitted, everything is collected.
+	Metrics *MetricsConfig `json:"metrics"`
+	// StatsRetention is how long stats are kept at each resolution, in seconds. 0 means forever.
+	// If it's omitted, DefaultStatsRetention is used.
+	StatsRetention *StatsRetentionConfig `json:"stats_retention"`
+	// StatsRollupInterval is how often process_stats are aggregated into rollups, in seconds.
+	StatsRollupInterval time.Duration `json:"stats_rollup_interval"`
+	// Prometheus enables the /metrics endpoint. It's disabled if omitted.
+	Prometheus *PrometheusConfig `json:"prometheus"`
+	// Auth configures user sessions. If it's omitted, DefaultAuthConfig is used.
+	Auth *AuthConfig `json:"auth"`
+	// TlsCert and TlsKey enable HTTPS. They are reloaded when the files change.
+	TlsCert string `json:"tls_cert"`
+	TlsKey  string `json:"tls_key"`
+	// ClientCa enables optional client certificate authentication. Requests with a verified
+	// certificate whose subject is in ClientCertIdentities don't need an auth key.
+	ClientCa             string               `json:"client_ca"`
+	ClientCertIdentities []ClientCertIdentity `json:"client_cert_identities"`
+	// UnixSocket additionally serves the API on a unix socket, see UnixSocketConfig. It's only supported on linux.
+	UnixSocket *UnixSocketConfig `json:"unix_socket"`
+	// RateLimits limits requests per IP and per credential. If it's omitted, DefaultRateLimitConfig is used.
+	RateLimits *RateLimitConfig `json:"rate_limits"`
+	// NotificationDispatch deduplicates, batches and suppresses process notifications.
+	// If it's omitted, DefaultNotificationDispatchConfig is used.
+	NotificationDispatch *NotificationDispatchConfig `json:"notification_dispatch"`
+}
+
+// RateLimit is a token bucket: Rate requests per second on average, with bursts of up to Burst requests.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// Read is for GET requests, Write for everything else and Export for log exports.
+	Read   RateLimit `json:"read"`
+	Write  RateLimit `json:"write"`
+	Export RateLimit `json:"export"`
+	// After MaxAuthFailures failed authentications within AuthFailureWindow, an IP is locked out for LockoutDuration.
+	// Both durations are in seconds.
+	MaxAuthFailures   int           `json:"max_auth_failures"`
+	AuthFailureWindow time.Duration `json:"auth_failure_window"`
+	LockoutDuration   time.Duration `json:"lockout_duration"`
+}
+
+// DefaultRateLimitConfig is in seconds, like the values in the config file.
+var DefaultRateLimitConfig = RateLimitConfig{
+	Enabled:           true,
+	Read:              RateLimit{Rate: 20, Burst: 60},
+	Write:             RateLimit{Rate: 5, Burst: 20},
+	Export:            RateLimit{Rate: 0.2, Burst: 3},
+	MaxAuthFailures:   10,
+	AuthFailureWindow: 5 * 60,
+	LockoutDuration:   15 * 60,
+}
+
+// NotificationDispatchConfig limits the notifications of a process and configures how failed deliveries are retried.
+// All durations are in seconds.
+type NotificationDispatchConfig struct {
+	Enabled bool `json:"enabled"`
+	// Notifications identical to one sent within DedupWindow are dropped. They're only counted in the digest
+	// of their event type, if one is sent for notifications held back by MaxPerWindow.
+	DedupWindow time.Duration `json:"dedup_window"`
+	// At most MaxPerWindow notifications of a process are sent within DigestWindow. The rest are batched
+	// into one digest per event type, e.g. "restarted 7 times in 2m0s", sent when DigestWindow is over.
+	MaxPerWindow int           `json:"max_per_window"`
+	DigestWindow time.Duration `json:"digest_window"`
+	// A process with FlapThreshold or more starts, stops, crashes and restarts within FlapWindow is flapping.
+	// One alert is sent when it starts and one when it stops, its notifications in between are suppressed.
+	// It stops flapping once it has less than half of FlapThreshold state changes within FlapWindow.
+	FlapThreshold int           `json:"flap_threshold"`
+	FlapWindow    time.Duration `json:"flap_window"`
+	// Failed deliveries are retried up to MaxAttempts times in total. The delay starts at RetryDelay
+	// and doubles with every attempt, up to MaxRetryDelay.
+	MaxAttempts   int           `json:"max_attempts"`
+	RetryDelay    time.Duration `json:"retry_delay"`
+	MaxRetryDelay time.Duration `json:"max_retry_delay"`
+	// HistoryRetention is how long delivered and failed notifications are kept. 0 means forever.
+	HistoryRetention time.Duration `json:"history_retention"`
+}
+
+// DefaultNotificationDispatchConfig is in seconds, like the values in the config file.
+var DefaultNotificationDispatchConfig = NotificationDispatchConfig{
+	Enabled:       true,
+	DedupWindow:   60,
+	MaxPerWindow:  5,
+	DigestWindow:  120,
+	FlapThreshold: 8,
+	FlapWindow:    300,
+
+	MaxAttempts:      8,
+	RetryDelay:       10,
+	MaxRetryDelay:    3600,
+	HistoryRetention: 30 * 24 * 3600,
+}
+
+// UnixSocketConfig configures the unix socket listener. Peers connecting as root or as a member of
+// AdminGroup are admins without an auth key, other peers have to authenticate like on TCP.
+type UnixSocketConfig struct {
+	Path string `json:"path"`
+	// Mode is the octal file mode of the socket, e.g. "0660".
+	Mode string `json:"mode"`
+	// Owner and Group are user and group names or ids. If they're empty, the socket keeps the owner of procsman.
+	Owner      string `json:"owner"`
+	Group      string `json:"group"`
+	AdminGroup string `json:"admin_group"`
+
+	FileMode os.FileMode `json:"-"`
+}
+
+// ClientCertIdentity maps a client certificate to a credential.
+type ClientCertIdentity struct {
+	// Subject is compared to the full subject of the certificate (e.g. "CN=deploy,O=Example") and to its common name.
+	Subject string `json:"subject"`
+	// Name identifies the certificate in the audit log.
+	Name string `json:"name"`
+	// Role is read, operate or admin, like the scope of API keys.
+	Role string `json:"role"`
+	// GroupIDs restricts the certificate to processes of these groups, if set.
+	GroupIDs []int32 `json:"group_ids"`
+}
+
+type AuthConfig struct {
+	// SessionKeyFile holds the key session tokens are signed with. It's created if it doesn't exist.
+	SessionKeyFile string `json:"session_key_file"`
+	// LegacyKeyFile is the shared key used before API keys and users existed.
+	// It's still accepted as an admin key if the file exists, but it's no longer created.
+	LegacyKeyFile string `json:"legacy_key_file"`
+	// BootstrapFile contains {"username": "...", "password": "..."} of the first admin.
+	// The admin is only created if there are no users yet.
+	BootstrapFile string `json:"bootstrap_file"`
+	// AccessTokenTTL and RefreshTokenTTL are in seconds.
+	AccessTokenTTL  time.Duration `json:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `json:"refresh_token_ttl"`
+}
+
+// DefaultAuthConfig is in seconds, like the values in the config file.
+var DefaultAuthConfig = AuthConfig{
+	SessionKeyFile:  "session.key",
+	LegacyKeyFile:   "auth.key",
+	AccessTokenTTL:  15 * 60,
+	RefreshTokenTTL: 30 * 24 * 60 * 60,
+}
+
+// PrometheusConfig configures /metrics. It doesn't use the API auth key,
+// instead scrapers have to send BearerToken in the Authorization header, if it's set.
+type PrometheusConfig struct {
+	Enabled     bool   `json:"enabled"`
+	BearerToken string `json:"bearer_token"`
+}
+
+type MetricsConfig struct {
+	Threads         bool `json:"threads"`
+	FileDescriptors bool `json:"file_descriptors"`
+	Io              bool `json:"io"`
+	ContextSwitches bool `json:"context_switches"`
+	Children        bool `json:"children"`
+}
+
+type StatsRetentionConfig struct {
+	Raw    time.Duration `json:"raw"`
+	Minute time.Duration `json:"minute"`
+	Hour   time.Duration `json:"hour"`
+	Day    time.Duration `json:"day"`
+}
+
+// DefaultStatsRetention is in seconds, like the values in the config file.
+var DefaultStatsRetention = StatsRetentionConfig{
+	Raw:    2 * 24 * 60 * 60,
+	Minute: 14 * 24 * 60 * 60,
+	Hour:   180 * 24 * 60 * 60,
+	Day:    0,
+}
+
+var DefaultMetricsConfig = MetricsConfig{
+	Threads:         true,
+	FileDescriptors: true,
+	Io:              true,
+	ContextSwitches: true,
+	Children:        true,
 }
 
 func (c *Config) Validate() error {
@@ -60,5 +244,143 @@ func (c *Config) Validate() error {
 		return errors.New("process_stats_interval must be at least 1 second")
 	}
 
+	if c.StatsRollupInterval == 0 {
+		c.StatsRollupInterval = 60
+	}
+	c.StatsRollupInterval = c.StatsRollupInterval * time.Second
+	if c.StatsRollupInterval < time.Second*10 {
+		return errors.New("stats_rollup_interval must be at least 10 seconds")
+	}
+
+	if c.StatsRetention == nil {
+		retention := DefaultStatsRetention
+		c.StatsRetention = &retention
+	}
+	c.StatsRetention.Raw = c.StatsRetention.Raw * time.Second
+	c.StatsRetention.Minute = c.StatsRetention.Minute * time.Second
+	c.StatsRetention.Hour = c.StatsRetention.Hour * time.Second
+	c.StatsRetention.Day = c.StatsRetention.Day * time.Second
+	if c.StatsRetention.Raw < 0 || c.StatsRetention.Minute < 0 || c.StatsRetention.Hour < 0 || c.StatsRetention.Day < 0 {
+		return errors.New("stats_retention values must not be negative")
+	}
+	// every resolution is computed from the previous one, so the source must live long enough to fill a bucket.
+	if c.StatsRetention.Raw != 0 && c.StatsRetention.Raw < time.Hour {
+		return errors.New("stats_retention.raw must be at least 1 hour")
+	}
+	if c.StatsRetention.Minute != 0 && c.StatsRetention.Minute < 2*time.Hour {
+		return errors.New("stats_retention.minute must be at least 2 hours")
+	}
+	if c.StatsRetention.Hour != 0 && c.StatsRetention.Hour < 2*24*time.Hour {
+		return errors.New("stats_retention.hour must be at least 2 days")
+	}
+
+	if c.Metrics == nil {
+		metrics := DefaultMetricsConfig
+		c.Metrics = &metrics
+	}
+
+	if c.Auth == nil {
+		auth := DefaultAuthConfig
+		c.Auth = &auth
+	}
+	if c.Auth.SessionKeyFile == "" {
+		c.Auth.SessionKeyFile = DefaultAuthConfig.SessionKeyFile
+	}
+	if c.Auth.AccessTokenTTL == 0 {
+		c.Auth.AccessTokenTTL = DefaultAuthConfig.AccessTokenTTL
+	}
+	if c.Auth.RefreshTokenTTL == 0 {
+		c.Auth.RefreshTokenTTL = DefaultAuthConfig.RefreshTokenTTL
+	}
+	c.Auth.AccessTokenTTL = c.Auth.AccessTokenTTL * time.Second
+	c.Auth.RefreshTokenTTL = c.Auth.RefreshTokenTTL * time.Second
+	if c.Auth.AccessTokenTTL < time.Minute {
+		return errors.New("auth.access_token_ttl must be at least 1 minute")
+	}
+	if c.Auth.RefreshTokenTTL < c.Auth.AccessTokenTTL {
+		return errors.New("auth.refresh_token_ttl must not be shorter than auth.access_token_ttl")
+	}
+
+	if (c.TlsCert == "") != (c.TlsKey == "") {
+		return errors.New("tls_cert and tls_key must be set together")
+	}
+	if c.ClientCa != "" && c.TlsCert == "" {
+		return errors.New("client_ca requires tls_cert and tls_key")
+	}
+	if c.UnixSocket != nil {
+		if c.UnixSocket.Path == "" {
+			return errors.New("unix_socket.path is empty")
+		}
+		if c.UnixSocket.Mode == "" {
+			c.UnixSocket.Mode = "0660"
+		}
+		mode, err := strconv.ParseUint(c.UnixSocket.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return errors.New("unix_socket.mode must be an octal file mode, e.g. 0660")
+		}
+		c.UnixSocket.FileMode = os.FileMode(mode)
+	}
+
+	if c.RateLimits == nil {
+		limits := DefaultRateLimitConfig
+		c.RateLimits = &limits
+	}
+	c.RateLimits.AuthFailureWindow = c.RateLimits.AuthFailureWindow * time.Second
+	c.RateLimits.LockoutDuration = c.RateLimits.LockoutDuration * time.Second
+	if c.RateLimits.Enabled {
+		for name, limit := range map[string]RateLimit{"read": c.RateLimits.Read, "write": c.RateLimits.Write, "export": c.RateLimits.Export} {
+			if limit.Rate <= 0 || limit.Burst < 1 {
+				return fmt.Errorf("rate_limits.%s must have a positive rate and a burst of at least 1", name)
+			}
+		}
+		if c.RateLimits.MaxAuthFailures < 1 || c.RateLimits.AuthFailureWindow <= 0 || c.RateLimits.LockoutDuration <= 0 {
+			return errors.New("rate_limits.max_auth_failures, auth_failure_window and lockout_duration must be positive")
+		}
+	}
+
+	if c.NotificationDispatch == nil {
+		dispatch := DefaultNotificationDispatchConfig
+		c.NotificationDispatch = &dispatch
+	}
+	c.NotificationDispatch.DedupWindow = c.NotificationDispatch.DedupWindow * time.Second
+	c.NotificationDispatch.DigestWindow = c.NotificationDispatch.DigestWindow * time.Second
+	c.NotificationDispatch.FlapWindow = c.NotificationDispatch.FlapWindow * time.Second
+	// config files from before retries existed don't have their settings.
+	if c.NotificationDispatch.MaxAttempts == 0 {
+		c.NotificationDispatch.MaxAttempts = DefaultNotificationDispatchConfig.MaxAttempts
+		c.NotificationDispatch.RetryDelay = DefaultNotificationDispatchConfig.RetryDelay
+		c.NotificationDispatch.MaxRetryDelay = DefaultNotificationDispatchConfig.MaxRetryDelay
+		c.NotificationDispatch.HistoryRetention = DefaultNotificationDispatchConfig.HistoryRetention
+	}
+	c.NotificationDispatch.RetryDelay = c.NotificationDispatch.RetryDelay * time.Second
+	c.NotificationDispatch.MaxRetryDelay = c.NotificationDispatch.MaxRetryDelay * time.Second
+	c.NotificationDispatch.HistoryRetention = c.NotificationDispatch.HistoryRetention * time.Second
+	if c.NotificationDispatch.MaxAttempts < 1 || c.NotificationDispatch.RetryDelay <= 0 || c.NotificationDispatch.MaxRetryDelay < c.NotificationDispatch.RetryDelay {
+		return errors.New("notification_dispatch.max_attempts and retry_delay must be positive, max_retry_delay must not be less than retry_delay")
+	}
+	if c.NotificationDispatch.HistoryRetention < 0 {
+		return errors.New("notification_dispatch.history_retention must not be negative")
+	}
+	if c.NotificationDispatch.Enabled {
+		if c.NotificationDispatch.DedupWindow < 0 || c.NotificationDispatch.DigestWindow <= 0 || c.NotificationDispatch.FlapWindow <= 0 {
+			return errors.New("notification_dispatch.digest_window and flap_window must be positive, dedup_window must not be negative")
+		}
+		if c.NotificationDispatch.MaxPerWindow < 1 || c.NotificationDispatch.FlapThreshold < 2 {
+			return errors.New("notification_dispatch.max_per_window must be at least 1 and flap_threshold at least 2")
+		}
+	}
+
+	for i, identity := range c.ClientCertIdentities {
+		if identity.Subject == "" {
+			return fmt.Errorf("client_cert_identities[%d].subject is empty", i)
+		}
+		if identity.Role != "read" && identity.Role != "operate" && identity.Role != "admin" {
+			return fmt.Errorf("client_cert_identities[%d].role must be read, operate or admin", i)
+		}
+		if identity.Name == "" {
+			c.ClientCertIdentities[i].Name = identity.Subject
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SmtpSecurity string
+
+const (
+	// SmtpSecurityStartTls upgrades a plain connection with STARTTLS, usually on port 587.
+	SmtpSecurityStartTls SmtpSecurity = "starttls"
+	// SmtpSecurityTls connects with TLS right away, usually on port 465.
+	SmtpSecurityTls SmtpSecurity = "tls"
+	// SmtpSecurityNone doesn't encrypt at all. It's meant for local relays and test servers.
+	SmtpSecurityNone SmtpSecurity = "none"
+)
+
+const smtpTimeout = 15 * time.Second
+
+type EmailChannelConfig struct {
+	Host     string       `json:"host"`
+	Port     int          `json:"port"`
+	Security SmtpSecurity `json:"security"`
+	// Username and Password are optional, there is no authentication if Username is empty.
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// InsecureSkipVerify disables certificate verification, for test servers with self-signed certificates.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+func (e *EmailChannelConfig) Validate() error {
+	if e.Host == "" {
+		return errors.New("email.host is required")
+	}
+	if e.Port <= 0 || e.Port > 65535 {
+		return errors.New("email.port is invalid")
+	}
+	switch e.Security {
+	case SmtpSecurityStartTls, SmtpSecurityTls, SmtpSecurityNone:
+	default:
+		return errors.New("email.security must be starttls, tls or none")
+	}
+	if _, err := mail.ParseAddress(e.From); err != nil {
+		return fmt.Errorf("email.from is invalid: %w", err)
+	}
+	if len(e.To) == 0 {
+		return errors.New("email.to is empty")
+	}
+	for _, to := range e.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("email.to %q is invalid: %w", to, err)
+		}
+	}
+	if e.Username != "" && e.Security == SmtpSecurityNone && !isLocalHost(e.Host) {
+		// net/smtp refuses to send credentials unencrypted anyway.
+		return errors.New("email.username requires starttls or tls, unless the host is local")
+	}
+	return nil
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// EmailNotifier sends notifications as multipart emails with a plain text and an HTML body.
+type EmailNotifier struct {
+	ChannelName string
+	Config      EmailChannelConfig
+}
+
+func (e *EmailNotifier) Name() string {
+	return e.ChannelName
+}
+
+var emailHtmlTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<p>{{.Text}}</p>
+{{if .ProcessName}}<table cellpadding="4">
+<tr><td><b>Process</b></td><td>{{.ProcessName}} (#{{.ProcessID}})</td></tr>
+{{if .Event}}<tr><td><b>Event</b></td><td>{{.Event}}</td></tr>{{end}}
+<tr><td><b>Time</b></td><td>{{.Time}}</td></tr>
+{{if .AdditionalInfo}}<tr><td><b>Details</b></td><td><pre>{{.AdditionalInfo}}</pre></td></tr>{{end}}
+</table>{{end}}
+{{if .OutputLines}}<p>The last {{.OutputLines}} lines of output are attached.</p>{{end}}
+</body></html>
+`))
+
+type emailData struct {
+	Text           string
+	ProcessID      int32
+	ProcessName    string
+	Event          string
+	Time           string
+	AdditionalInfo string
+	// OutputLines is the number of lines of output attached to the message.
+	OutputLines int
+}
+
+func newEmailData(n *Notification) emailData {
+	data := emailData{
+		Text:        n.Text,
+		ProcessID:   n.ProcessID,
+		ProcessName: n.ProcessName,
+		Event:       n.Event,
+		Time:        time.Unix(n.Time, 0).UTC().Format(time.RFC1123),
+		OutputLines: len(n.Output),
+	}
+	if len(n.AdditionalInfo) > 0 && string(n.AdditionalInfo) != "null" {
+		data.AdditionalInfo = string(n.AdditionalInfo)
+	}
+	return data
+}
+
+func emailSubject(n *Notification) string {
+	if n.ProcessName == "" {
+		return "procsman notification"
+	}
+	if n.Event == "" {
+		return "procsman: " + n.ProcessName
+	}
+	return fmt.Sprintf("procsman: %s %s", n.ProcessName, n.Event)
+}
+
+func emailTextBody(data emailData) string {
+	var b strings.Builder
+	b.WriteString(data.Text)
+	b.WriteString("\n")
+	if data.ProcessName != "" {
+		fmt.Fprintf(&b, "\nProcess: %s (#%d)\n", data.ProcessName, data.ProcessID)
+		if data.Event != "" {
+			fmt.Fprintf(&b, "Event: %s\n", data.Event)
+		}
+		fmt.Fprintf(&b, "Time: %s\n", data.Time)
+		if data.AdditionalInfo != "" {
+			fmt.Fprintf(&b, "Details: %s\n", data.AdditionalInfo)
+		}
+	}
+	if data.OutputLines > 0 {
+		fmt.Fprintf(&b, "\nThe last %d lines of output are attached.\n", data.OutputLines)
+	}
+	return b.String()
+}
+
+// buildMessage renders the RFC 5322 message for n.
+func (e *EmailNotifier) buildMessage(n *Notification) ([]byte, error) {
+	data := newEmailData(n)
+	var html bytes.Buffer
+	if err := emailHtmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	newBoundary := func() string {
+		boundaryBytes := make([]byte, 12)
+		_, _ = rand.Read(boundaryBytes)
+		return "procsman-" + hex.EncodeToString(boundaryBytes)
+	}
+	boundary := newBoundary()
+	// with output, the message is mixed: the text and html alternatives, then the output as a file.
+	mixedBoundary := ""
+	if len(n.Output) > 0 {
+		mixedBoundary = newBoundary()
+	}
+
+	var msg bytes.Buffer
+	writeHeader := func(name, value string) {
+		msg.WriteString(name + ": " + value + "\r\n")
+	}
+	writeHeader("From", e.Config.From)
+	writeHeader("To", strings.Join(e.Config.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", emailSubject(n)))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("MIME-Version", "1.0")
+	if mixedBoundary != "" {
+		writeHeader("Content-Type", `multipart/mixed; boundary="`+mixedBoundary+`"`)
+		msg.WriteString("\r\n--" + mixedBoundary + "\r\n")
+	}
+	writeHeader("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	msg.WriteString("\r\n")
+
+	writePart := func(contentType, body string) error {
+		msg.WriteString("--" + boundary + "\r\n")
+		msg.WriteString("Content-Type: " + contentType + "; charset=utf-8\r\n")
+		msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&msg)
+		if _, err := qp.Write([]byte(body)); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+		msg.WriteString("\r\n")
+		return nil
+	}
+	if err := writePart("text/plain", emailTextBody(data)); err != nil {
+		return nil, err
+	}
+	if err := writePart("text/html", html.String()); err != nil {
+		return nil, err
+	}
+	msg.WriteString("--" + boundary + "--\r\n")
+
+	if mixedBoundary != "" {
+		msg.WriteString("--" + mixedBoundary + "\r\n")
+		writeHeader("Content-Type", "text/plain; charset=utf-8")
+		writeHeader("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": outputFileName(n)}))
+		writeHeader("Content-Transfer-Encoding", "base64")
+		msg.WriteString("\r\n")
+		encoded := base64.StdEncoding.EncodeToString(outputText(n))
+		// base64 lines must not be longer than 76 characters.
+		for len(encoded) > 76 {
+			msg.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		msg.WriteString(encoded + "\r\n")
+		msg.WriteString("--" + mixedBoundary + "--\r\n")
+	}
+	return msg.Bytes(), nil
+}
+
+// failAll returns a failed result for every recipient.
+func (e *EmailNotifier) failAll(err error) []SendResult {
+	results := make([]SendResult, len(e.Config.To))
+	for i, to := range e.Config.To {
+		results[i] = SendResult{Channel: e.ChannelName, Recipient: to, Error: err.Error()}
+	}
+	return results
+}
+
+func (e *EmailNotifier) Send(n *Notification) []SendResult {
+	msg, err := e.buildMessage(n)
+	if err != nil {
+		return e.failAll(err)
+	}
+
+	addr := net.JoinHostPort(e.Config.Host, strconv.Itoa(e.Config.Port))
+	tlsConfig := &tls.Config{ServerName: e.Config.Host, InsecureSkipVerify: e.Config.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	if e.Config.Security == SmtpSecurityTls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return e.failAll(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, e.Config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return e.failAll(err)
+	}
+	defer client.Close()
+
+	if e.Config.Security == SmtpSecurityStartTls {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return e.failAll(err)
+		}
+	}
+	if e.Config.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", e.Config.Username, e.Config.Password, e.Config.Host)); err != nil {
+			return e.failAll(err)
+		}
+	}
+
+	from, err := mail.ParseAddress(e.Config.From)
+	if err != nil {
+		return e.failAll(fmt.Errorf("sender %q is invalid: %w", e.Config.From, err))
+	}
+	if err = client.Mail(from.Address); err != nil {
+		return e.failAll(err)
+	}
+
+	// recipients are accepted or rejected one by one, the message is sent to the accepted ones.
+	results := make([]SendResult, len(e.Config.To))
+	accepted := 0
+	for i, to := range e.Config.To {
+		results[i] = SendResult{Channel: e.ChannelName, Recipient: to}
+		// routed recipients don't go through EmailChannelConfig.Validate.
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			results[i].Error = fmt.Sprintf("recipient %q is invalid: %v", to, err)
+			continue
+		}
+		if err = client.Rcpt(addr.Address); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		return results
+	}
+
+	setAccepted := func(err error) []SendResult {
+		for i := range results {
+			if results[i].Error != "" {
+				continue
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Success = true
+			}
+		}
+		return results
+	}
+	w, err := client.Data()
+	if err != nil {
+		return setAccepted(err)
+	}
+	if _, err = w.Write(msg); err != nil {
+		return setAccepted(err)
+	}
+	if err = w.Close(); err != nil {
+		return setAccepted(err)
+	}
+	_ = client.Quit()
+	return setAccepted(nil)
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSmtpServer is a minimal SMTP server that rejects some recipients and keeps the messages it receives.
+type fakeSmtpServer struct {
+	listener net.Listener
+	reject   []string
+
+	mu         sync.Mutex
+	recipients []string
+	messages   []string
+}
+
+func startFakeSmtpServer(t *testing.T, reject []string) *fakeSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSmtpServer{listener: listener, reject: reject}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSmtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSmtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = io.WriteString(conn, line+"\r\n")
+	}
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-fake")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			reply("250 ok")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to := strings.Trim(line[len("RCPT TO:"):], "<> ")
+			if slices.Contains(s.reject, to) {
+				reply("550 5.1.1 no such user")
+				continue
+			}
+			s.mu.Lock()
+			s.recipients = append(s.recipients, to)
+			s.mu.Unlock()
+			reply("250 ok")
+		case command == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmailNotifierSend(t *testing.T) {
+	tests := []struct {
+		name   string
+		reject []string
+		// accepted are the recipients that must get the message.
+		accepted []string
+	}{
+		{name: "all recipients accepted", accepted: []string{"ops@example.com", "dev@example.com"}},
+		{name: "one recipient rejected", reject: []string{"dev@example.com"}, accepted: []string{"ops@example.com"}},
+		{name: "all recipients rejected", reject: []string{"ops@example.com", "dev@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startFakeSmtpServer(t, tt.reject)
+			notifier := &EmailNotifier{
+				ChannelName: "mail",
+				Config: EmailChannelConfig{
+					Host:     "127.0.0.1",
+					Port:     server.port(),
+					Security: SmtpSecurityNone,
+					From:     "procsman <procsman@example.com>",
+					To:       []string{"ops@example.com", "Dev <dev@example.com>"},
+				},
+			}
+			n := &Notification{
+				ProcessID:   1,
+				ProcessName: "web",
+				Event:       "CRASH",
+				Text:        "web crashed",
+				Time:        1767225600,
+				Output:      []string{"starting", "panic: boom"},
+			}
+
+			results := notifier.Send(n)
+			if len(results) != 2 {
+				t.Fatalf("got %d results, want 2", len(results))
+			}
+			for i, r := range results {
+				address, _ := mail.ParseAddress(notifier.Config.To[i])
+				rejected := slices.Contains(tt.reject, address.Address)
+				if r.Channel != "mail" || r.Recipient != notifier.Config.To[i] {
+					t.Fatalf("result %d is for %s/%s", i, r.Channel, r.Recipient)
+				}
+				if r.Success == rejected || (rejected && !strings.Contains(r.Error, "550")) {
+					t.Fatalf("result %d: success %v, error %q, rejected %v", i, r.Success, r.Error, rejected)
+				}
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			if !slices.Equal(server.recipients, tt.accepted) {
+				t.Fatalf("recipients %q, want %q", server.recipients, tt.accepted)
+			}
+			if len(tt.accepted) == 0 {
+				if len(server.messages) != 0 {
+					t.Fatal("a message was sent without recipients")
+				}
+				return
+			}
+			if len(server.messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(server.messages))
+			}
+			checkEmailMessage(t, server.messages[0], n)
+		})
+	}
+}
+
+// checkEmailMessage checks that msg has the text and html bodies of n, and its output attached.
+func checkEmailMessage(t *testing.T, msg string, n *Notification) {
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); subject != "procsman: web CRASH" {
+		t.Fatalf("subject %q", subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", m.Header.Get("Content-Type"), err)
+	}
+
+	mixed := multipart.NewReader(m.Body, params["boundary"])
+	alternative, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, _ = mime.ParseMediaType(alternative.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("first part is %q", mediaType)
+	}
+	bodies := multipart.NewReader(alternative, params["boundary"])
+	for _, want := range []string{"text/plain", "text/html"} {
+		part, err := bodies.NextPart()
+		if err != nil {
+			t.Fatalf("%s: %v", want, err)
+		}
+		mediaType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// quoted-printable is decoded by the reader.
+		body, _ := io.ReadAll(part)
+		if mediaType != want || !strings.Contains(string(body), n.Text) || !strings.Contains(string(body), "The last 2 lines of output are attached.") {
+			t.Fatalf("%s part is %q: %s", want, mediaType, body)
+		}
+	}
+
+	attachment, err := mixed.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "web-output.txt" {
+		t.Fatalf("attachment is called %q", attachment.FileName())
+	}
+	encoded, _ := io.ReadAll(attachment)
+	output, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || string(output) != "starting\npanic: boom\n" {
+		t.Fatalf("attachment is %q: %v", output, err)
+	}
+	if _, err = mixed.NextPart(); err != io.EOF {
+		t.Fatalf("unexpected part after the attachment: %v", err)
+	}
+}
+
+func TestEmailNotifierSendInvalidAddresses(t *testing.T) {
+	server := startFakeSmtpServer(t, nil)
+	notifier := &EmailNotifier{
+		ChannelName: "mail",
+		Config: EmailChannelConfig{
+			Host:     "127.0.0.1",
+			Port:     server.port(),
+			Security: SmtpSecurityNone,
+			From:     "procsman@example.com",
+			// routed recipients aren't validated with the channel.
+			To: []string{"12345", "ops@example.com"},
+		},
+	}
+	n := &Notification{ProcessID: 1, ProcessName: "web", Event: "CRASH", Text: "web crashed", Time: 1767225600}
+
+	results := notifier.Send(n)
+	if len(results) != 2 || results[0].Success || !strings.Contains(results[0].Error, `recipient "12345" is invalid`) || !results[1].Success {
+		t.Fatalf("results %+v", results)
+	}
+	server.mu.Lock()
+	if !slices.Equal(server.recipients, []string{"ops@example.com"}) || len(server.messages) != 1 {
+		t.Fatalf("recipients %q, %d messages", server.recipients, len(server.messages))
+	}
+	server.mu.Unlock()
+
+	notifier.Config.From = "not an address"
+	for i, r := range notifier.Send(n) {
+		if r.Success || !strings.Contains(r.Error, "sender") {
+			t.Fatalf("result %d with an invalid sender: %+v", i, r)
+		}
+	}
+}
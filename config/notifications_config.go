@@ -1,11 +1,14 @@
 package config
 
 import (
-	"bytes"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,124 +16,172 @@ var httpClient = &http.Client{
 	Timeout: 10 * time.Second,
 }
 
-func (nc *NotificationsConfig) Save() error {
-	f, err := os.OpenFile(NotificationConfigFileName, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+// withoutUrl removes the url from errors of http requests. Urls can contain secrets, like the bot token of telegram
+// urls or the token of chat webhooks, and errors are stored and shown in the notification history.
+func withoutUrl(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(nc)
+	return err
 }
 
 type SendResult struct {
+	// Channel is the name of the channel the message was sent to.
+	Channel   string
 	Success   bool
 	ChatId    int64
 	MessageId int
+	// Recipient is the address for channels that don't use chat ids, like email.
+	Recipient string
 	Error     string
 }
 
 func (nc *NotificationsConfig) SendTelegramMessage(text string, chatId int64) SendResult {
-	requestMap := map[string]interface{}{
-		"chat_id": chatId,
-		"text":    text,
-	}
+	return sendTelegramMessage(nc.TelegramApiUrl, nc.TelegramBotToken, text, chatId, nil)
+}
 
-	var reader io.Reader
-	if b, err := json.Marshal(requestMap); err != nil {
-		return SendResult{
-			ChatId: chatId,
-			Error:  err.Error(),
-		}
-	} else {
-		reader = bytes.NewReader(b)
-	}
+// SendTelegramKeyboard sends text with an inline keyboard, one slice of buttons per row.
+func (nc *NotificationsConfig) SendTelegramKeyboard(text string, chatId int64, buttons [][]TelegramButton) SendResult {
+	markup := map[string]interface{}{"inline_keyboard": buttons}
+	return sendTelegramMessage(nc.TelegramApiUrl, nc.TelegramBotToken, text, chatId, map[string]interface{}{"reply_markup": markup})
+}
 
-	req, err := http.NewRequest("POST", "https://api.telegram.org/bot"+nc.TelegramBotToken+"/sendMessage", reader)
-	if err != nil {
-		return SendResult{
-			ChatId: chatId,
-			Error:  err.Error(),
-		}
+// GetTelegramUpdates long-polls the bot for messages and callback queries, waiting up to timeout for one.
+func (nc *NotificationsConfig) GetTelegramUpdates(offset int64, timeout time.Duration) ([]TelegramUpdate, error) {
+	params := map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"message", "callback_query"},
 	}
+	updates := make([]TelegramUpdate, 0)
+	err := callTelegram(nc.TelegramApiUrl, nc.TelegramBotToken, "getUpdates", params, &updates, timeout+10*time.Second)
+	return updates, err
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// AnswerTelegramCallback acknowledges a pressed button, text is shown to the user briefly.
+func (nc *NotificationsConfig) AnswerTelegramCallback(callbackID, text string) error {
+	params := map[string]interface{}{"callback_query_id": callbackID, "text": text}
+	return callTelegram(nc.TelegramApiUrl, nc.TelegramBotToken, "answerCallbackQuery", params, nil, httpClient.Timeout)
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return SendResult{
-			ChatId: chatId,
-			Error:  err.Error(),
+// EditTelegramMessage replaces the text of a message sent by the bot, which also removes its keyboard.
+func (nc *NotificationsConfig) EditTelegramMessage(chatId int64, messageId int, text string) error {
+	params := map[string]interface{}{"chat_id": chatId, "message_id": messageId, "text": text}
+	return callTelegram(nc.TelegramApiUrl, nc.TelegramBotToken, "editMessageText", params, nil, httpClient.Timeout)
+}
+
+// SendMessage sends a plain text message to all channels.
+func (nc *NotificationsConfig) SendMessage(text string) []SendResult {
+	return nc.Send(&Notification{Text: text, Time: time.Now().Unix()})
+}
+
+// Delivery is a notification rendered for one of its targets.
+type Delivery struct {
+	Target       Target
+	Notification *Notification
+}
+
+// Deliveries renders n for each of its targets, or each enabled channel if it has none.
+// Channels whose event filter rejects n are skipped. It returns nothing if notifications are disabled.
+func (nc *NotificationsConfig) Deliveries(n *Notification) []Delivery {
+	if !nc.Enabled {
+		return nil
+	}
+	if nc.UiBaseUrl != "" && n.ProcessID != 0 && n.Link == "" {
+		linked := *n
+		linked.Link = strings.TrimRight(nc.UiBaseUrl, "/") + "/processes/" + strconv.Itoa(int(n.ProcessID))
+		n = &linked
+	}
+	targets := n.Targets
+	if targets == nil {
+		for _, notifier := range nc.Notifiers() {
+			targets = append(targets, Target{Channel: notifier.Name()})
 		}
 	}
-
-	defer resp.Body.Close()
-	var responseMap map[string]interface{}
-	if err = json.NewDecoder(resp.Body).Decode(&responseMap); err != nil {
-		return SendResult{
-			ChatId: chatId,
-			Error:  err.Error(),
+	deliveries := make([]Delivery, 0, len(targets))
+	for _, target := range targets {
+		channel := nc.channel(target.Channel)
+		if channel != nil && !channel.Accepts(n) {
+			continue
 		}
+		rendered := *n
+		rendered.Text = nc.renderText(n, channel)
+		rendered.Composed = true
+		rendered.Targets = nil
+		deliveries = append(deliveries, Delivery{Target: target, Notification: &rendered})
 	}
+	return deliveries
+}
 
-	if b, ok := responseMap["ok"].(bool); !ok || !b || resp.StatusCode >= 400 {
-		var description string
-		if desc, ok2 := responseMap["description"].(string); ok2 {
-			description = desc
-		} else {
-			description = "unknown error"
-		}
-		return SendResult{
-			ChatId: chatId,
-			Error:  description,
-		}
+// Deliver sends a delivery to its target. It blocks until the channel responds.
+func (nc *NotificationsConfig) Deliver(d Delivery) []SendResult {
+	notifier := nc.targetNotifier(d.Target)
+	if notifier == nil {
+		return []SendResult{{Channel: d.Target.Channel, Error: fmt.Sprintf("channel %s doesn't exist or is disabled", d.Target.Channel)}}
 	}
+	return notifier.Send(d.Notification)
+}
 
-	messageId, ok := responseMap["result"].(map[string]interface{})["message_id"].(float64)
+// Send sends n to its targets, or all enabled channels if it has none. It blocks until all of them respond.
+func (nc *NotificationsConfig) Send(n *Notification) []SendResult {
+	results := make([]SendResult, 0)
+	for _, delivery := range nc.Deliveries(n) {
+		results = append(results, nc.Deliver(delivery)...)
+	}
+	return results
+}
 
-	if !ok {
-		return SendResult{
-			ChatId: chatId,
-			Error:  "could not get message_id",
+// channel returns the configuration of a channel, nil for the telegram bot of TelegramBotToken.
+func (nc *NotificationsConfig) channel(name string) *ChannelConfig {
+	for i := range nc.Channels {
+		if nc.Channels[i].Name == name {
+			return &nc.Channels[i]
 		}
 	}
+	return nil
+}
 
-	return SendResult{
-		ChatId:    chatId,
-		MessageId: int(messageId),
-		Success:   true,
+// Notifiers returns the enabled channels. The telegram bot configured with TelegramBotToken is the first of them.
+func (nc *NotificationsConfig) Notifiers() []Notifier {
+	notifiers := make([]Notifier, 0, len(nc.Channels)+1)
+	if nc.TelegramBotToken != "" && len(nc.TelegramTargetChatIDS) > 0 {
+		notifiers = append(notifiers, &TelegramNotifier{
+			ChannelName: "telegram",
+			Config:      TelegramChannelConfig{BotToken: nc.TelegramBotToken, ChatIDs: nc.TelegramTargetChatIDS, ApiUrl: nc.TelegramApiUrl},
+		})
+	}
+	for i := range nc.Channels {
+		if !nc.Channels[i].Enabled {
+			continue
+		}
+		if notifier := nc.Channels[i].Notifier(); notifier != nil {
+			notifiers = append(notifiers, notifier)
+		}
 	}
+	return notifiers
 }
 
-func (nc *NotificationsConfig) SendMessage(text string) []SendResult {
-	if !nc.Enabled {
-		return nil
-	}
-	results := make([]SendResult, 0, len(nc.TelegramTargetChatIDS))
-	for _, chatId := range nc.TelegramTargetChatIDS {
-		results = append(results, nc.SendTelegramMessage(text, chatId))
+// Notifier returns the enabled channel with the given name, or nil.
+func (nc *NotificationsConfig) Notifier(name string) Notifier {
+	for _, notifier := range nc.Notifiers() {
+		if notifier.Name() == name {
+			return notifier
+		}
 	}
-	return results
+	return nil
 }
 
+// NotificationConfigFileName is where older versions kept the notification settings.
+// The file is imported into the database once and renamed to NotificationConfigFileName + ".imported".
 const NotificationConfigFileName = "notifications.json"
 
-func LoadOrCreateNotificationsConfig() (*NotificationsConfig, error) {
-	f, err := os.Open(NotificationConfigFileName)
+// ReadNotificationsConfigFile reads a notifications config file of an older version.
+func ReadNotificationsConfigFile(name string) (*NotificationsConfig, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		if os.IsNotExist(err) {
-			cfg := &NotificationsConfig{
-				Enabled:               false,
-				TelegramBotToken:      "",
-				TelegramTargetChatIDS: make([]int64, 0),
-			}
-			if err = cfg.Save(); err != nil {
-				return nil, err
-			}
-			return cfg, nil
-		}
 		return nil, err
 	}
-
 	defer f.Close()
 	cfg := &NotificationsConfig{}
 	if err = json.NewDecoder(f).Decode(cfg); err != nil {
@@ -139,9 +190,61 @@ func LoadOrCreateNotificationsConfig() (*NotificationsConfig, error) {
 	return cfg, nil
 }
 
+// DefaultNotificationSettings are the settings before anything is configured.
+func DefaultNotificationSettings() NotificationSettings {
+	return NotificationSettings{
+		TelegramTargetChatIDS: make([]int64, 0),
+		Templates:             make(map[string]string),
+	}
+}
+
+// NotificationsConfig is everything notifications are sent with. It's loaded from the database and replaced as
+// a whole when it changes, so it must not be modified once it's in use.
 type NotificationsConfig struct {
+	NotificationSettings
+	// Channels are notified in addition to the telegram chats of TelegramTargetChatIDS.
+	Channels []ChannelConfig `json:"channels"`
+}
+
+// NotificationSettings are the notification settings besides the channels.
+type NotificationSettings struct {
 	Enabled               bool    `json:"enabled"`
 	TelegramBotToken      string  `json:"telegram_bot_token"`
 	TelegramTargetChatIDS []int64 `json:"telegram_target_chat_ids"`
-	// maybe save names of chats?
+	// UiBaseUrl is the address of the web UI, e.g. "https://procsman.example.com". If it's set, messages link to the process.
+	UiBaseUrl string `json:"ui_base_url"`
+	// Templates override DefaultNotificationTemplates, by event type.
+	Templates map[string]string `json:"templates"`
+	// TelegramApiUrl is the Bot API server of TelegramBotToken, DefaultTelegramApiUrl if it's empty.
+	// It can point to a local stand-in for testing.
+	TelegramApiUrl string `json:"telegram_api_url"`
+	// TelegramCommands lets chats control processes through the bot of TelegramBotToken.
+	TelegramCommands *TelegramCommandsConfig `json:"telegram_commands"`
+}
+
+const DefaultTelegramPollTimeout = 30 * time.Second
+
+type TelegramCommandsConfig struct {
+	Enabled bool `json:"enabled"`
+	// AllowedChatIDs are the only chats whose commands are accepted.
+	AllowedChatIDs []int64 `json:"allowed_chat_ids"`
+	// PollTimeout is how long getUpdates waits for updates, in seconds. DefaultTelegramPollTimeout is used if it's 0.
+	PollTimeout int `json:"poll_timeout"`
+}
+
+func (t *TelegramCommandsConfig) Validate() error {
+	if t.Enabled && len(t.AllowedChatIDs) == 0 {
+		return errors.New("telegram_commands.allowed_chat_ids is required to enable commands")
+	}
+	if t.PollTimeout < 0 || t.PollTimeout > 300 {
+		return errors.New("telegram_commands.poll_timeout must be between 0 and 300")
+	}
+	return nil
+}
+
+func (t *TelegramCommandsConfig) GetPollTimeout() time.Duration {
+	if t.PollTimeout <= 0 {
+		return DefaultTelegramPollTimeout
+	}
+	return time.Duration(t.PollTimeout) * time.Second
 }
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Notification is an event sent to the notification channels.
+// Text is the message for channels that only send text, the other fields are for channels with structured payloads.
+type Notification struct {
+	ProcessID      int32           `json:"process_id"`
+	ProcessName    string          `json:"process_name"`
+	GroupID        *int32          `json:"group_id"`
+	GroupName      string          `json:"group_name"`
+	Event          string          `json:"event"`
+	AdditionalInfo json.RawMessage `json:"additional_info"`
+	// Time is a unix timestamp.
+	Time int64  `json:"time"`
+	Text string `json:"text"`
+	// Link points to the process in the UI. It's set by NotificationsConfig.Send if UiBaseUrl is configured.
+	Link string `json:"link,omitempty"`
+
+	Hostname     string   `json:"hostname"`
+	RestartCount uint64   `json:"restart_count"`
+	RecentLogs   []string `json:"recent_logs,omitempty"`
+	// Output are the last lines of output attached to crash notifications. Channels deliver them next to the text,
+	// within their limits, so templates don't need to include them.
+	Output []string `json:"output,omitempty"`
+	// Templates are the templates of the process and its group, by event type.
+	Templates map[string]string `json:"-"`
+	// Targets are the channels the notification was routed to. nil sends it to all channels, empty to none.
+	Targets []Target `json:"-"`
+	// Composed is set for digests and flapping alerts, whose Text is not replaced by a template.
+	Composed bool `json:"-"`
+}
+
+// Notifier is a notification channel.
+type Notifier interface {
+	// Name identifies the channel in SendResult.
+	Name() string
+	// Send delivers n, one result per recipient. It blocks until the channel responds.
+	Send(n *Notification) []SendResult
+}
+
+type ChannelType string
+
+const (
+	ChannelTypeTelegram ChannelType = "telegram"
+	ChannelTypeWebhook  ChannelType = "webhook"
+	ChannelTypeEmail    ChannelType = "email"
+	// slack and mattermost accept the same messages, discord has its own format.
+	ChannelTypeSlack      ChannelType = "slack"
+	ChannelTypeMattermost ChannelType = "mattermost"
+	ChannelTypeDiscord    ChannelType = "discord"
+)
+
+// ChannelConfig is a configured notification channel. Only the settings of its Type are used.
+type ChannelConfig struct {
+	// ID is the id of the channel in the database, 0 for channels that are not stored yet.
+	ID      int32       `json:"id"`
+	Name    string      `json:"name"`
+	Type    ChannelType `json:"type"`
+	Enabled bool        `json:"enabled"`
+	// Events limits the channel to these event types. Empty means all of them.
+	Events []string `json:"events"`
+	// Templates override the global templates for this channel, by event type.
+	Templates map[string]string      `json:"templates"`
+	Telegram  *TelegramChannelConfig `json:"telegram,omitempty"`
+	Webhook   *WebhookChannelConfig  `json:"webhook,omitempty"`
+	Email     *EmailChannelConfig    `json:"email,omitempty"`
+	// ChatWebhook is used by slack, mattermost and discord channels.
+	ChatWebhook *ChatWebhookChannelConfig `json:"chat_webhook,omitempty"`
+}
+
+// Accepts reports whether the channel's event filter lets n through. Notifications without an event, like test messages, always pass.
+func (c *ChannelConfig) Accepts(n *Notification) bool {
+	return len(c.Events) == 0 || n.Event == "" || slices.Contains(c.Events, n.Event)
+}
+
+func (c *ChannelConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("channel name is empty")
+	}
+	switch c.Type {
+	case ChannelTypeTelegram:
+		if c.Telegram == nil || c.Telegram.BotToken == "" {
+			return fmt.Errorf("channel %s: telegram.bot_token is required", c.Name)
+		}
+		if err := ValidateTelegramApiUrl(c.Telegram.ApiUrl); err != nil {
+			return fmt.Errorf("channel %s: telegram.%w", c.Name, err)
+		}
+	case ChannelTypeWebhook:
+		if c.Webhook == nil {
+			return fmt.Errorf("channel %s: webhook settings are required", c.Name)
+		}
+		if err := c.Webhook.Validate(); err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+	case ChannelTypeEmail:
+		if c.Email == nil {
+			return fmt.Errorf("channel %s: email settings are required", c.Name)
+		}
+		if err := c.Email.Validate(); err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+	case ChannelTypeSlack, ChannelTypeMattermost, ChannelTypeDiscord:
+		if c.ChatWebhook == nil {
+			return fmt.Errorf("channel %s: chat_webhook settings are required", c.Name)
+		}
+		if err := c.ChatWebhook.Validate(); err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+	default:
+		return fmt.Errorf("channel %s: unknown type %q", c.Name, c.Type)
+	}
+	return nil
+}
+
+// Settings returns the settings of the channel's type, as they're stored in the database.
+func (c *ChannelConfig) Settings() ([]byte, error) {
+	var settings any
+	switch c.Type {
+	case ChannelTypeTelegram:
+		settings = c.Telegram
+	case ChannelTypeWebhook:
+		settings = c.Webhook
+	case ChannelTypeEmail:
+		settings = c.Email
+	case ChannelTypeSlack, ChannelTypeMattermost, ChannelTypeDiscord:
+		settings = c.ChatWebhook
+	default:
+		return nil, fmt.Errorf("channel %s: unknown type %q", c.Name, c.Type)
+	}
+	return json.Marshal(settings)
+}
+
+// SetSettings sets the settings of the channel's type from the form Settings returns.
+func (c *ChannelConfig) SetSettings(settings []byte) error {
+	switch c.Type {
+	case ChannelTypeTelegram:
+		c.Telegram = &TelegramChannelConfig{}
+		return json.Unmarshal(settings, c.Telegram)
+	case ChannelTypeWebhook:
+		c.Webhook = &WebhookChannelConfig{}
+		return json.Unmarshal(settings, c.Webhook)
+	case ChannelTypeEmail:
+		c.Email = &EmailChannelConfig{}
+		return json.Unmarshal(settings, c.Email)
+	case ChannelTypeSlack, ChannelTypeMattermost, ChannelTypeDiscord:
+		c.ChatWebhook = &ChatWebhookChannelConfig{}
+		return json.Unmarshal(settings, c.ChatWebhook)
+	}
+	return fmt.Errorf("channel %s: unknown type %q", c.Name, c.Type)
+}
+
+// Notifier returns the implementation of the channel, or nil if its settings are missing.
+func (c *ChannelConfig) Notifier() Notifier {
+	switch c.Type {
+	case ChannelTypeTelegram:
+		if c.Telegram != nil {
+			return &TelegramNotifier{ChannelName: c.Name, Config: *c.Telegram}
+		}
+	case ChannelTypeWebhook:
+		if c.Webhook != nil {
+			return &WebhookNotifier{ChannelName: c.Name, Config: *c.Webhook}
+		}
+	case ChannelTypeEmail:
+		if c.Email != nil {
+			return &EmailNotifier{ChannelName: c.Name, Config: *c.Email}
+		}
+	case ChannelTypeSlack, ChannelTypeMattermost, ChannelTypeDiscord:
+		if c.ChatWebhook != nil {
+			return &ChatWebhookNotifier{ChannelName: c.Name, Discord: c.Type == ChannelTypeDiscord, Config: *c.ChatWebhook}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// outputFileName is the name of the file the output of a crash is attached as, by channels that support files.
+func outputFileName(n *Notification) string {
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(n.ProcessName, "_"), "_")
+	if name == "" {
+		name = "process"
+	}
+	return name + "-output.txt"
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// outputBlock formats the output of n as a block of at most limit bytes, including its title. It's put in a markdown
+// code block if markdown is set. The oldest lines are dropped to fit, the newest ones usually explain the crash.
+// complete is false if lines were dropped. The block is empty if there is no output or not even its last line fits.
+func outputBlock(n *Notification, limit int, markdown bool) (block string, complete bool) {
+	title, end := "Last output:\n", ""
+	if markdown {
+		title, end = "Last output:\n```\n", "\n```"
+	}
+
+	lines := make([]string, len(n.Output))
+	size := len(lines) - 1
+	for i, line := range n.Output {
+		if markdown {
+			// "```" in the output would end the block early.
+			line = strings.ReplaceAll(line, "```", "'''")
+		}
+		lines[i] = line
+		size += len(line)
+	}
+	for dropped := range lines {
+		note := ""
+		if dropped > 0 {
+			note = fmt.Sprintf("(%d earlier lines omitted)\n", dropped)
+		}
+		if len(title)+len(note)+size+len(end) <= limit {
+			return title + note + strings.Join(lines[dropped:], "\n") + end, dropped == 0
+		}
+		size -= len(lines[dropped]) + 1
+	}
+	return "", false
+}
+
+// appendOutput appends the output of n to text, keeping the result within limit bytes.
+func appendOutput(text string, n *Notification, limit int, markdown bool) string {
+	if block, _ := outputBlock(n, limit-len(text)-2, markdown); block != "" {
+		return text + "\n\n" + block
+	}
+	return text
+}
+
+// outputText returns the output of n as the content of a text file.
+func outputText(n *Notification) []byte {
+	return []byte(strings.Join(n.Output, "\n") + "\n")
+}
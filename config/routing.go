@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strconv"
+)
+
+// Target is a channel a notification is routed to.
+// Recipients replace the recipients of the channel if any are given: chat ids for telegram channels, addresses for email channels.
+type Target struct {
+	Channel    string   `json:"channel"`
+	Recipients []string `json:"recipients"`
+}
+
+// channelType returns the type of a channel by name, and false if there is no such channel.
+func (nc *NotificationsConfig) channelType(name string) (ChannelType, bool) {
+	if name == "telegram" {
+		return ChannelTypeTelegram, true
+	}
+	if channel := nc.channel(name); channel != nil {
+		return channel.Type, true
+	}
+	return "", false
+}
+
+// ValidateTarget checks that the channel of t exists and its recipients are valid for it.
+func (nc *NotificationsConfig) ValidateTarget(t Target) error {
+	channelType, ok := nc.channelType(t.Channel)
+	if !ok {
+		return fmt.Errorf("there is no channel %q", t.Channel)
+	}
+	if len(t.Recipients) == 0 {
+		return nil
+	}
+	switch channelType {
+	case ChannelTypeTelegram:
+		if _, err := parseChatIDs(t.Recipients); err != nil {
+			return fmt.Errorf("channel %s: %w", t.Channel, err)
+		}
+	case ChannelTypeEmail:
+		for _, to := range t.Recipients {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("channel %s: recipient %q is invalid: %w", t.Channel, to, err)
+			}
+		}
+	default:
+		return fmt.Errorf("channel %s: %s channels don't have recipients", t.Channel, channelType)
+	}
+	return nil
+}
+
+func parseChatIDs(recipients []string) ([]int64, error) {
+	chatIDs := make([]int64, len(recipients))
+	for i, recipient := range recipients {
+		chatID, err := strconv.ParseInt(recipient, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %q is not a chat id", recipient)
+		}
+		chatIDs[i] = chatID
+	}
+	return chatIDs, nil
+}
+
+// targetNotifier returns the notifier for t, with its recipients, or nil if the channel doesn't exist or is disabled.
+func (nc *NotificationsConfig) targetNotifier(t Target) Notifier {
+	notifier := nc.Notifier(t.Channel)
+	if notifier == nil || len(t.Recipients) == 0 {
+		return notifier
+	}
+	switch notifier := notifier.(type) {
+	case *TelegramNotifier:
+		chatIDs, err := parseChatIDs(t.Recipients)
+		if err != nil {
+			return nil
+		}
+		routed := *notifier
+		routed.Config.ChatIDs = chatIDs
+		return &routed
+	case *EmailNotifier:
+		routed := *notifier
+		routed.Config.To = t.Recipients
+		return &routed
+	}
+	return notifier
+}
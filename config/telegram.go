@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTelegramApiUrl is the Bot API server used if none is configured.
+const DefaultTelegramApiUrl = "https://api.telegram.org"
+
+// telegramMaxMessageLength is the length limit of messages. It's in characters, so limiting bytes to it is safe.
+const telegramMaxMessageLength = 4096
+
+type TelegramChannelConfig struct {
+	BotToken string  `json:"bot_token"`
+	ChatIDs  []int64 `json:"chat_ids"`
+	// ApiUrl is the Bot API server, DefaultTelegramApiUrl if it's empty.
+	ApiUrl string `json:"api_url"`
+}
+
+// TelegramNotifier sends the text of notifications to telegram chats.
+type TelegramNotifier struct {
+	ChannelName string
+	Config      TelegramChannelConfig
+}
+
+func (t *TelegramNotifier) Name() string {
+	return t.ChannelName
+}
+
+func (t *TelegramNotifier) Send(n *Notification) []SendResult {
+	results := make([]SendResult, 0, len(t.Config.ChatIDs))
+	for _, chatId := range t.Config.ChatIDs {
+		res := t.send(n, chatId)
+		res.Channel = t.ChannelName
+		results = append(results, res)
+	}
+	return results
+}
+
+// send sends n to a chat. Output that doesn't fit in the message is sent as a document in reply to it.
+// The message is what the result is about, a document that could not be sent is only reported in its error.
+func (t *TelegramNotifier) send(n *Notification, chatId int64) SendResult {
+	text := n.Text
+	block, complete := outputBlock(n, telegramMaxMessageLength-len(text)-2, false)
+	if complete {
+		text += "\n\n" + block
+	}
+	res := sendTelegramMessage(t.Config.ApiUrl, t.Config.BotToken, text, chatId, nil)
+	if res.Success && len(n.Output) > 0 && !complete {
+		err := sendTelegramDocument(t.Config.ApiUrl, t.Config.BotToken, chatId, outputFileName(n), outputText(n), res.MessageId)
+		if err != nil {
+			res.Error = "output not attached: " + err.Error()
+		}
+	}
+	return res
+}
+
+// ValidateTelegramApiUrl checks a Bot API server url, an empty one means DefaultTelegramApiUrl.
+func ValidateTelegramApiUrl(apiUrl string) error {
+	if apiUrl == "" {
+		return nil
+	}
+	u, err := url.Parse(apiUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("api_url must be an http or https url")
+	}
+	return nil
+}
+
+// telegramMethodUrl returns the url of a Bot API method.
+func telegramMethodUrl(apiUrl, botToken, method string) string {
+	if apiUrl == "" {
+		apiUrl = DefaultTelegramApiUrl
+	}
+	return strings.TrimRight(apiUrl, "/") + "/bot" + botToken + "/" + method
+}
+
+// sendTelegramMessage sends text to a chat. extra are added to the sendMessage parameters, e.g. reply_markup.
+func sendTelegramMessage(apiUrl, botToken, text string, chatId int64, extra map[string]interface{}) SendResult {
+	requestMap := map[string]interface{}{
+		"chat_id": chatId,
+		"text":    text,
+	}
+	for key, value := range extra {
+		requestMap[key] = value
+	}
+
+	var reader io.Reader
+	if b, err := json.Marshal(requestMap); err != nil {
+		return SendResult{
+			ChatId: chatId,
+			Error:  err.Error(),
+		}
+	} else {
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest("POST", telegramMethodUrl(apiUrl, botToken, "sendMessage"), reader)
+	if err != nil {
+		return SendResult{
+			ChatId: chatId,
+			Error:  withoutUrl(err).Error(),
+		}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return SendResult{
+			ChatId: chatId,
+			Error:  withoutUrl(err).Error(),
+		}
+	}
+
+	defer resp.Body.Close()
+	var responseMap map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&responseMap); err != nil {
+		return SendResult{
+			ChatId: chatId,
+			Error:  err.Error(),
+		}
+	}
+
+	if b, ok := responseMap["ok"].(bool); !ok || !b || resp.StatusCode >= 400 {
+		var description string
+		if desc, ok2 := responseMap["description"].(string); ok2 {
+			description = desc
+		} else {
+			description = "unknown error"
+		}
+		return SendResult{
+			ChatId: chatId,
+			Error:  description,
+		}
+	}
+
+	messageId, ok := responseMap["result"].(map[string]interface{})["message_id"].(float64)
+
+	if !ok {
+		return SendResult{
+			ChatId: chatId,
+			Error:  "could not get message_id",
+		}
+	}
+
+	return SendResult{
+		ChatId:    chatId,
+		MessageId: int(messageId),
+		Success:   true,
+	}
+}
+
+type TelegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type TelegramUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type TelegramMessage struct {
+	MessageID int           `json:"message_id"`
+	From      *TelegramUser `json:"from"`
+	Chat      TelegramChat  `json:"chat"`
+	Text      string        `json:"text"`
+}
+
+// TelegramCallbackQuery is sent when an inline keyboard button is pressed.
+type TelegramCallbackQuery struct {
+	ID      string           `json:"id"`
+	From    TelegramUser     `json:"from"`
+	Message *TelegramMessage `json:"message"`
+	Data    string           `json:"data"`
+}
+
+// TelegramUpdate is an update from getUpdates. Only messages and callback queries are requested.
+type TelegramUpdate struct {
+	UpdateID      int64                  `json:"update_id"`
+	Message       *TelegramMessage       `json:"message"`
+	CallbackQuery *TelegramCallbackQuery `json:"callback_query"`
+}
+
+// TelegramButton is an inline keyboard button that sends CallbackData back when it's pressed.
+type TelegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// callTelegram calls a Bot API method and decodes its result into result, if it's not nil.
+func callTelegram(apiUrl, botToken, method string, params interface{}, result interface{}, timeout time.Duration) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", telegramMethodUrl(apiUrl, botToken, method), bytes.NewReader(body))
+	if err != nil {
+		return withoutUrl(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doTelegramRequest(req, method, result, timeout)
+}
+
+// sendTelegramDocument uploads content as a file named name, in reply to the message replyTo if it's not 0.
+func sendTelegramDocument(apiUrl, botToken string, chatId int64, name string, content []byte, replyTo int) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("chat_id", strconv.FormatInt(chatId, 10))
+	if replyTo != 0 {
+		_ = form.WriteField("reply_to_message_id", strconv.Itoa(replyTo))
+	}
+	file, err := form.CreateFormFile("document", name)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err != nil {
+		return err
+	}
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", telegramMethodUrl(apiUrl, botToken, "sendDocument"), &body)
+	if err != nil {
+		return withoutUrl(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return doTelegramRequest(req, "sendDocument", nil, httpClient.Timeout)
+}
+
+// doTelegramRequest sends a request to a Bot API method and decodes its result into result, if it's not nil.
+func doTelegramRequest(req *http.Request, method string, result interface{}, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return withoutUrl(err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !response.Ok {
+		if response.Description == "" {
+			response.Description = resp.Status
+		}
+		return fmt.Errorf("%s: %s", method, response.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultNotificationTemplates are used for event types without a configured template.
+// THRESHOLD messages are composed by the threshold rule, so its default only prints them.
+var DefaultNotificationTemplates = map[string]string{
+	"START":            "Process {{.ProcessName}} has started",
+	"STOP":             "Process {{.ProcessName}} has stopped",
+	"CRASH":            "Process {{.ProcessName}} has crashed" + exitDetailsTemplate + "{{if .RestartAttempt}}, restart attempt {{.RestartAttempt}}{{end}}",
+	"FULL_STOP":        "Process {{.ProcessName}} has fully stopped",
+	"FULL_CRASH":       "Process {{.ProcessName}} has fully crashed" + exitDetailsTemplate + "{{if .RestartAttempt}}, gave up after {{.RestartAttempt}} restarts{{end}}",
+	"MANUALLY_STOPPED": "Process {{.ProcessName}} has been manually stopped",
+	"RESTART":          "Process {{.ProcessName}} has been restarted",
+	"THRESHOLD":        "{{.Text}}",
+}
+
+// exitDetailsTemplate describes how a process exited, e.g. " (SIGSEGV) after 1h2m3s".
+const exitDetailsTemplate = "{{if .ExitSignal}} ({{.ExitSignal}}){{else if .ExitCode}} (exit code {{.ExitCode}}){{end}}{{if .Uptime}} after {{.Uptime}}{{end}}"
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// TemplateData is what notification templates are executed with.
+type TemplateData struct {
+	ProcessID   int32
+	ProcessName string
+	GroupName   string
+	Hostname    string
+	Event       string
+	// ExitCode is nil for events that are not about the process exiting.
+	ExitCode     *int
+	RestartCount uint64
+	Time         time.Time
+	// RecentLogs is only set if the process has crash_output_lines.
+	RecentLogs []string
+	// ExitSignal, Uptime and RestartAttempt are set for events about the process exiting, if they are known.
+	ExitSignal     string
+	Uptime         time.Duration
+	RestartAttempt int
+	// Output are the lines attached to crash notifications, which channels deliver on their own.
+	Output []string
+	// AdditionalInfo is the decoded additional_info of the event.
+	AdditionalInfo map[string]any
+	// Text is the message the event was created with, if there is one.
+	Text string
+}
+
+func newTemplateData(n *Notification) TemplateData {
+	data := TemplateData{
+		ProcessID:    n.ProcessID,
+		ProcessName:  n.ProcessName,
+		GroupName:    n.GroupName,
+		Hostname:     n.Hostname,
+		Event:        n.Event,
+		RestartCount: n.RestartCount,
+		Time:         time.Unix(n.Time, 0).UTC(),
+		RecentLogs:   n.RecentLogs,
+		Output:       n.Output,
+		Text:         n.Text,
+	}
+	if len(n.AdditionalInfo) > 0 {
+		_ = json.Unmarshal(n.AdditionalInfo, &data.AdditionalInfo)
+		var info struct {
+			ExitCode       *int   `json:"exit_code"`
+			Signal         string `json:"signal"`
+			Uptime         int64  `json:"uptime"`
+			RestartAttempt int    `json:"restart_attempt"`
+		}
+		if json.Unmarshal(n.AdditionalInfo, &info) == nil {
+			data.ExitCode = info.ExitCode
+			data.ExitSignal = info.Signal
+			data.Uptime = time.Duration(info.Uptime) * time.Second
+			data.RestartAttempt = info.RestartAttempt
+		}
+	}
+	return data
+}
+
+// ParseNotificationTemplate parses a template. Missing keys of AdditionalInfo don't make it fail.
+func ParseNotificationTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// RenderNotification executes the template text for n.
+func RenderNotification(text string, n *Notification) (string, error) {
+	tmpl, err := ParseNotificationTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err = tmpl.Execute(&b, newTemplateData(n)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// notificationTemplate returns the template for n on the given channel (nil for the telegram bot of TelegramBotToken).
+// Process and group templates take precedence over channel templates, which take precedence over global ones.
+func (nc *NotificationsConfig) notificationTemplate(n *Notification, channel *ChannelConfig) string {
+	if tmpl, ok := n.Templates[n.Event]; ok {
+		return tmpl
+	}
+	if channel != nil {
+		if tmpl, ok := channel.Templates[n.Event]; ok {
+			return tmpl
+		}
+	}
+	if tmpl, ok := nc.Templates[n.Event]; ok {
+		return tmpl
+	}
+	return DefaultNotificationTemplates[n.Event]
+}
+
+// renderText returns the text of n for a channel. Notifications without an event and composed ones keep their text.
+// If the configured template fails, the default one is used, so the notification is still sent.
+func (nc *NotificationsConfig) renderText(n *Notification, channel *ChannelConfig) string {
+	if n.Event == "" || n.Composed {
+		return n.Text
+	}
+	if text, err := RenderNotification(nc.notificationTemplate(n, channel), n); err == nil && text != "" {
+		return text
+	}
+	if text, err := RenderNotification(DefaultNotificationTemplates[n.Event], n); err == nil && text != "" {
+		return text
+	}
+	return n.Text
+}
+
+// SampleNotification returns a made up notification for event, to preview templates without a real event.
+func SampleNotification(event string) *Notification {
+	n := &Notification{
+		ProcessID:    1,
+		ProcessName:  "example",
+		GroupName:    "default",
+		Event:        event,
+		Time:         time.Now().Unix(),
+		RestartCount: 2,
+		RecentLogs:   []string{"listening on :8080", "panic: runtime error: index out of range"},
+		Text:         "Process example crossed a threshold",
+	}
+	n.Hostname, _ = os.Hostname()
+	switch event {
+	case "STOP", "FULL_STOP", "MANUALLY_STOPPED":
+		n.AdditionalInfo = []byte(`{"exit_code":0,"uptime":3725}`)
+	case "CRASH", "FULL_CRASH":
+		n.AdditionalInfo = []byte(`{"exit_code":2,"uptime":42,"restart_attempt":2}`)
+		n.Output = n.RecentLogs
+	}
+	return n
+}
+
+// Preview renders n like Send would for the given channel ("" for none). If text is empty,
+// the template that would be used is rendered, otherwise text itself is.
+func (nc *NotificationsConfig) Preview(text string, n *Notification, channelName string) (string, error) {
+	channel := nc.channel(channelName)
+	if text == "" {
+		text = nc.notificationTemplate(n, channel)
+	}
+	return RenderNotification(text, n)
+}
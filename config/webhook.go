@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>", keyed with the secret.
+	WebhookSignatureHeader = "X-Procsman-Signature"
+	// WebhookTimestampHeader is the unix timestamp the signature was made at, receivers should reject old ones.
+	WebhookTimestampHeader = "X-Procsman-Timestamp"
+
+	DefaultWebhookTimeout = 10 * time.Second
+)
+
+type WebhookChannelConfig struct {
+	URL string `json:"url"`
+	// Secret signs the payload. If it's empty, the payload is not signed.
+	Secret  string            `json:"secret"`
+	Headers map[string]string `json:"headers"`
+	// Timeout is in seconds, DefaultWebhookTimeout is used if it's 0.
+	Timeout int `json:"timeout"`
+}
+
+func (w *WebhookChannelConfig) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook.url must be an http or https url")
+	}
+	if w.Timeout < 0 {
+		return errors.New("webhook.timeout must not be negative")
+	}
+	return nil
+}
+
+func (w *WebhookChannelConfig) GetTimeout() time.Duration {
+	if w.Timeout == 0 {
+		return DefaultWebhookTimeout
+	}
+	return time.Duration(w.Timeout) * time.Second
+}
+
+// WebhookProcess is the process in a WebhookPayload.
+type WebhookProcess struct {
+	ID      int32  `json:"id"`
+	Name    string `json:"name"`
+	GroupID *int32 `json:"group_id"`
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks.
+type WebhookPayload struct {
+	Process        *WebhookProcess `json:"process"`
+	Event          string          `json:"event"`
+	AdditionalInfo json.RawMessage `json:"additional_info"`
+	Timestamp      int64           `json:"timestamp"`
+	Text           string          `json:"text"`
+	// Output are the last lines of output of a crashed process, if the process attaches them.
+	Output []string `json:"output,omitempty"`
+}
+
+// WebhookNotifier POSTs notifications as WebhookPayload to a URL.
+type WebhookNotifier struct {
+	ChannelName string
+	Config      WebhookChannelConfig
+}
+
+func (w *WebhookNotifier) Name() string {
+	return w.ChannelName
+}
+
+// SignWebhook returns the value of WebhookSignatureHeader for body.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookNotifier) Send(n *Notification) []SendResult {
+	payload := WebhookPayload{
+		Event:          n.Event,
+		AdditionalInfo: n.AdditionalInfo,
+		Timestamp:      n.Time,
+		Text:           n.Text,
+		Output:         n.Output,
+	}
+	if n.ProcessID != 0 {
+		payload.Process = &WebhookProcess{ID: n.ProcessID, Name: n.ProcessName, GroupID: n.GroupID}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return []SendResult{{Channel: w.ChannelName, Error: err.Error()}}
+	}
+	return []SendResult{w.post(body)}
+}
+
+func (w *WebhookNotifier) post(body []byte) SendResult {
+	headers := make(map[string]string, len(w.Config.Headers)+2)
+	for name, value := range w.Config.Headers {
+		headers[name] = value
+	}
+	if w.Config.Secret != "" {
+		timestamp := time.Now().Unix()
+		headers[WebhookTimestampHeader] = strconv.FormatInt(timestamp, 10)
+		headers[WebhookSignatureHeader] = SignWebhook(w.Config.Secret, timestamp, body)
+	}
+	res := postJson(w.Config.URL, headers, w.Config.GetTimeout(), body)
+	res.Channel = w.ChannelName
+	return res
+}
+
+// postJson POSTs body to url. Any 2xx response is a success.
+func postJson(url string, headers map[string]string, timeout time.Duration, body []byte) SendResult {
+	var res SendResult
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		res.Error = withoutUrl(err).Error()
+		return res
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = withoutUrl(err).Error()
+		return res
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 300 {
+		res.Error = fmt.Sprintf("webhook responded with %s", resp.Status)
+		return res
+	}
+	res.Success = true
+	return res
+}
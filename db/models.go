@@ -22,6 +22,7 @@ const (
 	ProcessEventTypeFULLCRASH       ProcessEventType = "FULL_CRASH"
 	ProcessEventTypeMANUALLYSTOPPED ProcessEventType = "MANUALLY_STOPPED"
 	ProcessEventTypeRESTART         ProcessEventType = "RESTART"
+	ProcessEventTypeTHRESHOLD       ProcessEventType = "THRESHOLD"
 )
 
 func (e *ProcessEventType) Scan(src interface{}) error {
@@ -107,6 +108,47 @@ func (ns NullProcessStatus) Value() (driver.Value, error) {
 	return string(ns.ProcessStatus), nil
 }
 
+type ApiKey struct {
+	ID         int32            `json:"id"`
+	Name       string           `json:"name"`
+	KeyHash    []byte           `json:"key_hash"`
+	Scope      string           `json:"scope"`
+	GroupIds   []int32          `json:"group_ids"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	ExpiresAt  pgtype.Timestamp `json:"expires_at"`
+	LastUsedAt pgtype.Timestamp `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamp `json:"revoked_at"`
+}
+
+type AuditLog struct {
+	ID         int32            `json:"id"`
+	CreatedAt  pgtype.Timestamp `json:"created_at"`
+	Actor      string           `json:"actor"`
+	RemoteAddr string           `json:"remote_addr"`
+	RequestID  string           `json:"request_id"`
+	Method     string           `json:"method"`
+	Route      string           `json:"route"`
+	Action     string           `json:"action"`
+	TargetType string           `json:"target_type"`
+	TargetID   pgtype.Int4      `json:"target_id"`
+	Changes    []byte           `json:"changes"`
+}
+
+type HostStat struct {
+	ID                 int32            `json:"id"`
+	CreatedAt          pgtype.Timestamp `json:"created_at"`
+	CpuUsagePercentage float64          `json:"cpu_usage_percentage"`
+	MemoryTotal        int64            `json:"memory_total"`
+	MemoryUsed         int64            `json:"memory_used"`
+	SwapTotal          int64            `json:"swap_total"`
+	SwapUsed           int64            `json:"swap_used"`
+	Load1              float64          `json:"load_1"`
+	Load5              float64          `json:"load_5"`
+	Load15             float64          `json:"load_15"`
+	DiskTotal          int64            `json:"disk_total"`
+	DiskUsed           int64            `json:"disk_used"`
+}
+
 type Log struct {
 	ID        int32            `json:"id"`
 	ProcessID pgtype.Int4      `json:"process_id"`
@@ -115,6 +157,62 @@ type Log struct {
 	Path      string           `json:"path"`
 }
 
+type NotificationAttempt struct {
+	ID        int32            `json:"id"`
+	OutboxID  int32            `json:"outbox_id"`
+	Attempt   int32            `json:"attempt"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	Success   bool             `json:"success"`
+	Recipient string           `json:"recipient"`
+	ChatID    pgtype.Int8      `json:"chat_id"`
+	MessageID pgtype.Int4      `json:"message_id"`
+	Error     string           `json:"error"`
+}
+
+type NotificationChannel struct {
+	ID        int32             `json:"id"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Enabled   bool              `json:"enabled"`
+	Events    []string          `json:"events"`
+	Templates map[string]string `json:"templates"`
+	Settings  []byte            `json:"settings"`
+	CreatedAt pgtype.Timestamp  `json:"created_at"`
+}
+
+type NotificationOutbox struct {
+	ID            int32            `json:"id"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	ProcessID     pgtype.Int4      `json:"process_id"`
+	Event         string           `json:"event"`
+	Channel       string           `json:"channel"`
+	Recipients    []string         `json:"recipients"`
+	Notification  []byte           `json:"notification"`
+	Status        string           `json:"status"`
+	Attempts      int32            `json:"attempts"`
+	NextAttemptAt pgtype.Timestamp `json:"next_attempt_at"`
+	FinishedAt    pgtype.Timestamp `json:"finished_at"`
+}
+
+type NotificationRoute struct {
+	ID               int32               `json:"id"`
+	Name             string              `json:"name"`
+	Position         int32               `json:"position"`
+	IsDefault        bool                `json:"is_default"`
+	ProcessIds       []int32             `json:"process_ids"`
+	GroupIds         []int32             `json:"group_ids"`
+	Events           []string            `json:"events"`
+	Targets          NotificationTargets `json:"targets"`
+	ContinueMatching bool                `json:"continue_matching"`
+	CreatedAt        pgtype.Timestamp    `json:"created_at"`
+}
+
+type NotificationSetting struct {
+	ID        int32            `json:"id"`
+	Settings  []byte           `json:"settings"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+}
+
 type Process struct {
 	ID               int32             `json:"id"`
 	Name             string            `json:"name"`
@@ -135,6 +233,7 @@ type ProcessEvent struct {
 	Event          ProcessEventType `json:"event"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 	AdditionalInfo []byte           `json:"additional_info"`
+	AuditID        pgtype.Int4      `json:"audit_id"`
 }
 
 type ProcessGroup struct {
@@ -145,10 +244,51 @@ type ProcessGroup struct {
 }
 
 type ProcessStat struct {
-	ID                 int32            `json:"id"`
-	ProcessID          pgtype.Int4      `json:"process_id"`
-	CreatedAt          pgtype.Timestamp `json:"created_at"`
-	CpuUsage           int64            `json:"cpu_usage"`
-	CpuUsagePercentage float64          `json:"cpu_usage_percentage"`
-	MemoryUsage        int64            `json:"memory_usage"`
+	ID                     int32            `json:"id"`
+	ProcessID              pgtype.Int4      `json:"process_id"`
+	CreatedAt              pgtype.Timestamp `json:"created_at"`
+	CpuUsage               int64            `json:"cpu_usage"`
+	CpuUsagePercentage     float64          `json:"cpu_usage_percentage"`
+	MemoryUsage            int64            `json:"memory_usage"`
+	ThreadCount            pgtype.Int4      `json:"thread_count"`
+	OpenFds                pgtype.Int4      `json:"open_fds"`
+	IoReadBytes            pgtype.Int8      `json:"io_read_bytes"`
+	IoWriteBytes           pgtype.Int8      `json:"io_write_bytes"`
+	CtxSwitchesVoluntary   pgtype.Int8      `json:"ctx_switches_voluntary"`
+	CtxSwitchesInvoluntary pgtype.Int8      `json:"ctx_switches_involuntary"`
+	ChildCount             pgtype.Int4      `json:"child_count"`
+}
+
+type ProcessStatsRollup struct {
+	ProcessID  int32            `json:"process_id"`
+	Resolution int32            `json:"resolution"`
+	Bucket     pgtype.Timestamp `json:"bucket"`
+	Samples    int32            `json:"samples"`
+	CpuUsage   int64            `json:"cpu_usage"`
+	CpuAvg     float64          `json:"cpu_avg"`
+	CpuMin     float64          `json:"cpu_min"`
+	CpuMax     float64          `json:"cpu_max"`
+	CpuP95     float64          `json:"cpu_p95"`
+	MemoryAvg  int64            `json:"memory_avg"`
+	MemoryMin  int64            `json:"memory_min"`
+	MemoryMax  int64            `json:"memory_max"`
+	MemoryP95  int64            `json:"memory_p95"`
+}
+
+type User struct {
+	ID                int32            `json:"id"`
+	Username          string           `json:"username"`
+	PasswordHash      string           `json:"password_hash"`
+	Role              string           `json:"role"`
+	CreatedAt         pgtype.Timestamp `json:"created_at"`
+	PasswordChangedAt pgtype.Timestamp `json:"password_changed_at"`
+}
+
+type UserSession struct {
+	ID          int32            `json:"id"`
+	UserID      int32            `json:"user_id"`
+	RefreshHash []byte           `json:"refresh_hash"`
+	CreatedAt   pgtype.Timestamp `json:"created_at"`
+	ExpiresAt   pgtype.Timestamp `json:"expires_at"`
+	RevokedAt   pgtype.Timestamp `json:"revoked_at"`
 }
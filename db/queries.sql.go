@@ -11,6 +11,162 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const clearDefaultNotificationRoute = `-- name: ClearDefaultNotificationRoute :exec
+UPDATE notification_routes
+SET is_default = FALSE
+WHERE is_default
+  AND id != $1
+`
+
+// there can only be one default route.
+func (q *Queries) ClearDefaultNotificationRoute(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, clearDefaultNotificationRoute, id)
+	return err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT count(*)
+FROM users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createApiKey = `-- name: CreateApiKey :one
+INSERT INTO api_keys (name, key_hash, scope, group_ids, expires_at)
+VALUES ($1, $2, $3, $4, $5) RETURNING id, name, key_hash, scope, group_ids, created_at, expires_at, last_used_at, revoked_at
+`
+
+type CreateApiKeyParams struct {
+	Name      string           `json:"name"`
+	KeyHash   []byte           `json:"key_hash"`
+	Scope     string           `json:"scope"`
+	GroupIds  []int32          `json:"group_ids"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, createApiKey,
+		arg.Name,
+		arg.KeyHash,
+		arg.Scope,
+		arg.GroupIds,
+		arg.ExpiresAt,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scope,
+		&i.GroupIds,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const createNotificationChannel = `-- name: CreateNotificationChannel :one
+INSERT INTO notification_channels (name, type, enabled, events, templates, settings)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, type, enabled, events, templates, settings, created_at
+`
+
+type CreateNotificationChannelParams struct {
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Enabled   bool              `json:"enabled"`
+	Events    []string          `json:"events"`
+	Templates map[string]string `json:"templates"`
+	Settings  []byte            `json:"settings"`
+}
+
+func (q *Queries) CreateNotificationChannel(ctx context.Context, arg CreateNotificationChannelParams) (NotificationChannel, error) {
+	row := q.db.QueryRow(ctx, createNotificationChannel,
+		arg.Name,
+		arg.Type,
+		arg.Enabled,
+		arg.Events,
+		arg.Templates,
+		arg.Settings,
+	)
+	var i NotificationChannel
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Type,
+		&i.Enabled,
+		&i.Events,
+		&i.Templates,
+		&i.Settings,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createNotificationRoute = `-- name: CreateNotificationRoute :one
+INSERT INTO notification_routes (name, position, is_default, process_ids, group_ids, events, targets, continue_matching)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, name, position, is_default, process_ids, group_ids, events, targets, continue_matching, created_at
+`
+
+type CreateNotificationRouteParams struct {
+	Name             string              `json:"name"`
+	Position         int32               `json:"position"`
+	IsDefault        bool                `json:"is_default"`
+	ProcessIds       []int32             `json:"process_ids"`
+	GroupIds         []int32             `json:"group_ids"`
+	Events           []string            `json:"events"`
+	Targets          NotificationTargets `json:"targets"`
+	ContinueMatching bool                `json:"continue_matching"`
+}
+
+func (q *Queries) CreateNotificationRoute(ctx context.Context, arg CreateNotificationRouteParams) (NotificationRoute, error) {
+	row := q.db.QueryRow(ctx, createNotificationRoute,
+		arg.Name,
+		arg.Position,
+		arg.IsDefault,
+		arg.ProcessIds,
+		arg.GroupIds,
+		arg.Events,
+		arg.Targets,
+		arg.ContinueMatching,
+	)
+	var i NotificationRoute
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Position,
+		&i.IsDefault,
+		&i.ProcessIds,
+		&i.GroupIds,
+		&i.Events,
+		&i.Targets,
+		&i.ContinueMatching,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createNotificationSettings = `-- name: CreateNotificationSettings :execrows
+INSERT INTO notification_settings (settings)
+VALUES ($1)
+ON CONFLICT (id) DO NOTHING
+`
+
+// nothing is inserted if the settings exist, so only one instance imports notifications.json.
+func (q *Queries) CreateNotificationSettings(ctx context.Context, settings []byte) (int64, error) {
+	result, err := q.db.Exec(ctx, createNotificationSettings, settings)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const createProcess = `-- name: CreateProcess :one
 INSERT INTO process (name, process_group_id, color, executable_path, arguments, working_directory, environment,
                      configuration, enabled)
@@ -81,6 +237,114 @@ func (q *Queries) CreateProcessGroup(ctx context.Context, arg CreateProcessGroup
 	return i, err
 }
 
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (username, password_hash, role)
+VALUES ($1, $2, $3) RETURNING id, username, password_hash, role, created_at, password_changed_at
+`
+
+type CreateUserParams struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createUser, arg.Username, arg.PasswordHash, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.PasswordChangedAt,
+	)
+	return i, err
+}
+
+const createUserSession = `-- name: CreateUserSession :one
+INSERT INTO user_sessions (user_id, refresh_hash, expires_at)
+VALUES ($1, $2, $3) RETURNING id, user_id, refresh_hash, created_at, expires_at, revoked_at
+`
+
+type CreateUserSessionParams struct {
+	UserID      int32            `json:"user_id"`
+	RefreshHash []byte           `json:"refresh_hash"`
+	ExpiresAt   pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateUserSession(ctx context.Context, arg CreateUserSessionParams) (UserSession, error) {
+	row := q.db.QueryRow(ctx, createUserSession, arg.UserID, arg.RefreshHash, arg.ExpiresAt)
+	var i UserSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const deleteAllNotificationChannels = `-- name: DeleteAllNotificationChannels :exec
+DELETE
+FROM notification_channels
+`
+
+func (q *Queries) DeleteAllNotificationChannels(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteAllNotificationChannels)
+	return err
+}
+
+const deleteFinishedNotificationsBefore = `-- name: DeleteFinishedNotificationsBefore :exec
+DELETE
+FROM notification_outbox
+WHERE status != 'pending'
+  AND finished_at < $1
+`
+
+func (q *Queries) DeleteFinishedNotificationsBefore(ctx context.Context, finishedAt pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, deleteFinishedNotificationsBefore, finishedAt)
+	return err
+}
+
+const deleteHostStatsBefore = `-- name: DeleteHostStatsBefore :execrows
+DELETE
+FROM host_stats
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteHostStatsBefore(ctx context.Context, createdAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteHostStatsBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteNotificationChannel = `-- name: DeleteNotificationChannel :exec
+DELETE
+FROM notification_channels
+WHERE id = $1
+`
+
+func (q *Queries) DeleteNotificationChannel(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteNotificationChannel, id)
+	return err
+}
+
+const deleteNotificationRoute = `-- name: DeleteNotificationRoute :exec
+DELETE
+FROM notification_routes
+WHERE id = $1
+`
+
+func (q *Queries) DeleteNotificationRoute(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteNotificationRoute, id)
+	return err
+}
+
 const deleteProcess = `-- name: DeleteProcess :exec
 DELETE
 FROM process
@@ -103,6 +367,51 @@ func (q *Queries) DeleteProcessGroup(ctx context.Context, id int32) error {
 	return err
 }
 
+const deleteProcessStatsBefore = `-- name: DeleteProcessStatsBefore :execrows
+DELETE
+FROM process_stats
+WHERE created_at < $1
+`
+
+func (q *Queries) DeleteProcessStatsBefore(ctx context.Context, createdAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProcessStatsBefore, createdAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteProcessStatsRollupBefore = `-- name: DeleteProcessStatsRollupBefore :execrows
+DELETE
+FROM process_stats_rollup
+WHERE resolution = $1
+  AND bucket < $2
+`
+
+type DeleteProcessStatsRollupBeforeParams struct {
+	Resolution int32            `json:"resolution"`
+	Bucket     pgtype.Timestamp `json:"bucket"`
+}
+
+func (q *Queries) DeleteProcessStatsRollupBefore(ctx context.Context, arg DeleteProcessStatsRollupBeforeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProcessStatsRollupBefore, arg.Resolution, arg.Bucket)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
+	return err
+}
+
 const getAllLogFiles = `-- name: GetAllLogFiles :many
 SELECT id, process_id, start_time, end_time, path
 FROM logs
@@ -137,7 +446,7 @@ func (q *Queries) GetAllLogFiles(ctx context.Context, processID pgtype.Int4) ([]
 }
 
 const getAllProcessEvents = `-- name: GetAllProcessEvents :many
-SELECT id, process_id, event, created_at, additional_info
+SELECT id, process_id, event, created_at, additional_info, audit_id
 FROM process_event
 ORDER BY id ASC
 `
@@ -157,6 +466,383 @@ func (q *Queries) GetAllProcessEvents(ctx context.Context) ([]ProcessEvent, erro
 			&i.Event,
 			&i.CreatedAt,
 			&i.AdditionalInfo,
+			&i.AuditID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getApiKey = `-- name: GetApiKey :one
+SELECT id, name, key_hash, scope, group_ids, created_at, expires_at, last_used_at, revoked_at
+FROM api_keys
+WHERE id = $1
+`
+
+func (q *Queries) GetApiKey(ctx context.Context, id int32) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getApiKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scope,
+		&i.GroupIds,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getApiKeyByHash = `-- name: GetApiKeyByHash :one
+SELECT id, name, key_hash, scope, group_ids, created_at, expires_at, last_used_at, revoked_at
+FROM api_keys
+WHERE key_hash = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) GetApiKeyByHash(ctx context.Context, keyHash []byte) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getApiKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scope,
+		&i.GroupIds,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getApiKeys = `-- name: GetApiKeys :many
+SELECT id, name, key_hash, scope, group_ids, created_at, expires_at, last_used_at, revoked_at
+FROM api_keys
+ORDER BY id
+`
+
+func (q *Queries) GetApiKeys(ctx context.Context) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, getApiKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiKey{}
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.KeyHash,
+			&i.Scope,
+			&i.GroupIds,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAuditLog = `-- name: GetAuditLog :many
+SELECT id, created_at, actor, remote_addr, request_id, method, route, action, target_type, target_id, changes
+FROM audit_log
+WHERE ($1::varchar IS NULL OR actor = $1::varchar)
+  AND ($2::varchar IS NULL OR action = $2::varchar)
+  AND ($3::varchar IS NULL OR target_type = $3::varchar)
+  AND ($4::integer IS NULL OR target_id = $4::integer)
+  AND created_at >= $5
+  AND created_at <= $6
+  AND ($7::integer IS NULL OR id < $7::integer)
+ORDER BY id DESC
+LIMIT $8
+`
+
+type GetAuditLogParams struct {
+	Actor      pgtype.Text      `json:"actor"`
+	Action     pgtype.Text      `json:"action"`
+	TargetType pgtype.Text      `json:"target_type"`
+	TargetID   pgtype.Int4      `json:"target_id"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+	BeforeID   pgtype.Int4      `json:"before_id"`
+	RowLimit   int32            `json:"row_limit"`
+}
+
+// newest first. Empty filters match everything, before_id is the keyset cursor.
+func (q *Queries) GetAuditLog(ctx context.Context, arg GetAuditLogParams) ([]AuditLog, error) {
+	rows, err := q.db.Query(ctx, getAuditLog,
+		arg.Actor,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.RangeFrom,
+		arg.RangeTo,
+		arg.BeforeID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AuditLog{}
+	for rows.Next() {
+		var i AuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Actor,
+			&i.RemoteAddr,
+			&i.RequestID,
+			&i.Method,
+			&i.Route,
+			&i.Action,
+			&i.TargetType,
+			&i.TargetID,
+			&i.Changes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDueNotifications = `-- name: GetDueNotifications :many
+SELECT id, created_at, process_id, event, channel, recipients, notification, status, attempts, next_attempt_at, finished_at
+FROM notification_outbox
+WHERE status = 'pending'
+  AND next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY id
+LIMIT $1
+`
+
+func (q *Queries) GetDueNotifications(ctx context.Context, limit int32) ([]NotificationOutbox, error) {
+	rows, err := q.db.Query(ctx, getDueNotifications, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NotificationOutbox{}
+	for rows.Next() {
+		var i NotificationOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ProcessID,
+			&i.Event,
+			&i.Channel,
+			&i.Recipients,
+			&i.Notification,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEvents = `-- name: GetEvents :many
+SELECT e.id, e.process_id, e.event, e.created_at, e.additional_info, e.audit_id
+FROM process_event e
+         LEFT JOIN process p ON p.id = e.process_id
+WHERE (cardinality($1::integer[]) = 0 OR e.process_id = ANY ($1::integer[]))
+  AND (cardinality($2::integer[]) = 0 OR p.process_group_id = ANY ($2::integer[]))
+  AND (cardinality($3::text[]) = 0 OR e.event::text = ANY ($3::text[]))
+  AND e.created_at >= $4
+  AND e.created_at <= $5
+  AND ($6::integer IS NULL OR e.id < $6::integer)
+ORDER BY e.id DESC
+LIMIT $7
+`
+
+type GetEventsParams struct {
+	ProcessIds []int32          `json:"process_ids"`
+	GroupIds   []int32          `json:"group_ids"`
+	EventTypes []string         `json:"event_types"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+	BeforeID   pgtype.Int4      `json:"before_id"`
+	RowLimit   int32            `json:"row_limit"`
+}
+
+// events of all processes, newest first. Empty filter arrays match everything.
+// before_id is the keyset cursor: only events older than it are returned.
+func (q *Queries) GetEvents(ctx context.Context, arg GetEventsParams) ([]ProcessEvent, error) {
+	rows, err := q.db.Query(ctx, getEvents,
+		arg.ProcessIds,
+		arg.GroupIds,
+		arg.EventTypes,
+		arg.RangeFrom,
+		arg.RangeTo,
+		arg.BeforeID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ProcessEvent{}
+	for rows.Next() {
+		var i ProcessEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProcessID,
+			&i.Event,
+			&i.CreatedAt,
+			&i.AdditionalInfo,
+			&i.AuditID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGroupStatsFromTo = `-- name: GetGroupStatsFromTo :many
+SELECT per_process.bucket::timestamp         AS bucket,
+       sum(per_process.cpu)::float           AS cpu_usage_percentage,
+       sum(per_process.memory)::bigint       AS memory_usage,
+       count(*)::integer                     AS processes
+FROM (SELECT s.process_id,
+             to_timestamp(floor(extract(epoch FROM s.created_at) / $1::integer) *
+                          $1::integer) AT TIME ZONE 'UTC' AS bucket,
+             avg(s.cpu_usage_percentage)                                     AS cpu,
+             avg(s.memory_usage)                                             AS memory
+      FROM process_stats s
+               JOIN process p ON p.id = s.process_id
+      WHERE p.process_group_id = $2
+        AND s.created_at >= $3
+        AND s.created_at <= $4
+      GROUP BY s.process_id, bucket) per_process
+GROUP BY per_process.bucket
+ORDER BY per_process.bucket
+`
+
+type GetGroupStatsFromToParams struct {
+	BucketSize int32            `json:"bucket_size"`
+	GroupID    pgtype.Int4      `json:"group_id"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+}
+
+type GetGroupStatsFromToRow struct {
+	Bucket             pgtype.Timestamp `json:"bucket"`
+	CpuUsagePercentage float64          `json:"cpu_usage_percentage"`
+	MemoryUsage        int64            `json:"memory_usage"`
+	Processes          int32            `json:"processes"`
+}
+
+// sums stats of every process in the group. Samples of each process are averaged within a bucket first.
+func (q *Queries) GetGroupStatsFromTo(ctx context.Context, arg GetGroupStatsFromToParams) ([]GetGroupStatsFromToRow, error) {
+	rows, err := q.db.Query(ctx, getGroupStatsFromTo,
+		arg.BucketSize,
+		arg.GroupID,
+		arg.RangeFrom,
+		arg.RangeTo,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetGroupStatsFromToRow{}
+	for rows.Next() {
+		var i GetGroupStatsFromToRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.CpuUsagePercentage,
+			&i.MemoryUsage,
+			&i.Processes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getGroupStatsRollupFromTo = `-- name: GetGroupStatsRollupFromTo :many
+SELECT r.bucket,
+       sum(r.cpu_avg)::float     AS cpu_usage_percentage,
+       sum(r.memory_avg)::bigint AS memory_usage,
+       count(*)::integer         AS processes
+FROM process_stats_rollup r
+         JOIN process p ON p.id = r.process_id
+WHERE p.process_group_id = $1
+  AND r.resolution = $2
+  AND r.bucket >= $3
+  AND r.bucket <= $4
+GROUP BY r.bucket
+ORDER BY r.bucket
+`
+
+type GetGroupStatsRollupFromToParams struct {
+	GroupID    pgtype.Int4      `json:"group_id"`
+	Resolution int32            `json:"resolution"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+}
+
+type GetGroupStatsRollupFromToRow struct {
+	Bucket             pgtype.Timestamp `json:"bucket"`
+	CpuUsagePercentage float64          `json:"cpu_usage_percentage"`
+	MemoryUsage        int64            `json:"memory_usage"`
+	Processes          int32            `json:"processes"`
+}
+
+func (q *Queries) GetGroupStatsRollupFromTo(ctx context.Context, arg GetGroupStatsRollupFromToParams) ([]GetGroupStatsRollupFromToRow, error) {
+	rows, err := q.db.Query(ctx, getGroupStatsRollupFromTo,
+		arg.GroupID,
+		arg.Resolution,
+		arg.RangeFrom,
+		arg.RangeTo,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetGroupStatsRollupFromToRow{}
+	for rows.Next() {
+		var i GetGroupStatsRollupFromToRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.CpuUsagePercentage,
+			&i.MemoryUsage,
+			&i.Processes,
 		); err != nil {
 			return nil, err
 		}
@@ -199,6 +885,92 @@ func (q *Queries) GetGroupsByName(ctx context.Context, name string) ([]ProcessGr
 	return items, nil
 }
 
+const getHostStatsFromTo = `-- name: GetHostStatsFromTo :many
+SELECT (to_timestamp(floor(extract(epoch FROM created_at) / $1::integer) *
+                     $1::integer) AT TIME ZONE 'UTC')::timestamp AS bucket,
+       avg(cpu_usage_percentage)::float                                            AS cpu_usage_percentage,
+       max(memory_total)::bigint                                                   AS memory_total,
+       avg(memory_used)::bigint                                                    AS memory_used,
+       max(swap_total)::bigint                                                     AS swap_total,
+       avg(swap_used)::bigint                                                      AS swap_used,
+       avg(load_1)::float                                                          AS load_1,
+       avg(load_5)::float                                                          AS load_5,
+       avg(load_15)::float                                                         AS load_15,
+       max(disk_total)::bigint                                                     AS disk_total,
+       avg(disk_used)::bigint                                                      AS disk_used
+FROM host_stats
+WHERE created_at >= $2
+  AND created_at <= $3
+GROUP BY bucket
+ORDER BY bucket
+`
+
+type GetHostStatsFromToParams struct {
+	BucketSize int32            `json:"bucket_size"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+}
+
+type GetHostStatsFromToRow struct {
+	Bucket             pgtype.Timestamp `json:"bucket"`
+	CpuUsagePercentage float64          `json:"cpu_usage_percentage"`
+	MemoryTotal        int64            `json:"memory_total"`
+	MemoryUsed         int64            `json:"memory_used"`
+	SwapTotal          int64            `json:"swap_total"`
+	SwapUsed           int64            `json:"swap_used"`
+	Load1              float64          `json:"load_1"`
+	Load5              float64          `json:"load_5"`
+	Load15             float64          `json:"load_15"`
+	DiskTotal          int64            `json:"disk_total"`
+	DiskUsed           int64            `json:"disk_used"`
+}
+
+// averages host_stats over buckets of bucket_size seconds.
+func (q *Queries) GetHostStatsFromTo(ctx context.Context, arg GetHostStatsFromToParams) ([]GetHostStatsFromToRow, error) {
+	rows, err := q.db.Query(ctx, getHostStatsFromTo, arg.BucketSize, arg.RangeFrom, arg.RangeTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetHostStatsFromToRow{}
+	for rows.Next() {
+		var i GetHostStatsFromToRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.CpuUsagePercentage,
+			&i.MemoryTotal,
+			&i.MemoryUsed,
+			&i.SwapTotal,
+			&i.SwapUsed,
+			&i.Load1,
+			&i.Load5,
+			&i.Load15,
+			&i.DiskTotal,
+			&i.DiskUsed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLastRollupBucket = `-- name: GetLastRollupBucket :one
+SELECT coalesce(max(bucket), '1970-01-01 00:00:00')::timestamp AS last_bucket
+FROM process_stats_rollup
+WHERE resolution = $1
+`
+
+func (q *Queries) GetLastRollupBucket(ctx context.Context, resolution int32) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getLastRollupBucket, resolution)
+	var last_bucket pgtype.Timestamp
+	err := row.Scan(&last_bucket)
+	return last_bucket, err
+}
+
 const getLogFiles = `-- name: GetLogFiles :many
 SELECT id, process_id, start_time, end_time, path
 FROM logs
@@ -236,19 +1008,26 @@ const getLogFilesFromTo = `-- name: GetLogFilesFromTo :many
 SELECT id, process_id, start_time, end_time, path
 FROM logs
 WHERE process_id = $1
-  AND start_time >= $2
-  AND (end_time <= $3 OR end_time IS NULL)
+  AND ((start_time <= $2 AND (end_time >= $3 OR end_time IS NULL))
+    OR id = $4)
 ORDER BY id
 `
 
 type GetLogFilesFromToParams struct {
-	ProcessID pgtype.Int4      `json:"process_id"`
-	StartTime pgtype.Timestamp `json:"start_time"`
-	EndTime   pgtype.Timestamp `json:"end_time"`
+	ProcessID    pgtype.Int4      `json:"process_id"`
+	RangeTo      pgtype.Timestamp `json:"range_to"`
+	RangeFrom    pgtype.Timestamp `json:"range_from"`
+	CurrentLogID pgtype.Int4      `json:"current_log_id"`
 }
 
+// selects every file that overlaps [range_from, range_to], plus the file that is currently being written to, if any.
 func (q *Queries) GetLogFilesFromTo(ctx context.Context, arg GetLogFilesFromToParams) ([]Log, error) {
-	rows, err := q.db.Query(ctx, getLogFilesFromTo, arg.ProcessID, arg.StartTime, arg.EndTime)
+	rows, err := q.db.Query(ctx, getLogFilesFromTo,
+		arg.ProcessID,
+		arg.RangeTo,
+		arg.RangeFrom,
+		arg.CurrentLogID,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -273,6 +1052,238 @@ func (q *Queries) GetLogFilesFromTo(ctx context.Context, arg GetLogFilesFromToPa
 	return items, nil
 }
 
+const getNotificationAttempts = `-- name: GetNotificationAttempts :many
+SELECT id, outbox_id, attempt, created_at, success, recipient, chat_id, message_id, error
+FROM notification_attempts
+WHERE outbox_id = ANY ($1::integer[])
+ORDER BY id
+`
+
+func (q *Queries) GetNotificationAttempts(ctx context.Context, outboxIds []int32) ([]NotificationAttempt, error) {
+	rows, err := q.db.Query(ctx, getNotificationAttempts, outboxIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NotificationAttempt{}
+	for rows.Next() {
+		var i NotificationAttempt
+		if err := rows.Scan(
+			&i.ID,
+			&i.OutboxID,
+			&i.Attempt,
+			&i.CreatedAt,
+			&i.Success,
+			&i.Recipient,
+			&i.ChatID,
+			&i.MessageID,
+			&i.Error,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationChannel = `-- name: GetNotificationChannel :one
+SELECT id, name, type, enabled, events, templates, settings, created_at
+FROM notification_channels
+WHERE id = $1
+`
+
+func (q *Queries) GetNotificationChannel(ctx context.Context, id int32) (NotificationChannel, error) {
+	row := q.db.QueryRow(ctx, getNotificationChannel, id)
+	var i NotificationChannel
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Type,
+		&i.Enabled,
+		&i.Events,
+		&i.Templates,
+		&i.Settings,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getNotificationChannels = `-- name: GetNotificationChannels :many
+SELECT id, name, type, enabled, events, templates, settings, created_at
+FROM notification_channels
+ORDER BY id
+`
+
+func (q *Queries) GetNotificationChannels(ctx context.Context) ([]NotificationChannel, error) {
+	rows, err := q.db.Query(ctx, getNotificationChannels)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NotificationChannel{}
+	for rows.Next() {
+		var i NotificationChannel
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Enabled,
+			&i.Events,
+			&i.Templates,
+			&i.Settings,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationHistory = `-- name: GetNotificationHistory :many
+SELECT id, created_at, process_id, event, channel, recipients, notification, status, attempts, next_attempt_at, finished_at
+FROM notification_outbox
+WHERE ($1::integer IS NULL OR process_id = $1::integer)
+  AND ($2::varchar IS NULL OR channel = $2::varchar)
+  AND ($3::varchar IS NULL OR status = $3::varchar)
+  AND created_at >= $4
+  AND created_at <= $5
+  AND ($6::integer IS NULL OR id < $6::integer)
+ORDER BY id DESC
+LIMIT $7
+`
+
+type GetNotificationHistoryParams struct {
+	ProcessID pgtype.Int4      `json:"process_id"`
+	Channel   pgtype.Text      `json:"channel"`
+	Status    pgtype.Text      `json:"status"`
+	RangeFrom pgtype.Timestamp `json:"range_from"`
+	RangeTo   pgtype.Timestamp `json:"range_to"`
+	BeforeID  pgtype.Int4      `json:"before_id"`
+	RowLimit  int32            `json:"row_limit"`
+}
+
+// newest first. Empty filters match everything, before_id is the keyset cursor.
+func (q *Queries) GetNotificationHistory(ctx context.Context, arg GetNotificationHistoryParams) ([]NotificationOutbox, error) {
+	rows, err := q.db.Query(ctx, getNotificationHistory,
+		arg.ProcessID,
+		arg.Channel,
+		arg.Status,
+		arg.RangeFrom,
+		arg.RangeTo,
+		arg.BeforeID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NotificationOutbox{}
+	for rows.Next() {
+		var i NotificationOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ProcessID,
+			&i.Event,
+			&i.Channel,
+			&i.Recipients,
+			&i.Notification,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationRoute = `-- name: GetNotificationRoute :one
+SELECT id, name, position, is_default, process_ids, group_ids, events, targets, continue_matching, created_at
+FROM notification_routes
+WHERE id = $1
+`
+
+func (q *Queries) GetNotificationRoute(ctx context.Context, id int32) (NotificationRoute, error) {
+	row := q.db.QueryRow(ctx, getNotificationRoute, id)
+	var i NotificationRoute
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Position,
+		&i.IsDefault,
+		&i.ProcessIds,
+		&i.GroupIds,
+		&i.Events,
+		&i.Targets,
+		&i.ContinueMatching,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getNotificationRoutes = `-- name: GetNotificationRoutes :many
+SELECT id, name, position, is_default, process_ids, group_ids, events, targets, continue_matching, created_at
+FROM notification_routes
+ORDER BY is_default, position, id
+`
+
+func (q *Queries) GetNotificationRoutes(ctx context.Context) ([]NotificationRoute, error) {
+	rows, err := q.db.Query(ctx, getNotificationRoutes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []NotificationRoute{}
+	for rows.Next() {
+		var i NotificationRoute
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Position,
+			&i.IsDefault,
+			&i.ProcessIds,
+			&i.GroupIds,
+			&i.Events,
+			&i.Targets,
+			&i.ContinueMatching,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNotificationSettings = `-- name: GetNotificationSettings :one
+SELECT settings
+FROM notification_settings
+WHERE id = 1
+`
+
+func (q *Queries) GetNotificationSettings(ctx context.Context) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getNotificationSettings)
+	var settings []byte
+	err := row.Scan(&settings)
+	return settings, err
+}
+
 const getProcess = `-- name: GetProcess :one
 SELECT id, name, process_group_id, color, enabled, executable_path, arguments, working_directory, environment, status, configuration
 FROM process
@@ -336,8 +1347,28 @@ func (q *Queries) GetProcessByName(ctx context.Context, name string) ([]Process,
 	return items, nil
 }
 
+const getProcessEvent = `-- name: GetProcessEvent :one
+SELECT id, process_id, event, created_at, additional_info, audit_id
+FROM process_event
+WHERE id = $1
+`
+
+func (q *Queries) GetProcessEvent(ctx context.Context, id int32) (ProcessEvent, error) {
+	row := q.db.QueryRow(ctx, getProcessEvent, id)
+	var i ProcessEvent
+	err := row.Scan(
+		&i.ID,
+		&i.ProcessID,
+		&i.Event,
+		&i.CreatedAt,
+		&i.AdditionalInfo,
+		&i.AuditID,
+	)
+	return i, err
+}
+
 const getProcessEvents = `-- name: GetProcessEvents :many
-SELECT id, process_id, event, created_at, additional_info
+SELECT id, process_id, event, created_at, additional_info, audit_id
 FROM process_event
 WHERE process_id = $1
 ORDER BY id ASC
@@ -358,6 +1389,7 @@ func (q *Queries) GetProcessEvents(ctx context.Context, processID pgtype.Int4) (
 			&i.Event,
 			&i.CreatedAt,
 			&i.AdditionalInfo,
+			&i.AuditID,
 		); err != nil {
 			return nil, err
 		}
@@ -370,7 +1402,7 @@ func (q *Queries) GetProcessEvents(ctx context.Context, processID pgtype.Int4) (
 }
 
 const getProcessEventsAfter = `-- name: GetProcessEventsAfter :many
-SELECT id, process_id, event, created_at, additional_info
+SELECT id, process_id, event, created_at, additional_info, audit_id
 FROM process_event
 WHERE process_id = $1
   AND created_at >= $2
@@ -397,6 +1429,7 @@ func (q *Queries) GetProcessEventsAfter(ctx context.Context, arg GetProcessEvent
 			&i.Event,
 			&i.CreatedAt,
 			&i.AdditionalInfo,
+			&i.AuditID,
 		); err != nil {
 			return nil, err
 		}
@@ -409,7 +1442,7 @@ func (q *Queries) GetProcessEventsAfter(ctx context.Context, arg GetProcessEvent
 }
 
 const getProcessEventsFromTo = `-- name: GetProcessEventsFromTo :many
-SELECT id, process_id, event, created_at, additional_info
+SELECT id, process_id, event, created_at, additional_info, audit_id
 FROM process_event
 WHERE process_id = $1
   AND created_at >= $2
@@ -445,6 +1478,7 @@ func (q *Queries) GetProcessEventsFromTo(ctx context.Context, arg GetProcessEven
 			&i.Event,
 			&i.CreatedAt,
 			&i.AdditionalInfo,
+			&i.AuditID,
 		); err != nil {
 			return nil, err
 		}
@@ -539,7 +1573,7 @@ func (q *Queries) GetProcessGroups(ctx context.Context) ([]ProcessGroup, error)
 }
 
 const getProcessStats = `-- name: GetProcessStats :many
-SELECT id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage
+SELECT id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage, thread_count, open_fds, io_read_bytes, io_write_bytes, ctx_switches_voluntary, ctx_switches_involuntary, child_count
 FROM process_stats
 WHERE process_id = $1
 ORDER BY id ASC
@@ -561,6 +1595,13 @@ func (q *Queries) GetProcessStats(ctx context.Context, processID pgtype.Int4) ([
 			&i.CpuUsage,
 			&i.CpuUsagePercentage,
 			&i.MemoryUsage,
+			&i.ThreadCount,
+			&i.OpenFds,
+			&i.IoReadBytes,
+			&i.IoWriteBytes,
+			&i.CtxSwitchesVoluntary,
+			&i.CtxSwitchesInvoluntary,
+			&i.ChildCount,
 		); err != nil {
 			return nil, err
 		}
@@ -573,7 +1614,7 @@ func (q *Queries) GetProcessStats(ctx context.Context, processID pgtype.Int4) ([
 }
 
 const getProcessStatsFromTo = `-- name: GetProcessStatsFromTo :many
-SELECT id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage
+SELECT id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage, thread_count, open_fds, io_read_bytes, io_write_bytes, ctx_switches_voluntary, ctx_switches_involuntary, child_count
 FROM process_stats
 WHERE process_id = $1
   AND created_at >= $2
@@ -603,6 +1644,69 @@ func (q *Queries) GetProcessStatsFromTo(ctx context.Context, arg GetProcessStats
 			&i.CpuUsage,
 			&i.CpuUsagePercentage,
 			&i.MemoryUsage,
+			&i.ThreadCount,
+			&i.OpenFds,
+			&i.IoReadBytes,
+			&i.IoWriteBytes,
+			&i.CtxSwitchesVoluntary,
+			&i.CtxSwitchesInvoluntary,
+			&i.ChildCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getProcessStatsRollupFromTo = `-- name: GetProcessStatsRollupFromTo :many
+SELECT process_id, resolution, bucket, samples, cpu_usage, cpu_avg, cpu_min, cpu_max, cpu_p95, memory_avg, memory_min, memory_max, memory_p95
+FROM process_stats_rollup
+WHERE process_id = $1
+  AND resolution = $2
+  AND bucket >= $3
+  AND bucket <= $4
+ORDER BY bucket
+`
+
+type GetProcessStatsRollupFromToParams struct {
+	ProcessID  int32            `json:"process_id"`
+	Resolution int32            `json:"resolution"`
+	Bucket     pgtype.Timestamp `json:"bucket"`
+	Bucket_2   pgtype.Timestamp `json:"bucket_2"`
+}
+
+func (q *Queries) GetProcessStatsRollupFromTo(ctx context.Context, arg GetProcessStatsRollupFromToParams) ([]ProcessStatsRollup, error) {
+	rows, err := q.db.Query(ctx, getProcessStatsRollupFromTo,
+		arg.ProcessID,
+		arg.Resolution,
+		arg.Bucket,
+		arg.Bucket_2,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ProcessStatsRollup{}
+	for rows.Next() {
+		var i ProcessStatsRollup
+		if err := rows.Scan(
+			&i.ProcessID,
+			&i.Resolution,
+			&i.Bucket,
+			&i.Samples,
+			&i.CpuUsage,
+			&i.CpuAvg,
+			&i.CpuMin,
+			&i.CpuMax,
+			&i.CpuP95,
+			&i.MemoryAvg,
+			&i.MemoryMin,
+			&i.MemoryMax,
+			&i.MemoryP95,
 		); err != nil {
 			return nil, err
 		}
@@ -691,6 +1795,100 @@ func (q *Queries) GetProcessesByGroup(ctx context.Context, processGroupID pgtype
 	return items, nil
 }
 
+const getUser = `-- name: GetUser :one
+SELECT id, username, password_hash, role, created_at, password_changed_at
+FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id int32) (User, error) {
+	row := q.db.QueryRow(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.PasswordChangedAt,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username, password_hash, role, created_at, password_changed_at
+FROM users
+WHERE username = $1
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.PasswordChangedAt,
+	)
+	return i, err
+}
+
+const getUserSession = `-- name: GetUserSession :one
+SELECT id, user_id, refresh_hash, created_at, expires_at, revoked_at
+FROM user_sessions
+WHERE id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) GetUserSession(ctx context.Context, id int32) (UserSession, error) {
+	row := q.db.QueryRow(ctx, getUserSession, id)
+	var i UserSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getUsers = `-- name: GetUsers :many
+SELECT id, username, password_hash, role, created_at, password_changed_at
+FROM users
+ORDER BY id
+`
+
+func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.Query(ctx, getUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.PasswordHash,
+			&i.Role,
+			&i.CreatedAt,
+			&i.PasswordChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const groupExistsByName = `-- name: GroupExistsByName :one
 SELECT EXISTS(SELECT 1
               FROM process_group
@@ -704,19 +1902,187 @@ func (q *Queries) GroupExistsByName(ctx context.Context, name string) (bool, err
 	return exists, err
 }
 
+const insertAuditLog = `-- name: InsertAuditLog :one
+INSERT INTO audit_log (actor, remote_addr, request_id, method, route, action, target_type, target_id, changes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at, actor, remote_addr, request_id, method, route, action, target_type, target_id, changes
+`
+
+type InsertAuditLogParams struct {
+	Actor      string      `json:"actor"`
+	RemoteAddr string      `json:"remote_addr"`
+	RequestID  string      `json:"request_id"`
+	Method     string      `json:"method"`
+	Route      string      `json:"route"`
+	Action     string      `json:"action"`
+	TargetType string      `json:"target_type"`
+	TargetID   pgtype.Int4 `json:"target_id"`
+	Changes    []byte      `json:"changes"`
+}
+
+func (q *Queries) InsertAuditLog(ctx context.Context, arg InsertAuditLogParams) (AuditLog, error) {
+	row := q.db.QueryRow(ctx, insertAuditLog,
+		arg.Actor,
+		arg.RemoteAddr,
+		arg.RequestID,
+		arg.Method,
+		arg.Route,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.Changes,
+	)
+	var i AuditLog
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.Actor,
+		&i.RemoteAddr,
+		&i.RequestID,
+		&i.Method,
+		&i.Route,
+		&i.Action,
+		&i.TargetType,
+		&i.TargetID,
+		&i.Changes,
+	)
+	return i, err
+}
+
+const insertHostStats = `-- name: InsertHostStats :one
+INSERT INTO host_stats (cpu_usage_percentage, memory_total, memory_used, swap_total, swap_used, load_1, load_5, load_15,
+                        disk_total, disk_used)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at, cpu_usage_percentage, memory_total, memory_used, swap_total, swap_used, load_1, load_5, load_15, disk_total, disk_used
+`
+
+type InsertHostStatsParams struct {
+	CpuUsagePercentage float64 `json:"cpu_usage_percentage"`
+	MemoryTotal        int64   `json:"memory_total"`
+	MemoryUsed         int64   `json:"memory_used"`
+	SwapTotal          int64   `json:"swap_total"`
+	SwapUsed           int64   `json:"swap_used"`
+	Load1              float64 `json:"load_1"`
+	Load5              float64 `json:"load_5"`
+	Load15             float64 `json:"load_15"`
+	DiskTotal          int64   `json:"disk_total"`
+	DiskUsed           int64   `json:"disk_used"`
+}
+
+func (q *Queries) InsertHostStats(ctx context.Context, arg InsertHostStatsParams) (HostStat, error) {
+	row := q.db.QueryRow(ctx, insertHostStats,
+		arg.CpuUsagePercentage,
+		arg.MemoryTotal,
+		arg.MemoryUsed,
+		arg.SwapTotal,
+		arg.SwapUsed,
+		arg.Load1,
+		arg.Load5,
+		arg.Load15,
+		arg.DiskTotal,
+		arg.DiskUsed,
+	)
+	var i HostStat
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.CpuUsagePercentage,
+		&i.MemoryTotal,
+		&i.MemoryUsed,
+		&i.SwapTotal,
+		&i.SwapUsed,
+		&i.Load1,
+		&i.Load5,
+		&i.Load15,
+		&i.DiskTotal,
+		&i.DiskUsed,
+	)
+	return i, err
+}
+
+const insertNotificationAttempt = `-- name: InsertNotificationAttempt :exec
+INSERT INTO notification_attempts (outbox_id, attempt, success, recipient, chat_id, message_id, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type InsertNotificationAttemptParams struct {
+	OutboxID  int32       `json:"outbox_id"`
+	Attempt   int32       `json:"attempt"`
+	Success   bool        `json:"success"`
+	Recipient string      `json:"recipient"`
+	ChatID    pgtype.Int8 `json:"chat_id"`
+	MessageID pgtype.Int4 `json:"message_id"`
+	Error     string      `json:"error"`
+}
+
+func (q *Queries) InsertNotificationAttempt(ctx context.Context, arg InsertNotificationAttemptParams) error {
+	_, err := q.db.Exec(ctx, insertNotificationAttempt,
+		arg.OutboxID,
+		arg.Attempt,
+		arg.Success,
+		arg.Recipient,
+		arg.ChatID,
+		arg.MessageID,
+		arg.Error,
+	)
+	return err
+}
+
+const insertNotificationOutbox = `-- name: InsertNotificationOutbox :one
+INSERT INTO notification_outbox (process_id, event, channel, recipients, notification)
+VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, process_id, event, channel, recipients, notification, status, attempts, next_attempt_at, finished_at
+`
+
+type InsertNotificationOutboxParams struct {
+	ProcessID    pgtype.Int4 `json:"process_id"`
+	Event        string      `json:"event"`
+	Channel      string      `json:"channel"`
+	Recipients   []string    `json:"recipients"`
+	Notification []byte      `json:"notification"`
+}
+
+func (q *Queries) InsertNotificationOutbox(ctx context.Context, arg InsertNotificationOutboxParams) (NotificationOutbox, error) {
+	row := q.db.QueryRow(ctx, insertNotificationOutbox,
+		arg.ProcessID,
+		arg.Event,
+		arg.Channel,
+		arg.Recipients,
+		arg.Notification,
+	)
+	var i NotificationOutbox
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.ProcessID,
+		&i.Event,
+		&i.Channel,
+		&i.Recipients,
+		&i.Notification,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
 const insertProcessEvent = `-- name: InsertProcessEvent :one
-INSERT INTO process_event (process_id, event, additional_info)
-VALUES ($1, $2, $3) RETURNING id, process_id, event, created_at, additional_info
+INSERT INTO process_event (process_id, event, additional_info, audit_id)
+VALUES ($1, $2, $3, $4) RETURNING id, process_id, event, created_at, additional_info, audit_id
 `
 
 type InsertProcessEventParams struct {
 	ProcessID      pgtype.Int4      `json:"process_id"`
 	Event          ProcessEventType `json:"event"`
 	AdditionalInfo []byte           `json:"additional_info"`
+	AuditID        pgtype.Int4      `json:"audit_id"`
 }
 
 func (q *Queries) InsertProcessEvent(ctx context.Context, arg InsertProcessEventParams) (ProcessEvent, error) {
-	row := q.db.QueryRow(ctx, insertProcessEvent, arg.ProcessID, arg.Event, arg.AdditionalInfo)
+	row := q.db.QueryRow(ctx, insertProcessEvent,
+		arg.ProcessID,
+		arg.Event,
+		arg.AdditionalInfo,
+		arg.AuditID,
+	)
 	var i ProcessEvent
 	err := row.Scan(
 		&i.ID,
@@ -724,20 +2090,30 @@ func (q *Queries) InsertProcessEvent(ctx context.Context, arg InsertProcessEvent
 		&i.Event,
 		&i.CreatedAt,
 		&i.AdditionalInfo,
+		&i.AuditID,
 	)
 	return i, err
 }
 
 const insertProcessStats = `-- name: InsertProcessStats :one
-INSERT INTO process_stats (process_id, cpu_usage, cpu_usage_percentage, memory_usage)
-VALUES ($1, $2, $3, $4) RETURNING id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage
+INSERT INTO process_stats (process_id, cpu_usage, cpu_usage_percentage, memory_usage, thread_count, open_fds,
+                           io_read_bytes, io_write_bytes, ctx_switches_voluntary, ctx_switches_involuntary,
+                           child_count)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, process_id, created_at, cpu_usage, cpu_usage_percentage, memory_usage, thread_count, open_fds, io_read_bytes, io_write_bytes, ctx_switches_voluntary, ctx_switches_involuntary, child_count
 `
 
 type InsertProcessStatsParams struct {
-	ProcessID          pgtype.Int4 `json:"process_id"`
-	CpuUsage           int64       `json:"cpu_usage"`
-	CpuUsagePercentage float64     `json:"cpu_usage_percentage"`
-	MemoryUsage        int64       `json:"memory_usage"`
+	ProcessID              pgtype.Int4 `json:"process_id"`
+	CpuUsage               int64       `json:"cpu_usage"`
+	CpuUsagePercentage     float64     `json:"cpu_usage_percentage"`
+	MemoryUsage            int64       `json:"memory_usage"`
+	ThreadCount            pgtype.Int4 `json:"thread_count"`
+	OpenFds                pgtype.Int4 `json:"open_fds"`
+	IoReadBytes            pgtype.Int8 `json:"io_read_bytes"`
+	IoWriteBytes           pgtype.Int8 `json:"io_write_bytes"`
+	CtxSwitchesVoluntary   pgtype.Int8 `json:"ctx_switches_voluntary"`
+	CtxSwitchesInvoluntary pgtype.Int8 `json:"ctx_switches_involuntary"`
+	ChildCount             pgtype.Int4 `json:"child_count"`
 }
 
 func (q *Queries) InsertProcessStats(ctx context.Context, arg InsertProcessStatsParams) (ProcessStat, error) {
@@ -746,6 +2122,13 @@ func (q *Queries) InsertProcessStats(ctx context.Context, arg InsertProcessStats
 		arg.CpuUsage,
 		arg.CpuUsagePercentage,
 		arg.MemoryUsage,
+		arg.ThreadCount,
+		arg.OpenFds,
+		arg.IoReadBytes,
+		arg.IoWriteBytes,
+		arg.CtxSwitchesVoluntary,
+		arg.CtxSwitchesInvoluntary,
+		arg.ChildCount,
 	)
 	var i ProcessStat
 	err := row.Scan(
@@ -755,6 +2138,13 @@ func (q *Queries) InsertProcessStats(ctx context.Context, arg InsertProcessStats
 		&i.CpuUsage,
 		&i.CpuUsagePercentage,
 		&i.MemoryUsage,
+		&i.ThreadCount,
+		&i.OpenFds,
+		&i.IoReadBytes,
+		&i.IoWriteBytes,
+		&i.CtxSwitchesVoluntary,
+		&i.CtxSwitchesInvoluntary,
+		&i.ChildCount,
 	)
 	return i, err
 }
@@ -802,6 +2192,193 @@ func (q *Queries) NewProcessLogFile(ctx context.Context, arg NewProcessLogFilePa
 	return i, err
 }
 
+const revokeApiKey = `-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND revoked_at IS NULL RETURNING id, name, key_hash, scope, group_ids, created_at, expires_at, last_used_at, revoked_at
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, id int32) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, revokeApiKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scope,
+		&i.GroupIds,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const revokeOtherUserSessions = `-- name: RevokeOtherUserSessions :exec
+UPDATE user_sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1
+  AND id != $2
+  AND revoked_at IS NULL
+`
+
+type RevokeOtherUserSessionsParams struct {
+	UserID int32 `json:"user_id"`
+	ID     int32 `json:"id"`
+}
+
+func (q *Queries) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) error {
+	_, err := q.db.Exec(ctx, revokeOtherUserSessions, arg.UserID, arg.ID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :exec
+UPDATE user_sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserSession(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, revokeUserSession, id)
+	return err
+}
+
+const rollupRawStats = `-- name: RollupRawStats :exec
+INSERT INTO process_stats_rollup (process_id, resolution, bucket, samples, cpu_usage, cpu_avg, cpu_min, cpu_max, cpu_p95,
+                                  memory_avg, memory_min, memory_max, memory_p95)
+SELECT process_id,
+       $1::integer,
+       to_timestamp(floor(extract(epoch FROM created_at) / $1::integer) *
+                    $1::integer) AT TIME ZONE 'UTC'                        AS bucket,
+       count(*)::integer,
+       sum(cpu_usage)::bigint,
+       avg(cpu_usage_percentage)::float,
+       min(cpu_usage_percentage)::float,
+       max(cpu_usage_percentage)::float,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY cpu_usage_percentage)::float,
+       avg(memory_usage)::bigint,
+       min(memory_usage)::bigint,
+       max(memory_usage)::bigint,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY memory_usage)::bigint
+FROM process_stats
+WHERE created_at >= $2
+  AND created_at < $3
+  AND process_id IS NOT NULL
+GROUP BY process_id, bucket
+ON CONFLICT (process_id, resolution, bucket) DO UPDATE SET samples=excluded.samples,
+                                                           cpu_usage=excluded.cpu_usage,
+                                                           cpu_avg=excluded.cpu_avg,
+                                                           cpu_min=excluded.cpu_min,
+                                                           cpu_max=excluded.cpu_max,
+                                                           cpu_p95=excluded.cpu_p95,
+                                                           memory_avg=excluded.memory_avg,
+                                                           memory_min=excluded.memory_min,
+                                                           memory_max=excluded.memory_max,
+                                                           memory_p95=excluded.memory_p95
+`
+
+type RollupRawStatsParams struct {
+	Resolution int32            `json:"resolution"`
+	RangeFrom  pgtype.Timestamp `json:"range_from"`
+	RangeTo    pgtype.Timestamp `json:"range_to"`
+}
+
+// aggregates process_stats in [range_from, range_to) into buckets of the given resolution (in seconds).
+func (q *Queries) RollupRawStats(ctx context.Context, arg RollupRawStatsParams) error {
+	_, err := q.db.Exec(ctx, rollupRawStats, arg.Resolution, arg.RangeFrom, arg.RangeTo)
+	return err
+}
+
+const rollupStats = `-- name: RollupStats :exec
+INSERT INTO process_stats_rollup (process_id, resolution, bucket, samples, cpu_usage, cpu_avg, cpu_min, cpu_max, cpu_p95,
+                                  memory_avg, memory_min, memory_max, memory_p95)
+SELECT process_id,
+       $1::integer,
+       to_timestamp(floor(extract(epoch FROM r.bucket) / $1::integer) *
+                    $1::integer) AT TIME ZONE 'UTC'                         AS new_bucket,
+       sum(samples)::integer,
+       sum(cpu_usage)::bigint,
+       (sum(cpu_avg * samples) / sum(samples))::float,
+       min(cpu_min)::float,
+       max(cpu_max)::float,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY cpu_p95)::float,
+       (sum(memory_avg * samples) / sum(samples))::bigint,
+       min(memory_min)::bigint,
+       max(memory_max)::bigint,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY memory_p95)::bigint
+FROM process_stats_rollup r
+WHERE r.resolution = $2::integer
+  AND r.bucket >= $3
+  AND r.bucket < $4
+GROUP BY process_id, new_bucket
+ON CONFLICT (process_id, resolution, bucket) DO UPDATE SET samples=excluded.samples,
+                                                           cpu_usage=excluded.cpu_usage,
+                                                           cpu_avg=excluded.cpu_avg,
+                                                           cpu_min=excluded.cpu_min,
+                                                           cpu_max=excluded.cpu_max,
+                                                           cpu_p95=excluded.cpu_p95,
+                                                           memory_avg=excluded.memory_avg,
+                                                           memory_min=excluded.memory_min,
+                                                           memory_max=excluded.memory_max,
+                                                           memory_p95=excluded.memory_p95
+`
+
+type RollupStatsParams struct {
+	Resolution       int32            `json:"resolution"`
+	SourceResolution int32            `json:"source_resolution"`
+	RangeFrom        pgtype.Timestamp `json:"range_from"`
+	RangeTo          pgtype.Timestamp `json:"range_to"`
+}
+
+// aggregates rollups of source_resolution in [range_from, range_to) into buckets of the given resolution.
+func (q *Queries) RollupStats(ctx context.Context, arg RollupStatsParams) error {
+	_, err := q.db.Exec(ctx, rollupStats,
+		arg.Resolution,
+		arg.SourceResolution,
+		arg.RangeFrom,
+		arg.RangeTo,
+	)
+	return err
+}
+
+const rotateUserSession = `-- name: RotateUserSession :one
+UPDATE user_sessions
+SET refresh_hash = $1,
+    expires_at   = $2
+WHERE refresh_hash = $3
+  AND revoked_at IS NULL
+  AND user_sessions.expires_at > $4 RETURNING id, user_id, refresh_hash, created_at, expires_at, revoked_at
+`
+
+type RotateUserSessionParams struct {
+	NewRefreshHash []byte           `json:"new_refresh_hash"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	RefreshHash    []byte           `json:"refresh_hash"`
+	Now            pgtype.Timestamp `json:"now"`
+}
+
+func (q *Queries) RotateUserSession(ctx context.Context, arg RotateUserSessionParams) (UserSession, error) {
+	row := q.db.QueryRow(ctx, rotateUserSession,
+		arg.NewRefreshHash,
+		arg.ExpiresAt,
+		arg.RefreshHash,
+		arg.Now,
+	)
+	var i UserSession
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const setLogEndTime = `-- name: SetLogEndTime :exec
 UPDATE logs
 SET end_time=$2
@@ -866,6 +2443,177 @@ func (q *Queries) SetProcessStatus(ctx context.Context, arg SetProcessStatusPara
 	return err
 }
 
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash       = $2,
+    password_changed_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID           int32  `json:"id"`
+	PasswordHash string `json:"password_hash"`
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, setUserPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const touchApiKey = `-- name: TouchApiKey :exec
+UPDATE api_keys
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+`
+
+// last_used_at is only updated once a minute, so every request doesn't result in a write.
+func (q *Queries) TouchApiKey(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, touchApiKey, id)
+	return err
+}
+
+const updateNotificationChannel = `-- name: UpdateNotificationChannel :one
+UPDATE notification_channels
+SET name      = $2,
+    type      = $3,
+    enabled   = $4,
+    events    = $5,
+    templates = $6,
+    settings  = $7
+WHERE id = $1 RETURNING id, name, type, enabled, events, templates, settings, created_at
+`
+
+type UpdateNotificationChannelParams struct {
+	ID        int32             `json:"id"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type"`
+	Enabled   bool              `json:"enabled"`
+	Events    []string          `json:"events"`
+	Templates map[string]string `json:"templates"`
+	Settings  []byte            `json:"settings"`
+}
+
+func (q *Queries) UpdateNotificationChannel(ctx context.Context, arg UpdateNotificationChannelParams) (NotificationChannel, error) {
+	row := q.db.QueryRow(ctx, updateNotificationChannel,
+		arg.ID,
+		arg.Name,
+		arg.Type,
+		arg.Enabled,
+		arg.Events,
+		arg.Templates,
+		arg.Settings,
+	)
+	var i NotificationChannel
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Type,
+		&i.Enabled,
+		&i.Events,
+		&i.Templates,
+		&i.Settings,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateNotificationOutbox = `-- name: UpdateNotificationOutbox :exec
+UPDATE notification_outbox
+SET status          = $2,
+    attempts        = $3,
+    recipients      = $4,
+    next_attempt_at = $5,
+    finished_at     = $6
+WHERE id = $1
+`
+
+type UpdateNotificationOutboxParams struct {
+	ID            int32            `json:"id"`
+	Status        string           `json:"status"`
+	Attempts      int32            `json:"attempts"`
+	Recipients    []string         `json:"recipients"`
+	NextAttemptAt pgtype.Timestamp `json:"next_attempt_at"`
+	FinishedAt    pgtype.Timestamp `json:"finished_at"`
+}
+
+func (q *Queries) UpdateNotificationOutbox(ctx context.Context, arg UpdateNotificationOutboxParams) error {
+	_, err := q.db.Exec(ctx, updateNotificationOutbox,
+		arg.ID,
+		arg.Status,
+		arg.Attempts,
+		arg.Recipients,
+		arg.NextAttemptAt,
+		arg.FinishedAt,
+	)
+	return err
+}
+
+const updateNotificationRoute = `-- name: UpdateNotificationRoute :one
+UPDATE notification_routes
+SET name              = $2,
+    position          = $3,
+    is_default        = $4,
+    process_ids       = $5,
+    group_ids         = $6,
+    events            = $7,
+    targets           = $8,
+    continue_matching = $9
+WHERE id = $1 RETURNING id, name, position, is_default, process_ids, group_ids, events, targets, continue_matching, created_at
+`
+
+type UpdateNotificationRouteParams struct {
+	ID               int32               `json:"id"`
+	Name             string              `json:"name"`
+	Position         int32               `json:"position"`
+	IsDefault        bool                `json:"is_default"`
+	ProcessIds       []int32             `json:"process_ids"`
+	GroupIds         []int32             `json:"group_ids"`
+	Events           []string            `json:"events"`
+	Targets          NotificationTargets `json:"targets"`
+	ContinueMatching bool                `json:"continue_matching"`
+}
+
+func (q *Queries) UpdateNotificationRoute(ctx context.Context, arg UpdateNotificationRouteParams) (NotificationRoute, error) {
+	row := q.db.QueryRow(ctx, updateNotificationRoute,
+		arg.ID,
+		arg.Name,
+		arg.Position,
+		arg.IsDefault,
+		arg.ProcessIds,
+		arg.GroupIds,
+		arg.Events,
+		arg.Targets,
+		arg.ContinueMatching,
+	)
+	var i NotificationRoute
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Position,
+		&i.IsDefault,
+		&i.ProcessIds,
+		&i.GroupIds,
+		&i.Events,
+		&i.Targets,
+		&i.ContinueMatching,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const updateNotificationSettings = `-- name: UpdateNotificationSettings :exec
+UPDATE notification_settings
+SET settings   = $1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = 1
+`
+
+func (q *Queries) UpdateNotificationSettings(ctx context.Context, settings []byte) error {
+	_, err := q.db.Exec(ctx, updateNotificationSettings, settings)
+	return err
+}
+
 const updateProcess = `-- name: UpdateProcess :one
 UPDATE process
 SET name=$2,
@@ -2,6 +2,8 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/jackc/pgx/v5/pgtype"
 	"os"
 	"time"
@@ -22,8 +24,14 @@ var DefaultConfiguration = Configuration{
 
 	RecordStats: pgtype.Bool{Valid: true, Bool: true},
 	StoreLogs:   pgtype.Bool{Valid: true, Bool: true},
+
+	// output often contains secrets, so it's only sent if a process opts in.
+	CrashOutputLines: pgtype.Int4{Valid: true, Int32: 0},
 }
 
+// MaxCrashOutputLines limits CrashOutputLines, the lines are kept in memory for every process.
+const MaxCrashOutputLines = 500
+
 type Configuration struct {
 	AutoRestartOnStop  pgtype.Bool `json:"auto_restart_on_stop"`
 	AutoRestartOnCrash pgtype.Bool `json:"auto_restart_on_crash"`
@@ -40,6 +48,73 @@ type Configuration struct {
 
 	RecordStats pgtype.Bool `json:"record_stats"`
 	StoreLogs   pgtype.Bool `json:"store_logs"`
+
+	// ThresholdRules are evaluated on every stats recording, so they need RecordStats.
+	ThresholdRules []ThresholdRule `json:"threshold_rules"`
+
+	// NotificationTemplates override the notification templates, by event type.
+	// Process templates take precedence over the ones of its group.
+	NotificationTemplates map[string]string `json:"notification_templates"`
+
+	// CrashOutputLines is how many of the last lines of output are attached to CRASH and FULL_CRASH notifications.
+	// 0 attaches none, and keeps the output out of notifications and their templates altogether.
+	CrashOutputLines pgtype.Int4 `json:"crash_output_lines"`
+}
+
+type ThresholdMetric string
+
+const (
+	ThresholdMetricMemory ThresholdMetric = "memory"
+	ThresholdMetricCpu    ThresholdMetric = "cpu"
+)
+
+// ThresholdRule is breached when Metric stays above Above for Samples consecutive recordings,
+// or for Duration seconds. If neither is set, a single recording is enough.
+// It recovers once the value drops to RecoverBelow or lower, which defaults to 90% of Above,
+// so a value hovering around Above doesn't produce a notification on every recording.
+type ThresholdRule struct {
+	Metric ThresholdMetric `json:"metric"`
+	// Above is in bytes for memory and in percent for cpu.
+	Above        float64 `json:"above"`
+	RecoverBelow float64 `json:"recover_below"`
+	Samples      int     `json:"samples"`
+	Duration     int     `json:"duration"`
+
+	Notify   bool `json:"notify"`
+	LogEvent bool `json:"log_event"`
+	Restart  bool `json:"restart"`
+}
+
+func (t *ThresholdRule) GetRecoverBelow() float64 {
+	if t.RecoverBelow == 0 {
+		return t.Above * 0.9
+	}
+	return t.RecoverBelow
+}
+
+func (t *ThresholdRule) GetDuration() time.Duration {
+	return time.Duration(t.Duration) * time.Second
+}
+
+func (t *ThresholdRule) Validate() error {
+	switch t.Metric {
+	case ThresholdMetricMemory, ThresholdMetricCpu:
+	default:
+		return fmt.Errorf("unknown metric %q", t.Metric)
+	}
+	if t.Above <= 0 {
+		return errors.New("above must be positive")
+	}
+	if t.RecoverBelow < 0 || t.RecoverBelow > t.Above {
+		return errors.New("recover_below must be between 0 and above")
+	}
+	if t.Samples < 0 || t.Duration < 0 {
+		return errors.New("samples and duration can't be negative")
+	}
+	if !t.Notify && !t.LogEvent && !t.Restart {
+		return errors.New("at least one of notify, log_event and restart is required")
+	}
+	return nil
 }
 
 // GetAutoRestartOnStop -> bool
@@ -129,6 +204,22 @@ func (c *Configuration) GetStoreLogs() bool {
 	return c.StoreLogs.Bool
 }
 
+func (c *Configuration) GetCrashOutputLines() int {
+	if !c.CrashOutputLines.Valid {
+		return int(DefaultConfiguration.CrashOutputLines.Int32)
+	}
+	return int(c.CrashOutputLines.Int32)
+}
+
+func (c *Configuration) GetThresholdRules() []ThresholdRule {
+	if c.ThresholdRules == nil {
+		return DefaultConfiguration.ThresholdRules
+	}
+	return c.ThresholdRules
+}
+
+// Equal reports whether the settings that need a restart of the process are the same.
+// ThresholdRules, NotificationTemplates and CrashOutputLines are only used for monitoring and notifications.
 func (c *Configuration) Equal(other Configuration) bool {
 	return c.GetAutoRestartOnStop() == other.GetAutoRestartOnStop() &&
 		c.GetAutoRestartOnCrash() == other.GetAutoRestartOnCrash() &&
@@ -178,3 +269,12 @@ func init() {
 	}
 
 }
+
+// NotificationTarget is a channel a notification route sends to.
+// Recipients replace the recipients of the channel, if there are any.
+type NotificationTarget struct {
+	Channel    string   `json:"channel"`
+	Recipients []string `json:"recipients"`
+}
+
+type NotificationTargets []NotificationTarget
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/apepenkov/yalog"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"os"
 	"procsman_backend/api"
 	"procsman_backend/config"
+	"procsman_backend/db"
 	"procsman_backend/procsmanager"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -24,6 +30,8 @@ func main() {
 	var allowOrigin string
 	flag.StringVar(&serveAddr, "serve", "127.0.0.1:54580", "Address to serve the HTTP API on")
 	flag.StringVar(&allowOrigin, "allow-origin", "*", "Allow origin for CORS")
+	var createAdmin string
+	flag.StringVar(&createAdmin, "create-admin", "", "Create an admin user with this username and exit. The password is read from PROCSMAN_ADMIN_PASSWORD or stdin")
 	flag.Parse()
 
 	var cfg config.Config
@@ -37,6 +45,15 @@ func main() {
 		panic(err)
 	}
 
+	if createAdmin != "" {
+		if err = createAdminUser(cfg, createAdmin); err != nil {
+			logger.Errorln(fmt.Sprintf("Error creating admin: %s", err.Error()))
+			os.Exit(1)
+		}
+		logger.Infof("Created admin %q\n", createAdmin)
+		return
+	}
+
 	serv, err := procsmanager.NewProcessManager(cfg, logger)
 	if err != nil {
 		logger.Errorln(fmt.Sprintf("Error creating process manager: %s", err.Error()))
@@ -46,6 +63,10 @@ func main() {
 	defer serv.Close()
 	httpServ := api.NewHttpServer(serv, serveAddr, allowOrigin)
 	httpServ.Logger.SetVerboseLevel(yalog.VerboseLevelInfo)
+	if err = httpServ.LoadAuth(context.Background()); err != nil {
+		logger.Errorln(fmt.Sprintf("Error loading auth: %s", err.Error()))
+		panic(err)
+	}
 
 	logger.Infof("Serving on %s\nAllowing origin: %s\n", serveAddr, allowOrigin)
 
@@ -57,3 +78,26 @@ func main() {
 	defer httpServ.Close()
 
 }
+
+// createAdminUser creates an admin without starting the process manager, so it can be run next to a running procsman.
+func createAdminUser(cfg config.Config, username string) error {
+	password, ok := os.LookupEnv("PROCSMAN_ADMIN_PASSWORD")
+	if !ok {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, cfg.Db)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	_, err = api.CreateAdmin(ctx, db.New(pool), username, password)
+	return err
+}
@@ -0,0 +1,261 @@
+package procsmanager
+
+import (
+	"fmt"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+	"sync"
+	"time"
+)
+
+// dispatchInterval is how often digests are sent and flapping processes are checked.
+const dispatchInterval = 5 * time.Second
+
+// flapEvents are the state changes that are counted to detect flapping.
+var flapEvents = []string{
+	string(db.ProcessEventTypeSTART),
+	string(db.ProcessEventTypeSTOP),
+	string(db.ProcessEventTypeCRASH),
+	string(db.ProcessEventTypeRESTART),
+}
+
+// finalEvents are not suppressed while a process is flapping, they mean that it stopped for good.
+var finalEvents = []string{
+	string(db.ProcessEventTypeFULLSTOP),
+	string(db.ProcessEventTypeFULLCRASH),
+	string(db.ProcessEventTypeMANUALLYSTOPPED),
+}
+
+// eventVerbs describe event types in digests, e.g. "Process x restarted 7 more times in 2m0s".
+var eventVerbs = map[string]string{
+	string(db.ProcessEventTypeSTART):           "started",
+	string(db.ProcessEventTypeSTOP):            "stopped",
+	string(db.ProcessEventTypeCRASH):           "crashed",
+	string(db.ProcessEventTypeFULLSTOP):        "fully stopped",
+	string(db.ProcessEventTypeFULLCRASH):       "fully crashed",
+	string(db.ProcessEventTypeMANUALLYSTOPPED): "was manually stopped",
+	string(db.ProcessEventTypeRESTART):         "restarted",
+	string(db.ProcessEventTypeTHRESHOLD):       "crossed a threshold",
+}
+
+// pendingDigest counts the notifications of an event type that were held back.
+type pendingDigest struct {
+	// count is the number of notifications held back by MaxPerWindow.
+	count int
+	// duplicates is the number of notifications dropped by DedupWindow. They're never sent on their own,
+	// only counted if there is a digest for held back notifications anyway.
+	duplicates int
+	since      time.Time
+	// last is the newest held back notification, the digest is routed like it.
+	last *config.Notification
+}
+
+// processDispatch is the dispatch state of a process.
+type processDispatch struct {
+	// sent are the times of notifications sent within DigestWindow.
+	sent []time.Time
+	// seen are the times identical notifications were last sent, by dedupKey.
+	seen    map[string]time.Time
+	digests map[string]*pendingDigest
+	// changes are the times of state changes within FlapWindow.
+	changes []time.Time
+
+	flapping   bool
+	flapSince  time.Time
+	suppressed int
+	// flapLast is the newest notification while flapping, the alert that it stopped is routed like it.
+	flapLast *config.Notification
+}
+
+func (p *processDispatch) idle() bool {
+	return !p.flapping && len(p.sent) == 0 && len(p.seen) == 0 && len(p.digests) == 0 && len(p.changes) == 0
+}
+
+// notificationDispatcher sits between runners and the notification channels. It drops duplicates,
+// batches notifications of processes that send too many into digests, and replaces the notifications
+// of flapping processes with an alert when they start and one when they stop flapping.
+type notificationDispatcher struct {
+	cfg  config.NotificationDispatchConfig
+	send func(n *config.Notification)
+
+	mu        sync.Mutex
+	processes map[int32]*processDispatch
+}
+
+func newNotificationDispatcher(cfg config.NotificationDispatchConfig, send func(n *config.Notification)) *notificationDispatcher {
+	return &notificationDispatcher{
+		cfg:       cfg,
+		send:      send,
+		processes: make(map[int32]*processDispatch),
+	}
+}
+
+// pruneTimes removes the times before since from times, which is sorted.
+func pruneTimes(times []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(since) {
+		i++
+	}
+	return times[i:]
+}
+
+func dedupKey(n *config.Notification) string {
+	return n.Event + "\x00" + n.Text + "\x00" + string(n.AdditionalInfo)
+}
+
+// composed returns a copy of base with a text that is not replaced by templates.
+func composed(base *config.Notification, text string, now time.Time) *config.Notification {
+	n := *base
+	n.Text = text
+	n.Composed = true
+	n.Time = now.Unix()
+	return &n
+}
+
+// Dispatch sends n now, later as part of a digest, or not at all. It doesn't block.
+// Notifications that are not about a process are always sent.
+func (d *notificationDispatcher) Dispatch(n *config.Notification) {
+	if !d.cfg.Enabled || n.ProcessID == 0 {
+		go d.send(n)
+		return
+	}
+	d.mu.Lock()
+	toSend := d.dispatch(n, time.Now())
+	d.mu.Unlock()
+	for _, n := range toSend {
+		go d.send(n)
+	}
+}
+
+func (d *notificationDispatcher) dispatch(n *config.Notification, now time.Time) []*config.Notification {
+	p := d.processes[n.ProcessID]
+	if p == nil {
+		p = &processDispatch{seen: make(map[string]time.Time), digests: make(map[string]*pendingDigest)}
+		d.processes[n.ProcessID] = p
+	}
+
+	if slices.Contains(flapEvents, n.Event) {
+		p.changes = append(pruneTimes(p.changes, now.Add(-d.cfg.FlapWindow)), now)
+		if !p.flapping && len(p.changes) >= d.cfg.FlapThreshold {
+			p.flapping = true
+			p.flapSince = now
+			p.flapLast = n
+			// held back notifications are part of the flapping, they're not sent on their own.
+			p.suppressed = 0
+			for event, digest := range p.digests {
+				p.suppressed += digest.count
+				delete(p.digests, event)
+			}
+			text := fmt.Sprintf("Process %s is flapping: %d state changes in %s. Its notifications are suppressed until it's stable.",
+				n.ProcessName, len(p.changes), d.cfg.FlapWindow)
+			return []*config.Notification{composed(n, text, now)}
+		}
+	}
+	toSend := make([]*config.Notification, 0, 2)
+	if p.flapping {
+		if !slices.Contains(finalEvents, n.Event) {
+			p.suppressed++
+			p.flapLast = n
+			return nil
+		}
+		// the process stopped for good, so it's not flapping anymore.
+		toSend = append(toSend, d.stopFlapping(p, now))
+		p.changes = nil
+	}
+
+	key := dedupKey(n)
+	p.sent = pruneTimes(p.sent, now.Add(-d.cfg.DigestWindow))
+	duplicate := false
+	if last, ok := p.seen[key]; ok && now.Sub(last) < d.cfg.DedupWindow {
+		duplicate = true
+	}
+	if duplicate || len(p.sent) >= d.cfg.MaxPerWindow {
+		digest := p.digests[n.Event]
+		if digest == nil {
+			digest = &pendingDigest{since: now}
+			p.digests[n.Event] = digest
+		}
+		if duplicate {
+			digest.duplicates++
+		} else {
+			digest.count++
+			digest.last = n
+		}
+		return toSend
+	}
+	p.seen[key] = now
+	p.sent = append(p.sent, now)
+	return append(toSend, n)
+}
+
+// stopFlapping returns the alert that p stopped flapping.
+func (d *notificationDispatcher) stopFlapping(p *processDispatch, now time.Time) *config.Notification {
+	p.flapping = false
+	text := fmt.Sprintf("Process %s stopped flapping after %s, %d notifications were suppressed.",
+		p.flapLast.ProcessName, now.Sub(p.flapSince).Round(time.Second), p.suppressed)
+	alert := composed(p.flapLast, text, now)
+	p.flapLast = nil
+	return alert
+}
+
+// flush sends the digests whose window is over and the alerts of processes that stopped flapping.
+func (d *notificationDispatcher) flush(now time.Time) []*config.Notification {
+	toSend := make([]*config.Notification, 0)
+	for processID, p := range d.processes {
+		for event, digest := range p.digests {
+			if now.Sub(digest.since) < d.cfg.DigestWindow {
+				continue
+			}
+			delete(p.digests, event)
+			if digest.count == 0 {
+				// only duplicates, they're dropped.
+				continue
+			}
+			if digest.count == 1 && digest.duplicates == 0 {
+				toSend = append(toSend, digest.last)
+				continue
+			}
+			verb, ok := eventVerbs[event]
+			if !ok {
+				verb = "had the event " + event
+			}
+			text := fmt.Sprintf("Process %s %s %d more times in %s", digest.last.ProcessName, verb, digest.count+digest.duplicates, now.Sub(digest.since).Round(time.Second))
+			toSend = append(toSend, composed(digest.last, text, now))
+		}
+
+		p.changes = pruneTimes(p.changes, now.Add(-d.cfg.FlapWindow))
+		if p.flapping && len(p.changes) < (d.cfg.FlapThreshold+1)/2 {
+			toSend = append(toSend, d.stopFlapping(p, now))
+		}
+
+		p.sent = pruneTimes(p.sent, now.Add(-d.cfg.DigestWindow))
+		for key, last := range p.seen {
+			if now.Sub(last) >= d.cfg.DedupWindow {
+				delete(p.seen, key)
+			}
+		}
+		if p.idle() {
+			delete(d.processes, processID)
+		}
+	}
+	return toSend
+}
+
+func (d *notificationDispatcher) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		d.mu.Lock()
+		toSend := d.flush(time.Now())
+		d.mu.Unlock()
+		for _, n := range toSend {
+			go d.send(n)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package procsmanager
+
+import (
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+	"testing"
+	"time"
+)
+
+var testBase = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	eventStart     = string(db.ProcessEventTypeSTART)
+	eventCrash     = string(db.ProcessEventTypeCRASH)
+	eventRestart   = string(db.ProcessEventTypeRESTART)
+	eventFullCrash = string(db.ProcessEventTypeFULLCRASH)
+	eventThreshold = string(db.ProcessEventTypeTHRESHOLD)
+)
+
+// dispatchStep dispatches a notification, or flushes if flush is set, and expects the texts of the notifications to send.
+type dispatchStep struct {
+	at    time.Duration
+	flush bool
+	event string
+	text  string
+	want  []string
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxPerWindow int
+		steps        []dispatchStep
+	}{
+		{
+			name: "duplicates are dropped",
+			steps: []dispatchStep{
+				{at: 0, event: eventThreshold, text: "cpu", want: []string{"cpu"}},
+				{at: 10 * time.Second, event: eventThreshold, text: "cpu"},
+				{at: 20 * time.Second, event: eventThreshold, text: "memory", want: []string{"memory"}},
+				{at: 130 * time.Second, flush: true},
+				{at: 140 * time.Second, event: eventThreshold, text: "cpu", want: []string{"cpu"}},
+			},
+		},
+		{
+			name: "held back notifications are sent in a digest",
+			steps: []dispatchStep{
+				{at: 0, event: eventThreshold, text: "1", want: []string{"1"}},
+				{at: time.Second, event: eventThreshold, text: "2", want: []string{"2"}},
+				{at: 2 * time.Second, event: eventThreshold, text: "3", want: []string{"3"}},
+				{at: 3 * time.Second, event: eventThreshold, text: "4"},
+				{at: 4 * time.Second, event: eventThreshold, text: "5"},
+				{at: 60 * time.Second, flush: true},
+				{at: 123 * time.Second, flush: true, want: []string{"Process p crossed a threshold 2 more times in 2m0s"}},
+			},
+		},
+		{
+			name: "a single held back notification is sent as is",
+			steps: []dispatchStep{
+				{at: 0, event: eventThreshold, text: "1", want: []string{"1"}},
+				{at: time.Second, event: eventThreshold, text: "2", want: []string{"2"}},
+				{at: 2 * time.Second, event: eventThreshold, text: "3", want: []string{"3"}},
+				{at: 3 * time.Second, event: eventThreshold, text: "4"},
+				{at: 123 * time.Second, flush: true, want: []string{"4"}},
+			},
+		},
+		{
+			name: "duplicates are counted in a digest of held back notifications",
+			steps: []dispatchStep{
+				{at: 0, event: eventThreshold, text: "1", want: []string{"1"}},
+				{at: time.Second, event: eventThreshold, text: "2", want: []string{"2"}},
+				{at: 2 * time.Second, event: eventThreshold, text: "3", want: []string{"3"}},
+				{at: 3 * time.Second, event: eventThreshold, text: "4"},
+				{at: 4 * time.Second, event: eventThreshold, text: "1"},
+				{at: 123 * time.Second, flush: true, want: []string{"Process p crossed a threshold 2 more times in 2m0s"}},
+			},
+		},
+		{
+			name: "duplicates alone are not sent when the window is full",
+			steps: []dispatchStep{
+				{at: 0, event: eventThreshold, text: "1", want: []string{"1"}},
+				{at: time.Second, event: eventThreshold, text: "2", want: []string{"2"}},
+				{at: 2 * time.Second, event: eventThreshold, text: "3", want: []string{"3"}},
+				{at: 3 * time.Second, event: eventThreshold, text: "1"},
+				{at: 123 * time.Second, flush: true},
+			},
+		},
+		{
+			name:         "flapping starts and stops",
+			maxPerWindow: 10,
+			steps: []dispatchStep{
+				{at: 0, event: eventStart, text: "start", want: []string{"start"}},
+				{at: time.Second, event: eventCrash, text: "crash", want: []string{"crash"}},
+				{at: 2 * time.Second, event: eventRestart, text: "restart", want: []string{"restart"}},
+				{at: 3 * time.Second, event: eventCrash, text: "crash again", want: []string{
+					"Process p is flapping: 4 state changes in 5m0s. Its notifications are suppressed until it's stable.",
+				}},
+				{at: 4 * time.Second, event: eventRestart, text: "restart again"},
+				{at: 5 * time.Second, event: eventThreshold, text: "cpu"},
+				{at: 200 * time.Second, flush: true},
+				{at: 305 * time.Second, flush: true, want: []string{"Process p stopped flapping after 5m2s, 2 notifications were suppressed."}},
+				{at: 310 * time.Second, event: eventStart, text: "stable", want: []string{"stable"}},
+			},
+		},
+		{
+			name:         "final events end flapping",
+			maxPerWindow: 10,
+			steps: []dispatchStep{
+				{at: 0, event: eventStart, text: "start", want: []string{"start"}},
+				{at: time.Second, event: eventCrash, text: "crash", want: []string{"crash"}},
+				{at: 2 * time.Second, event: eventRestart, text: "restart", want: []string{"restart"}},
+				{at: 3 * time.Second, event: eventCrash, text: "crash again", want: []string{
+					"Process p is flapping: 4 state changes in 5m0s. Its notifications are suppressed until it's stable.",
+				}},
+				{at: 10 * time.Second, event: eventFullCrash, text: "gave up", want: []string{
+					"Process p stopped flapping after 7s, 0 notifications were suppressed.",
+					"gave up",
+				}},
+				{at: 305 * time.Second, flush: true},
+			},
+		},
+		{
+			name:         "held back notifications are suppressed when flapping starts",
+			maxPerWindow: 2,
+			steps: []dispatchStep{
+				{at: 0, event: eventStart, text: "start", want: []string{"start"}},
+				{at: time.Second, event: eventCrash, text: "crash", want: []string{"crash"}},
+				{at: 2 * time.Second, event: eventRestart, text: "restart"},
+				{at: 3 * time.Second, event: eventCrash, text: "crash again", want: []string{
+					"Process p is flapping: 4 state changes in 5m0s. Its notifications are suppressed until it's stable.",
+				}},
+				{at: 305 * time.Second, flush: true, want: []string{"Process p stopped flapping after 5m2s, 1 notifications were suppressed."}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NotificationDispatchConfig{
+				Enabled:       true,
+				DedupWindow:   time.Minute,
+				MaxPerWindow:  3,
+				DigestWindow:  2 * time.Minute,
+				FlapThreshold: 4,
+				FlapWindow:    5 * time.Minute,
+			}
+			if tt.maxPerWindow != 0 {
+				cfg.MaxPerWindow = tt.maxPerWindow
+			}
+			d := newNotificationDispatcher(cfg, nil)
+			for i, step := range tt.steps {
+				now := testBase.Add(step.at)
+				var sent []*config.Notification
+				if step.flush {
+					sent = d.flush(now)
+				} else {
+					sent = d.dispatch(&config.Notification{ProcessID: 1, ProcessName: "p", Event: step.event, Text: step.text}, now)
+				}
+				texts := make([]string, len(sent))
+				for j, n := range sent {
+					texts[j] = n.Text
+				}
+				if !slices.Equal(texts, step.want) && (len(texts) != 0 || len(step.want) != 0) {
+					t.Fatalf("step %d: sent %q, want %q", i, texts, step.want)
+				}
+			}
+
+			// once everything is over, nothing is left to send and the process is forgotten.
+			if sent := d.flush(testBase.Add(24 * time.Hour)); len(sent) != 0 {
+				t.Fatalf("sent %d notifications after the end", len(sent))
+			}
+			if len(d.processes) != 0 {
+				t.Fatal("the state of the process was kept")
+			}
+		})
+	}
+}
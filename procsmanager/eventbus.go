@@ -0,0 +1,211 @@
+package procsmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"procsman_backend/db"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BusEventKind string
+
+const (
+	BusEventStatus BusEventKind = "status"
+	BusEventEvent  BusEventKind = "event"
+	BusEventStats  BusEventKind = "stats"
+	BusEventConfig BusEventKind = "config"
+)
+
+// StatsSample is a single stats recording. The extended metrics are only set if their group was collected.
+type StatsSample struct {
+	CpuUsagePercent float64 `json:"cpu_usage_percent"`
+	MemUsage        int64   `json:"mem_usage"`
+	Threads         *int64  `json:"threads,omitempty"`
+	OpenFds         *int64  `json:"open_fds,omitempty"`
+	Children        *int64  `json:"children,omitempty"`
+}
+
+// BusEvent is published on the EventBus. Only the fields of its Kind are set.
+type BusEvent struct {
+	Seq       uint64       `json:"seq"`
+	Kind      BusEventKind `json:"kind"`
+	ProcessID int32        `json:"process_id"`
+	GroupID   pgtype.Int4  `json:"group_id"`
+	Time      int64        `json:"time"`
+
+	Status         db.ProcessStatus    `json:"status,omitempty"`
+	Event          db.ProcessEventType `json:"event,omitempty"`
+	AdditionalInfo json.RawMessage     `json:"additional_info,omitempty"`
+	Stats          *StatsSample        `json:"stats,omitempty"`
+	// Deleted is set on config events of deleted processes.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// BusFilter selects events for a subscription. Empty fields match everything.
+type BusFilter struct {
+	ProcessIDs []int32
+	GroupIDs   []int32
+	Kinds      []BusEventKind
+	// EventTypes only applies to events of kind BusEventEvent.
+	EventTypes []db.ProcessEventType
+}
+
+func (f *BusFilter) Match(e *BusEvent) bool {
+	if len(f.ProcessIDs) > 0 && !containsValue(f.ProcessIDs, e.ProcessID) {
+		return false
+	}
+	if len(f.GroupIDs) > 0 && (!e.GroupID.Valid || !containsValue(f.GroupIDs, e.GroupID.Int32)) {
+		return false
+	}
+	if len(f.Kinds) > 0 && !containsValue(f.Kinds, e.Kind) {
+		return false
+	}
+	if e.Kind == BusEventEvent && len(f.EventTypes) > 0 && !containsValue(f.EventTypes, e.Event) {
+		return false
+	}
+	return true
+}
+
+func containsValue[T comparable](s []T, v T) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// eventBusHistory is how many events are kept for resuming subscribers.
+	eventBusHistory = 4096
+	// subscriptionBuffer is how many events a subscriber may fall behind before it's dropped.
+	subscriptionBuffer = 256
+)
+
+// EventBus fans out process events to subscribers, and keeps the latest ones so that
+// reconnecting subscribers can catch up. Sequence numbers restart with procsman,
+// so resume tokens also carry the epoch of the bus.
+type EventBus struct {
+	mu          sync.Mutex
+	epoch       int64
+	seq         uint64
+	history     []BusEvent
+	subscribers map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		epoch:       time.Now().UnixNano(),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events on C. C is closed when the subscriber falls too far behind or unsubscribes.
+type Subscription struct {
+	C      chan BusEvent
+	filter BusFilter
+	bus    *EventBus
+	// Head is the sequence number of the last event published before subscribing.
+	Head uint64
+	// Lagged is set when C was closed because the subscriber fell behind.
+	Lagged bool
+}
+
+// Publish assigns a sequence number to e and sends it to all matching subscribers.
+func (b *EventBus) Publish(e BusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if e.Time == 0 {
+		e.Time = UtcNow().Unix()
+	}
+
+	if len(b.history) >= eventBusHistory {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:len(b.history)-1]
+	}
+	b.history = append(b.history, e)
+
+	for sub := range b.subscribers {
+		if !sub.filter.Match(&e) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+			sub.Lagged = true
+			delete(b.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Token returns the resume token for the event with the given sequence number.
+func (b *EventBus) Token(seq uint64) string {
+	return fmt.Sprintf("%d-%d", b.epoch, seq)
+}
+
+func (b *EventBus) parseToken(token string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(token, "-")
+	if !ok || epoch != strconv.FormatInt(b.epoch, 10) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Subscribe subscribes to events matching filter. If resumeToken is not empty, matching events
+// published after it are returned as well. complete is false if some of them are no longer kept,
+// or the token is from a different run of procsman, in that case the client has to reload its state.
+func (b *EventBus) Subscribe(filter BusFilter, resumeToken string) (sub *Subscription, missed []BusEvent, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		C:      make(chan BusEvent, subscriptionBuffer),
+		filter: filter,
+		bus:    b,
+		Head:   b.seq,
+	}
+	b.subscribers[sub] = struct{}{}
+
+	if resumeToken == "" {
+		return sub, nil, true
+	}
+	after, ok := b.parseToken(resumeToken)
+	if !ok || after > b.seq {
+		return sub, nil, false
+	}
+	complete = after == b.seq || (len(b.history) > 0 && b.history[0].Seq <= after+1)
+	for _, e := range b.history {
+		if e.Seq > after && filter.Match(&e) {
+			missed = append(missed, e)
+		}
+	}
+	return sub, missed, complete
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subscribers[s]; ok {
+		delete(s.bus.subscribers, s)
+		close(s.C)
+	}
+}
+
+// publish sends an event about the runner's process to the manager's bus.
+func (pr *ProcessRunner) publish(e BusEvent) {
+	e.ProcessID = pr.Process.ID
+	e.GroupID = pr.Process.ProcessGroupID
+	pr.Manager.Events.Publish(e)
+}
@@ -0,0 +1,81 @@
+package procsmanager
+
+import (
+	"context"
+	"errors"
+	"procsman_backend/db"
+	"time"
+)
+
+var errHostStatsUnsupported = errors.New("host stats are not supported on this platform")
+
+// HostUsageInfo is a snapshot of host-wide usage.
+// CpuBusy and CpuTotal are counters, so CPU usage can only be computed from two snapshots.
+// Disk usage is of the file system LogsFolder is on.
+type HostUsageInfo struct {
+	CpuBusy     uint64
+	CpuTotal    uint64
+	MemoryTotal int64
+	MemoryUsed  int64
+	SwapTotal   int64
+	SwapUsed    int64
+	Load1       float64
+	Load5       float64
+	Load15      float64
+	DiskTotal   int64
+	DiskUsed    int64
+	When        time.Time
+}
+
+// cpuUsagePercent returns the CPU usage of the whole host between prev and h.
+func (h *HostUsageInfo) cpuUsagePercent(prev *HostUsageInfo) float64 {
+	if h.CpuTotal <= prev.CpuTotal || h.CpuBusy < prev.CpuBusy {
+		return 0
+	}
+	percent := float64(h.CpuBusy-prev.CpuBusy) / float64(h.CpuTotal-prev.CpuTotal) * 100.0
+	return float64(int(percent*1000)) / 1000
+}
+
+// hostStatsWorker records host usage every ProcessStatsInterval.
+// It should be called in a goroutine.
+func (pm *ProcessManager) hostStatsWorker() {
+	logger := pm.Logger.NewLogger("host")
+	ticker := time.NewTicker(pm.Config.ProcessStatsInterval)
+	defer ticker.Stop()
+
+	var last *HostUsageInfo
+	for {
+		usage, err := getHostUsageInfo(pm.Config.LogsFolder)
+		if err != nil {
+			if errors.Is(err, errHostStatsUnsupported) {
+				logger.Warningln("Host stats are not supported on this platform, not recording them")
+				return
+			}
+			logger.Errorf("Failed to get host usage: %v\n", err)
+		} else if last != nil {
+			if _, err = pm.Queries.InsertHostStats(context.Background(), db.InsertHostStatsParams{
+				CpuUsagePercentage: usage.cpuUsagePercent(last),
+				MemoryTotal:        usage.MemoryTotal,
+				MemoryUsed:         usage.MemoryUsed,
+				SwapTotal:          usage.SwapTotal,
+				SwapUsed:           usage.SwapUsed,
+				Load1:              usage.Load1,
+				Load5:              usage.Load5,
+				Load15:             usage.Load15,
+				DiskTotal:          usage.DiskTotal,
+				DiskUsed:           usage.DiskUsed,
+			}); err != nil {
+				logger.Errorf("Failed to record host usage: %v\n", err)
+			}
+		}
+		if usage != nil {
+			last = usage
+		}
+
+		select {
+		case <-pm.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
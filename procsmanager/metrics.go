@@ -0,0 +1,45 @@
+package procsmanager
+
+import (
+	"time"
+)
+
+// RunnerMetrics are in-memory values of a runner, exposed on /metrics.
+// Counters start from zero every time procsman starts.
+type RunnerMetrics struct {
+	// Pid is 0 if the process is not running.
+	Pid       int
+	StartedAt time.Time
+
+	// CpuUsagePercent and MemUsage are taken from the last usage recording.
+	CpuUsagePercent float64
+	MemUsage        int64
+
+	Starts   uint64
+	Crashes  uint64
+	Restarts uint64
+}
+
+// Metrics returns a copy of the runner's metrics.
+func (pr *ProcessRunner) Metrics() RunnerMetrics {
+	pr.metricsMu.Lock()
+	defer pr.metricsMu.Unlock()
+	return pr.metrics
+}
+
+func (pr *ProcessRunner) updateMetrics(update func(m *RunnerMetrics)) {
+	pr.metricsMu.Lock()
+	defer pr.metricsMu.Unlock()
+	update(&pr.metrics)
+}
+
+// Runners returns all runners, ordered by nothing in particular.
+func (pm *ProcessManager) Runners() []*ProcessRunner {
+	pm.runnersMutex.RLock()
+	defer pm.runnersMutex.RUnlock()
+	runners := make([]*ProcessRunner, 0, len(pm.runners))
+	for _, runner := range pm.runners {
+		runners = append(runners, runner)
+	}
+	return runners
+}
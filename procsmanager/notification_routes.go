@@ -0,0 +1,95 @@
+package procsmanager
+
+import (
+	"context"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+)
+
+// LoadNotificationRoutes reads the routes from the database. It must be called after they change.
+func (pm *ProcessManager) LoadNotificationRoutes(ctx context.Context) error {
+	routes, err := pm.Queries.GetNotificationRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	pm.routesMutex.Lock()
+	pm.routes = routes
+	pm.routesMutex.Unlock()
+	return nil
+}
+
+// routeMatches reports whether n is about one of the processes, groups and event types of route.
+func routeMatches(route *db.NotificationRoute, n *config.Notification) bool {
+	if len(route.ProcessIds) > 0 && !slices.Contains(route.ProcessIds, n.ProcessID) {
+		return false
+	}
+	if len(route.GroupIds) > 0 && (n.GroupID == nil || !slices.Contains(route.GroupIds, *n.GroupID)) {
+		return false
+	}
+	return len(route.Events) == 0 || slices.Contains(route.Events, n.Event)
+}
+
+// RouteNotification sets the targets of n from the notification routes. Routes are tried in order and the first
+// matching one is used, unless it has ContinueMatching. If none matches, the default route is used, and without
+// a default route n goes to all channels. The NotifyOn* settings of the process are checked before this, and the
+// event filters of the channels after it.
+func (pm *ProcessManager) RouteNotification(n *config.Notification) {
+	pm.routesMutex.RLock()
+	defer pm.routesMutex.RUnlock()
+
+	var targets []config.Target
+	var defaultRoute *db.NotificationRoute
+	matched := false
+	for i := range pm.routes {
+		route := &pm.routes[i]
+		if route.IsDefault {
+			defaultRoute = route
+			continue
+		}
+		if !routeMatches(route, n) {
+			continue
+		}
+		matched = true
+		targets = appendTargets(targets, route.Targets)
+		if !route.ContinueMatching {
+			break
+		}
+	}
+	if !matched {
+		if defaultRoute == nil {
+			return
+		}
+		targets = appendTargets(targets, defaultRoute.Targets)
+	}
+	if targets == nil {
+		// the matching routes have no targets, which drops the notification.
+		targets = make([]config.Target, 0)
+	}
+	n.Targets = targets
+}
+
+// appendTargets adds routed to targets, merging the recipients of targets on the same channel.
+// A target without recipients means all recipients of the channel, so it wins over one with recipients.
+func appendTargets(targets []config.Target, routed db.NotificationTargets) []config.Target {
+	for _, target := range routed {
+		i := slices.IndexFunc(targets, func(t config.Target) bool { return t.Channel == target.Channel })
+		if i == -1 {
+			targets = append(targets, config.Target{Channel: target.Channel, Recipients: slices.Clone(target.Recipients)})
+			continue
+		}
+		if len(targets[i].Recipients) == 0 {
+			continue
+		}
+		if len(target.Recipients) == 0 {
+			targets[i].Recipients = nil
+			continue
+		}
+		for _, recipient := range target.Recipients {
+			if !slices.Contains(targets[i].Recipients, recipient) {
+				targets[i].Recipients = append(targets[i].Recipients, recipient)
+			}
+		}
+	}
+	return targets
+}
@@ -0,0 +1,148 @@
+package procsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"os"
+	"procsman_backend/config"
+	"procsman_backend/db"
+)
+
+// Notifications returns the notification settings and channels. The result must not be modified,
+// changes are stored in the database and applied with LoadNotificationsConfig.
+func (pm *ProcessManager) Notifications() *config.NotificationsConfig {
+	pm.notificationsMutex.RLock()
+	defer pm.notificationsMutex.RUnlock()
+	return pm.notifications
+}
+
+// LoadNotificationsConfig reads the notification settings and channels from the database. It must be called after
+// they change. On the first start, they are imported from config.NotificationConfigFileName if it exists.
+func (pm *ProcessManager) LoadNotificationsConfig(ctx context.Context) error {
+	settingsJson, err := pm.Queries.GetNotificationSettings(ctx)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err = pm.importNotificationsConfig(ctx); err != nil {
+			return err
+		}
+		settingsJson, err = pm.Queries.GetNotificationSettings(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	nc := &config.NotificationsConfig{NotificationSettings: config.DefaultNotificationSettings()}
+	if err = json.Unmarshal(settingsJson, &nc.NotificationSettings); err != nil {
+		return fmt.Errorf("invalid notification settings: %w", err)
+	}
+	channels, err := pm.Queries.GetNotificationChannels(ctx)
+	if err != nil {
+		return err
+	}
+	nc.Channels = make([]config.ChannelConfig, 0, len(channels))
+	for _, row := range channels {
+		channel, err := NotificationChannelConfig(row)
+		if err != nil {
+			// a broken channel shouldn't stop the others from working.
+			pm.Logger.Errorf("Skipping notification channel %d: %v\n", row.ID, err)
+			continue
+		}
+		nc.Channels = append(nc.Channels, channel)
+	}
+
+	pm.notificationsMutex.Lock()
+	pm.notifications = nc
+	pm.notificationsMutex.Unlock()
+	return nil
+}
+
+// importNotificationsConfig stores the notification settings and channels of config.NotificationConfigFileName
+// in the database, or the default settings if there is no such file. The file is renamed afterward.
+func (pm *ProcessManager) importNotificationsConfig(ctx context.Context) error {
+	nc, err := config.ReadNotificationsConfigFile(config.NotificationConfigFileName)
+	imported := err == nil
+	if errors.Is(err, os.ErrNotExist) {
+		nc = &config.NotificationsConfig{NotificationSettings: config.DefaultNotificationSettings()}
+	} else if err != nil {
+		return fmt.Errorf("importing %s: %w", config.NotificationConfigFileName, err)
+	}
+	settingsJson, err := json.Marshal(nc.NotificationSettings)
+	if err != nil {
+		return err
+	}
+
+	tx, queries, err := pm.OpenTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	created, err := queries.CreateNotificationSettings(ctx, settingsJson)
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		// another instance imported them in the meantime.
+		return nil
+	}
+	for i := range nc.Channels {
+		params, err := NotificationChannelParams(&nc.Channels[i])
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", config.NotificationConfigFileName, err)
+		}
+		if _, err = queries.CreateNotificationChannel(ctx, params); err != nil {
+			return fmt.Errorf("importing channel %s: %w", nc.Channels[i].Name, err)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if imported {
+		pm.Logger.Infof("Imported notification settings and %d channels from %s\n", len(nc.Channels), config.NotificationConfigFileName)
+		if err = os.Rename(config.NotificationConfigFileName, config.NotificationConfigFileName+".imported"); err != nil {
+			pm.Logger.Warningf("Failed to rename %s, it's not used anymore: %v\n", config.NotificationConfigFileName, err)
+		}
+	}
+	return nil
+}
+
+// NotificationChannelParams returns the stored form of a channel.
+func NotificationChannelParams(channel *config.ChannelConfig) (db.CreateNotificationChannelParams, error) {
+	settings, err := channel.Settings()
+	if err != nil {
+		return db.CreateNotificationChannelParams{}, err
+	}
+	params := db.CreateNotificationChannelParams{
+		Name:      channel.Name,
+		Type:      string(channel.Type),
+		Enabled:   channel.Enabled,
+		Events:    channel.Events,
+		Templates: channel.Templates,
+		Settings:  settings,
+	}
+	if params.Events == nil {
+		params.Events = make([]string, 0)
+	}
+	if params.Templates == nil {
+		params.Templates = make(map[string]string)
+	}
+	return params, nil
+}
+
+// NotificationChannelConfig returns a stored channel as a config.ChannelConfig.
+func NotificationChannelConfig(row db.NotificationChannel) (config.ChannelConfig, error) {
+	channel := config.ChannelConfig{
+		ID:        row.ID,
+		Name:      row.Name,
+		Type:      config.ChannelType(row.Type),
+		Enabled:   row.Enabled,
+		Events:    row.Events,
+		Templates: row.Templates,
+	}
+	err := channel.SetSettings(row.Settings)
+	return channel, err
+}
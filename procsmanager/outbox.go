@@ -0,0 +1,209 @@
+package procsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/apepenkov/yalog"
+	"github.com/jackc/pgx/v5/pgtype"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// outboxInterval is how often the outbox is checked for retries. New notifications are delivered right away.
+	outboxInterval  = 5 * time.Second
+	outboxBatchSize = 50
+	// outboxCleanupInterval is how often notifications older than HistoryRetention are deleted.
+	outboxCleanupInterval = time.Hour
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+// enqueueNotification stores a delivery of n for each of its channels in the outbox and wakes the outbox worker.
+// If a delivery can't be stored, it's sent right away, without retries.
+func (pm *ProcessManager) enqueueNotification(n *config.Notification) {
+	for _, delivery := range pm.Notifications().Deliveries(n) {
+		body, err := json.Marshal(delivery.Notification)
+		if err == nil {
+			recipients := delivery.Target.Recipients
+			if recipients == nil {
+				recipients = make([]string, 0)
+			}
+			_, err = pm.Queries.InsertNotificationOutbox(context.Background(), db.InsertNotificationOutboxParams{
+				ProcessID:    pgtype.Int4{Int32: n.ProcessID, Valid: n.ProcessID != 0},
+				Event:        n.Event,
+				Channel:      delivery.Target.Channel,
+				Recipients:   recipients,
+				Notification: body,
+			})
+		}
+		if err != nil {
+			pm.Logger.Errorf("Failed to store notification for %s, sending it without retries: %v\n", delivery.Target.Channel, err)
+			for _, r := range pm.Notifications().Deliver(delivery) {
+				if !r.Success {
+					pm.NotificationFailures.Add(1)
+					pm.Logger.Warningf("Failed to send notification of %s to %s: %v\n", n.ProcessName, r.Channel, r.Error)
+				}
+			}
+		}
+	}
+	select {
+	case pm.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// retryDelay is the delay before the next attempt, after the given number of failed attempts.
+func retryDelay(cfg *config.NotificationDispatchConfig, attempts int32) time.Duration {
+	delay := cfg.RetryDelay
+	for i := int32(1); i < attempts && delay < cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, cfg.MaxRetryDelay)
+}
+
+// resultRecipient identifies the recipient of a result, so a retry can be limited to it.
+// It's empty for channels without recipients, like webhooks.
+func resultRecipient(r config.SendResult) string {
+	if r.Recipient != "" {
+		return r.Recipient
+	}
+	if r.ChatId != 0 {
+		return strconv.FormatInt(r.ChatId, 10)
+	}
+	return ""
+}
+
+// deliverOutbox makes an attempt to deliver a notification from the outbox and records its results.
+// Recipients that got it are not retried, if the channel reports results per recipient.
+func (pm *ProcessManager) deliverOutbox(logger *yalog.Logger, entry db.NotificationOutbox) {
+	ctx := context.Background()
+	cfg := pm.Config.NotificationDispatch
+	attempt := entry.Attempts + 1
+
+	var results []config.SendResult
+	final := attempt >= int32(cfg.MaxAttempts)
+	var n config.Notification
+	if err := json.Unmarshal(entry.Notification, &n); err != nil {
+		results = []config.SendResult{{Channel: entry.Channel, Error: "invalid notification: " + err.Error()}}
+		final = true
+	} else if !pm.Notifications().Enabled {
+		// they're not sent later either, that would flood the channels once notifications are enabled again.
+		results = []config.SendResult{{Channel: entry.Channel, Error: "notifications are disabled"}}
+		final = true
+	} else {
+		results = pm.Notifications().Deliver(config.Delivery{
+			Target:       config.Target{Channel: entry.Channel, Recipients: entry.Recipients},
+			Notification: &n,
+		})
+	}
+
+	failed := make([]string, 0)
+	failedWithoutRecipient := false
+	for _, r := range results {
+		params := db.InsertNotificationAttemptParams{
+			OutboxID:  entry.ID,
+			Attempt:   attempt,
+			Success:   r.Success,
+			Recipient: resultRecipient(r),
+			ChatID:    pgtype.Int8{Int64: r.ChatId, Valid: r.ChatId != 0},
+			MessageID: pgtype.Int4{Int32: int32(r.MessageId), Valid: r.MessageId != 0},
+			Error:     r.Error,
+		}
+		if err := pm.Queries.InsertNotificationAttempt(ctx, params); err != nil {
+			logger.Errorf("Failed to record attempt %d of notification %d: %v\n", attempt, entry.ID, err)
+		}
+		if r.Success {
+			continue
+		}
+		pm.NotificationFailures.Add(1)
+		if params.Recipient == "" {
+			failedWithoutRecipient = true
+		} else {
+			failed = append(failed, params.Recipient)
+		}
+	}
+
+	now := UtcNow()
+	update := db.UpdateNotificationOutboxParams{
+		ID:            entry.ID,
+		Status:        OutboxStatusPending,
+		Attempts:      attempt,
+		Recipients:    entry.Recipients,
+		NextAttemptAt: entry.NextAttemptAt,
+	}
+	switch {
+	case len(failed) == 0 && !failedWithoutRecipient:
+		update.Status = OutboxStatusSent
+		update.FinishedAt = pgtype.Timestamp{Time: now, Valid: true}
+	case final:
+		update.Status = OutboxStatusFailed
+		update.FinishedAt = pgtype.Timestamp{Time: now, Valid: true}
+		logger.Warningf("Giving up on notification %d to %s after %d attempts: %s\n", entry.ID, entry.Channel, attempt, results[len(results)-1].Error)
+	default:
+		if !failedWithoutRecipient {
+			update.Recipients = failed
+		}
+		update.NextAttemptAt = pgtype.Timestamp{Time: now.Add(retryDelay(cfg, attempt)), Valid: true}
+	}
+	if err := pm.Queries.UpdateNotificationOutbox(ctx, update); err != nil {
+		logger.Errorf("Failed to update notification %d: %v\n", entry.ID, err)
+	}
+}
+
+// deliverDueNotifications delivers the notifications that are due, a batch at a time.
+func (pm *ProcessManager) deliverDueNotifications(logger *yalog.Logger) {
+	for {
+		entries, err := pm.Queries.GetDueNotifications(context.Background(), outboxBatchSize)
+		if err != nil {
+			logger.Errorf("Failed to get due notifications: %v\n", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, entry := range entries {
+			wg.Add(1)
+			go func(entry db.NotificationOutbox) {
+				defer wg.Done()
+				pm.deliverOutbox(logger, entry)
+			}(entry)
+		}
+		wg.Wait()
+		if len(entries) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (pm *ProcessManager) outboxWorker() {
+	logger := pm.Logger.NewLogger("outbox")
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		pm.deliverDueNotifications(logger)
+
+		retention := pm.Config.NotificationDispatch.HistoryRetention
+		if retention > 0 && time.Since(lastCleanup) >= outboxCleanupInterval {
+			lastCleanup = time.Now()
+			before := pgtype.Timestamp{Time: UtcNow().Add(-retention), Valid: true}
+			if err := pm.Queries.DeleteFinishedNotificationsBefore(context.Background(), before); err != nil {
+				logger.Errorf("Failed to delete old notifications: %v\n", err)
+			}
+		}
+
+		select {
+		case <-pm.stop:
+			return
+		case <-ticker.C:
+		case <-pm.outboxWake:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package procsmanager
+
+import (
+	"bytes"
+	"procsman_backend/db"
+	"sync"
+)
+
+// recentOutputLines is how many lines of output notifications include in RecentLogs.
+// More are kept if the process attaches more to crash notifications.
+const recentOutputLines = 20
+
+// maxOutputLineLength truncates long lines, so a process without newlines in its output can't grow the buffer.
+const maxOutputLineLength = 1024
+
+// outputRing keeps the last lines of the output of a process in memory.
+type outputRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+func newOutputRing(size int) *outputRing {
+	return &outputRing{lines: make([]string, size)}
+}
+
+// outputRingSize is how many lines are kept for a process with the given configuration.
+func outputRingSize(cfg *db.Configuration) int {
+	return max(recentOutputLines, min(cfg.GetCrashOutputLines(), db.MaxCrashOutputLines))
+}
+
+func (o *outputRing) push(line []byte) {
+	if len(line) > maxOutputLineLength {
+		line = line[:maxOutputLineLength]
+	}
+	o.lines[o.next] = string(bytes.TrimRight(line, "\r"))
+	o.next = (o.next + 1) % len(o.lines)
+	if o.next == 0 {
+		o.full = true
+	}
+}
+
+func (o *outputRing) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rest := b
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		o.push(append(o.partial, rest[:i]...))
+		o.partial = o.partial[:0]
+		rest = rest[i+1:]
+	}
+	if len(o.partial)+len(rest) <= maxOutputLineLength {
+		o.partial = append(o.partial, rest...)
+	}
+	return len(b), nil
+}
+
+// Lines returns the kept lines, oldest first. An unfinished last line is included.
+func (o *outputRing) Lines() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lines := o.complete()
+	if len(o.partial) > 0 {
+		lines = append(lines, string(o.partial))
+	}
+	return lines
+}
+
+// complete returns the kept finished lines, oldest first. o.mu must be held.
+func (o *outputRing) complete() []string {
+	var lines []string
+	if o.full {
+		lines = append(lines, o.lines[o.next:]...)
+	}
+	return append(lines, o.lines[:o.next]...)
+}
+
+// Resize changes how many lines are kept, keeping the newest ones.
+func (o *outputRing) Resize(size int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if size == len(o.lines) {
+		return
+	}
+
+	kept := o.complete()
+	kept = kept[len(kept)-min(size, len(kept)):]
+	o.lines = make([]string, size)
+	copy(o.lines, kept)
+	o.next = len(kept) % size
+	o.full = len(kept) == size
+}
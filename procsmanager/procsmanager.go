@@ -8,18 +8,39 @@ import (
 	"procsman_backend/config"
 	"procsman_backend/db"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ProcessManager struct {
-	Queries       *db.Queries
-	Db            *pgxpool.Pool
-	Logger        *yalog.Logger
-	Config        *config.Config
-	Notifications *config.NotificationsConfig
+	Queries *db.Queries
+	Db      *pgxpool.Pool
+	Logger  *yalog.Logger
+	Config  *config.Config
+
+	// notifications are the notification settings and channels, see Notifications.
+	notifications      *config.NotificationsConfig
+	notificationsMutex sync.RWMutex
 
 	runners      map[int32]*ProcessRunner
 	runnersMutex sync.RWMutex
+
+	// routes are the notification routes, ordered like GetNotificationRoutes returns them.
+	routes      []db.NotificationRoute
+	routesMutex sync.RWMutex
+
+	// Events publishes status changes, events, stats and config changes of all processes.
+	Events *EventBus
+
+	// NotificationFailures counts notifications that could not be sent.
+	NotificationFailures atomic.Uint64
+
+	dispatcher *notificationDispatcher
+	// outboxWake wakes the outbox worker when notifications are added.
+	outboxWake chan struct{}
+
+	// stop is closed by Close to stop background workers.
+	stop chan struct{}
 }
 
 func NewProcessManager(cfg config.Config, logger *yalog.Logger) (*ProcessManager, error) {
@@ -37,16 +58,21 @@ func NewProcessManager(cfg config.Config, logger *yalog.Logger) (*ProcessManager
 	if err != nil {
 		return nil, err
 	}
-	notif, err := config.LoadOrCreateNotificationsConfig()
-	if err != nil {
+	pm := &ProcessManager{
+		Queries:    db.New(d),
+		Db:         d,
+		Logger:     logger,
+		Config:     &cfg,
+		Events:     NewEventBus(),
+		stop:       make(chan struct{}),
+		outboxWake: make(chan struct{}, 1),
+	}
+	pm.dispatcher = newNotificationDispatcher(*cfg.NotificationDispatch, pm.enqueueNotification)
+	if err = pm.LoadNotificationsConfig(context.Background()); err != nil {
 		return nil, err
 	}
-	pm := &ProcessManager{
-		Queries:       db.New(d),
-		Db:            d,
-		Logger:        logger,
-		Config:        &cfg,
-		Notifications: notif,
+	if err = pm.LoadNotificationRoutes(context.Background()); err != nil {
+		return nil, err
 	}
 	processes, err := pm.Queries.GetProcesses(context.Background())
 	if err != nil {
@@ -57,10 +83,16 @@ func NewProcessManager(cfg config.Config, logger *yalog.Logger) (*ProcessManager
 		go pm.AddRunner(&p).Work()
 
 	}
+	go pm.dispatcher.run(pm.stop)
+	go pm.outboxWorker()
+	go pm.telegramBotWorker()
+	go pm.statsRollupWorker()
+	go pm.hostStatsWorker()
 	return pm, nil
 }
 
 func (pm *ProcessManager) Close() {
+	close(pm.stop)
 	pm.Db.Close()
 }
 
@@ -79,6 +111,7 @@ func (pm *ProcessManager) AddRunner(process *db.Process) *ProcessRunner {
 		pm.runners = make(map[int32]*ProcessRunner)
 	}
 	pm.runners[process.ID] = NewProcessRunner(pm, process)
+	pm.runners[process.ID].publish(BusEvent{Kind: BusEventConfig})
 	return pm.runners[process.ID]
 }
 
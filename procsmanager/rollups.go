@@ -0,0 +1,126 @@
+package procsmanager
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgtype"
+	"procsman_backend/db"
+	"time"
+)
+
+// Resolutions of process_stats_rollup, in seconds. ResolutionRaw means process_stats itself.
+const (
+	ResolutionRaw    int32 = 0
+	ResolutionMinute int32 = 60
+	ResolutionHour   int32 = 60 * 60
+	ResolutionDay    int32 = 24 * 60 * 60
+)
+
+// rollupStep describes how one resolution is computed.
+type rollupStep struct {
+	resolution int32
+	source     int32
+}
+
+var rollupSteps = []rollupStep{
+	{resolution: ResolutionMinute, source: ResolutionRaw},
+	{resolution: ResolutionHour, source: ResolutionMinute},
+	{resolution: ResolutionDay, source: ResolutionHour},
+}
+
+// StatsRetention returns how long stats of the given resolution are kept. 0 means forever.
+func (pm *ProcessManager) StatsRetention(resolution int32) time.Duration {
+	switch resolution {
+	case ResolutionRaw:
+		return pm.Config.StatsRetention.Raw
+	case ResolutionMinute:
+		return pm.Config.StatsRetention.Minute
+	case ResolutionHour:
+		return pm.Config.StatsRetention.Hour
+	default:
+		return pm.Config.StatsRetention.Day
+	}
+}
+
+// statsRollupWorker periodically aggregates process_stats into rollups and removes expired stats.
+// It should be called in a goroutine.
+func (pm *ProcessManager) statsRollupWorker() {
+	logger := pm.Logger.NewLogger("rollups")
+	ticker := time.NewTicker(pm.Config.StatsRollupInterval)
+	defer ticker.Stop()
+
+	// lastBucket is the start of the newest bucket of each resolution, which may still be incomplete.
+	// Everything from it onwards is recomputed on the next run.
+	lastBucket := make(map[int32]time.Time)
+	for _, step := range rollupSteps {
+		last, err := pm.Queries.GetLastRollupBucket(context.Background(), step.resolution)
+		if err != nil {
+			logger.Errorf("Failed to get last rollup bucket: %v\n", err)
+			return
+		}
+		lastBucket[step.resolution] = last.Time
+	}
+
+	for {
+		now := UtcNow()
+		for _, step := range rollupSteps {
+			if err := pm.rollupStats(step, lastBucket[step.resolution], now); err != nil {
+				logger.Errorf("Failed to roll up stats into %ds buckets: %v\n", step.resolution, err)
+				continue
+			}
+			lastBucket[step.resolution] = now.Truncate(time.Duration(step.resolution) * time.Second)
+		}
+
+		if err := pm.deleteExpiredStats(now); err != nil {
+			logger.Errorf("Failed to delete expired stats: %v\n", err)
+		}
+
+		select {
+		case <-pm.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pm *ProcessManager) rollupStats(step rollupStep, from, to time.Time) error {
+	rangeFrom := pgtype.Timestamp{Time: from, Valid: true}
+	rangeTo := pgtype.Timestamp{Time: to, Valid: true}
+	if step.source == ResolutionRaw {
+		return pm.Queries.RollupRawStats(context.Background(), db.RollupRawStatsParams{
+			Resolution: step.resolution,
+			RangeFrom:  rangeFrom,
+			RangeTo:    rangeTo,
+		})
+	}
+	return pm.Queries.RollupStats(context.Background(), db.RollupStatsParams{
+		Resolution:       step.resolution,
+		SourceResolution: step.source,
+		RangeFrom:        rangeFrom,
+		RangeTo:          rangeTo,
+	})
+}
+
+func (pm *ProcessManager) deleteExpiredStats(now time.Time) error {
+	// host_stats are not rolled up, they share the retention of raw process_stats.
+	if retention := pm.StatsRetention(ResolutionRaw); retention != 0 {
+		if _, err := pm.Queries.DeleteProcessStatsBefore(context.Background(), pgtype.Timestamp{Time: now.Add(-retention), Valid: true}); err != nil {
+			return err
+		}
+		if _, err := pm.Queries.DeleteHostStatsBefore(context.Background(), pgtype.Timestamp{Time: now.Add(-retention), Valid: true}); err != nil {
+			return err
+		}
+	}
+	for _, step := range rollupSteps {
+		retention := pm.StatsRetention(step.resolution)
+		if retention == 0 {
+			continue
+		}
+		if _, err := pm.Queries.DeleteProcessStatsRollupBefore(context.Background(), db.DeleteProcessStatsRollupBeforeParams{
+			Resolution: step.resolution,
+			Bucket:     pgtype.Timestamp{Time: now.Add(-retention), Valid: true},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
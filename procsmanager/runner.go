@@ -2,19 +2,23 @@ package procsmanager
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/apepenkov/yalog"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"procsman_backend/config"
 	"procsman_backend/db"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -327,12 +331,14 @@ type Signal int
 // Restart 	stops the process, 	close the procLog file and start the process again. Status will be set to	STOPPING -> STOPPED -> STARTING -> RUNNING.
 // Deleted 	stops the process, 	delete all logs. Process is expected to be deleted from the database by the caller.
 // Refresh  re-fetches process configuration from the database and updates the process runner.
+// Reload 	re-fetches process configuration from the database without restarting the process, for settings that don't affect it.
 const (
 	Stop Signal = iota
 	Start
 	Restart
 	Deleted
 	Refresh
+	Reload
 )
 
 func (s Signal) String() string {
@@ -347,6 +353,8 @@ func (s Signal) String() string {
 		return "Deleted"
 	case Refresh:
 		return "Refresh"
+	case Reload:
+		return "Reload"
 	default:
 		return fmt.Sprintf("Unknown Signal (%d)", s)
 	}
@@ -363,23 +371,39 @@ type ProcessRunner struct {
 	Process *db.Process
 	Logger  *yalog.Logger
 
-	SignalIn chan Signal
+	SignalIn chan SignalRequest
 
 	StdIn chan string
 
 	status  db.ProcessStatus
 	procLog *ProcessLogger
+	// recentOutput keeps the last lines of stdout and stderr for notifications, even if logs are not stored.
+	recentOutput *outputRing
+	// restartAttempts counts the automatic restarts since the process was last started or restarted by a user.
+	restartAttempts atomic.Int32
 
 	// stoppedByUser is set when a stop signal is received.
 	// it will be set to false after .Wait()
 	stoppedByUser bool
+
+	metrics   RunnerMetrics
+	metricsMu sync.Mutex
+
+	// thresholdStates is only used by the Work goroutine.
+	thresholdStates map[db.ThresholdRule]*thresholdState
+}
+
+// SignalRequest is a signal for the runner. Events caused by it reference AuditID, if it's valid.
+type SignalRequest struct {
+	Signal  Signal
+	AuditID pgtype.Int4
 }
 
 func NewProcessRunner(manager *ProcessManager, process *db.Process) *ProcessRunner {
 	runner := &ProcessRunner{
 		Manager:  manager,
 		Process:  process,
-		SignalIn: make(chan Signal, 2),
+		SignalIn: make(chan SignalRequest, 2),
 		StdIn:    make(chan string, 2),
 		status:   process.Status,
 		Logger:   manager.Logger.NewLogger(fmt.Sprintf("pr-%d", process.ID)),
@@ -387,6 +411,7 @@ func NewProcessRunner(manager *ProcessManager, process *db.Process) *ProcessRunn
 	runner.procLog = &ProcessLogger{
 		Process: runner,
 	}
+	runner.recentOutput = newOutputRing(outputRingSize(&process.Configuration))
 	return runner
 }
 
@@ -402,51 +427,126 @@ func (pr *ProcessRunner) SetStatus(status db.ProcessStatus) error {
 	}
 	pr.Process.Status = status
 	pr.status = status
+	pr.publish(BusEvent{Kind: BusEventStatus, Status: status})
 	return nil
 }
 
+// Status returns the last status set by SetStatus.
+func (pr *ProcessRunner) Status() db.ProcessStatus {
+	return pr.status
+}
+
+// CurrentLogID returns the id of the log file the process is currently writing to.
+// It is not valid if the process doesn't store logs, or the file is not open.
+func (pr *ProcessRunner) CurrentLogID() pgtype.Int4 {
+	pr.procLog.mu.Lock()
+	defer pr.procLog.mu.Unlock()
+	if pr.procLog.CurrentLog == nil || pr.procLog.FileWriter == nil {
+		return pgtype.Int4{}
+	}
+	return pgtype.Int4{Int32: pr.procLog.CurrentLog.ID, Valid: true}
+}
+
 func (pr *ProcessRunner) GetCmd() string {
 	return pr.Process.ExecutablePath + " " + pr.Process.Arguments
 }
 
-func (pr *ProcessRunner) LogEvent(eventType db.ProcessEventType, extra []byte) error {
-	notify := func(text string) {
-		res := pr.Manager.Notifications.SendMessage(text)
-		for _, r := range res {
-			if r.Success {
-				continue
-			}
-			pr.Logger.Warningf("Failed to send notification: %v\n", r.Error)
+// NewNotification returns a notification for an event of the process, with the templates of its group and its own.
+// text is only used for events whose message is composed by procsman, the others are rendered from templates.
+func (pr *ProcessRunner) NewNotification(eventType db.ProcessEventType, extra []byte, text string) *config.Notification {
+	n := &config.Notification{
+		ProcessID:      pr.Process.ID,
+		ProcessName:    pr.Process.Name,
+		Event:          string(eventType),
+		AdditionalInfo: extra,
+		Time:           UtcNow().Unix(),
+		Text:           text,
+		RestartCount:   pr.Metrics().Restarts,
+		Templates:      make(map[string]string),
+	}
+	if pr.Process.Configuration.GetCrashOutputLines() > 0 {
+		n.RecentLogs = pr.recentOutput.Lines()
+	}
+	n.Hostname, _ = os.Hostname()
+	if pr.Process.ProcessGroupID.Valid {
+		groupID := pr.Process.ProcessGroupID.Int32
+		n.GroupID = &groupID
+		if group, err := pr.Manager.Queries.GetProcessGroup(context.Background(), groupID); err == nil {
+			n.GroupName = group.Name
+			maps.Copy(n.Templates, group.ScriptsConfiguration.NotificationTemplates)
 		}
 	}
+	maps.Copy(n.Templates, pr.Process.Configuration.NotificationTemplates)
+	if eventType == db.ProcessEventTypeCRASH || eventType == db.ProcessEventTypeFULLCRASH {
+		if lines := pr.Process.Configuration.GetCrashOutputLines(); lines > 0 && len(n.RecentLogs) > 0 {
+			n.Output = n.RecentLogs[len(n.RecentLogs)-min(lines, len(n.RecentLogs)):]
+		}
+	}
+	if len(n.RecentLogs) > recentOutputLines {
+		n.RecentLogs = n.RecentLogs[len(n.RecentLogs)-recentOutputLines:]
+	}
+	return n
+}
+
+// notify routes an event of the process and hands it to the dispatcher.
+func (pr *ProcessRunner) notify(eventType db.ProcessEventType, extra []byte, text string) {
+	n := pr.NewNotification(eventType, extra, text)
+	pr.Manager.RouteNotification(n)
+	pr.Manager.dispatcher.Dispatch(n)
+}
+
+// reloadProcess re-fetches the process from the database.
+func (pr *ProcessRunner) reloadProcess() error {
+	proc, err := pr.Manager.Queries.GetProcess(context.Background(), pr.Process.ID)
+	if err != nil {
+		return err
+	}
+	pr.Process = &proc
+	pr.recentOutput.Resize(outputRingSize(&proc.Configuration))
+	pr.publish(BusEvent{Kind: BusEventConfig})
+	return nil
+}
+
+// SendSignal sends a signal to the runner. Events caused by the signal reference auditID, if it's valid.
+func (pr *ProcessRunner) SendSignal(signal Signal, auditID pgtype.Int4) {
+	pr.SignalIn <- SignalRequest{Signal: signal, AuditID: auditID}
+}
+
+func (pr *ProcessRunner) LogEvent(eventType db.ProcessEventType, extra []byte) error {
+	return pr.LogAuditedEvent(eventType, extra, pgtype.Int4{})
+}
+
+// LogAuditedEvent is LogEvent for events caused by an API call, auditID is its audit_log entry.
+func (pr *ProcessRunner) LogAuditedEvent(eventType db.ProcessEventType, extra []byte, auditID pgtype.Int4) error {
+	notify := pr.notify
 	switch eventType {
 	case db.ProcessEventTypeSTART:
 		if pr.Process.Configuration.GetNotifyOnStart() {
-			go notify(fmt.Sprintf("Process %s has started", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeSTOP:
 		if pr.Process.Configuration.GetNotifyOnStop() {
-			go notify(fmt.Sprintf("Process %s has stopped", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeCRASH:
 		if pr.Process.Configuration.GetNotifyOnCrash() {
-			go notify(fmt.Sprintf("Process %s has crashed", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeFULLSTOP:
 		if pr.Process.Configuration.GetNotifyOnStop() {
-			go notify(fmt.Sprintf("Process %s has fully stopped", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeFULLCRASH:
 		if pr.Process.Configuration.GetNotifyOnCrash() {
-			go notify(fmt.Sprintf("Process %s has fully crashed", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeMANUALLYSTOPPED:
 		if pr.Process.Configuration.GetNotifyOnStop() {
-			go notify(fmt.Sprintf("Process %s has been manually stopped", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 	case db.ProcessEventTypeRESTART:
 		if pr.Process.Configuration.GetNotifyOnRestart() {
-			go notify(fmt.Sprintf("Process %s has been restarted", pr.Process.Name))
+			go notify(eventType, extra, "")
 		}
 
 	}
@@ -454,19 +554,45 @@ func (pr *ProcessRunner) LogEvent(eventType db.ProcessEventType, extra []byte) e
 		ProcessID:      pgtype.Int4{Int32: pr.Process.ID, Valid: true},
 		Event:          eventType,
 		AdditionalInfo: extra,
+		AuditID:        auditID,
 	})
 	if err != nil {
 		pr.Logger.Errorf("Failed to procLog event %v: %v\n", eventType, err)
 	}
+	pr.publish(BusEvent{Kind: BusEventEvent, Event: eventType, AdditionalInfo: extra})
 	return err
 }
 
+// ExitEventInfo is the additional info of STOP, FULLSTOP, CRASH and FULLCRASH events.
+type ExitEventInfo struct {
+	// ExitCode is -1 if the process was killed by a signal.
+	ExitCode int `json:"exit_code"`
+	// Signal is the signal that killed the process, like SIGSEGV. It's never set on windows.
+	Signal string `json:"signal,omitempty"`
+	// Uptime is how long the process ran, in seconds.
+	Uptime int64 `json:"uptime"`
+	// RestartAttempt counts the automatic restarts in a row, including the one the exit leads to.
+	// For FULL_STOP and FULL_CRASH it's the number of restarts before procsman gave up.
+	RestartAttempt int32 `json:"restart_attempt,omitempty"`
+}
+
 type UsageInfo struct {
 	// TotalCpuUsage is a total CPU usage by the process.
 	// MemUsage is a total memory usage in B.
 	TotalCpuUsage time.Duration
 	MemUsage      int64
 	When          time.Time
+
+	// Metrics are the metric groups that were collected. The fields below are only set if their group was collected.
+	// Threads, OpenFds and Children are current values, the rest are counters summed over the whole process tree.
+	Metrics                config.MetricsConfig
+	Threads                int64
+	OpenFds                int64
+	IoReadBytes            int64
+	IoWriteBytes           int64
+	CtxSwitchesVoluntary   int64
+	CtxSwitchesInvoluntary int64
+	Children               int64
 }
 
 // SubProcess is a wrapper for exec.Cmd and its stdin.
@@ -479,42 +605,57 @@ type SubProcess struct {
 
 //goland:noinspection GoSnakeCaseUsage
 type Win32_Process struct {
-	Name            string
-	ProcessID       uint32
-	ParentProcessId uint32
-	UserModeTime    uint64
-	KernelModeTime  uint64
-	PageFileUsage   uint32
+	Name               string
+	ProcessID          uint32
+	ParentProcessId    uint32
+	UserModeTime       uint64
+	KernelModeTime     uint64
+	PageFileUsage      uint32
+	ThreadCount        uint32
+	HandleCount        uint32
+	ReadTransferCount  uint64
+	WriteTransferCount uint64
 }
 
-func (s *SubProcess) getUsageInfo() (*UsageInfo, error) {
+func (s *SubProcess) getUsageInfo(metrics config.MetricsConfig) (*UsageInfo, error) {
 	if s.Cmd.ProcessState != nil && s.Cmd.ProcessState.Exited() || s.Cmd.Process == nil || s.Cmd.Process.Pid == 0 {
 		return nil, errors.New("process has exited")
 	}
-	return s.getUsageInfoInner()
+	return s.getUsageInfoInner(metrics)
 }
 
+// UsageRecord is the usage over the last interval.
+// IoReadBytes, IoWriteBytes and context switches are deltas over the interval, like CpuUsage.
 type UsageRecord struct {
 	CpuUsage        time.Duration
 	CpuUsagePercent float64
 	MemUsage        int64
 	When            time.Time
 	Delta           time.Duration
+
+	Metrics                config.MetricsConfig
+	Threads                int64
+	OpenFds                int64
+	IoReadBytes            int64
+	IoWriteBytes           int64
+	CtxSwitchesVoluntary   int64
+	CtxSwitchesInvoluntary int64
+	Children               int64
 }
 
 // getUsageRecording saves current usage info in database.
 // due to the CPU info being recorded by delta, we can't record the first usage info.
-func (s *SubProcess) getUsageRecording() (*UsageRecord, error) {
+func (s *SubProcess) getUsageRecording(metrics config.MetricsConfig) (*UsageRecord, error) {
 	var err error
 	if s.LastUsage == nil {
-		s.LastUsage, err = s.getUsageInfo()
+		s.LastUsage, err = s.getUsageInfo(metrics)
 		if err != nil {
 			return nil, err
 		}
 		return nil, nil
 	}
 
-	newUsage, err := s.getUsageInfo()
+	newUsage, err := s.getUsageInfo(metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -524,15 +665,30 @@ func (s *SubProcess) getUsageRecording() (*UsageRecord, error) {
 
 	totalCpuNano := time.Duration(runtime.NumCPU()) * timeDelta
 
+	// counters of children that exited during the interval are gone from the sum, so the delta can't go below zero.
+	counterDelta := func(newValue, oldValue int64) int64 {
+		return max(newValue-oldValue, 0)
+	}
+
+	record := &UsageRecord{
+		CpuUsage:               cpuUsageDelta,
+		CpuUsagePercent:        (float64(cpuUsageDelta) / float64(totalCpuNano)) * 100.0,
+		MemUsage:               newUsage.MemUsage,
+		When:                   newUsage.When,
+		Delta:                  timeDelta,
+		Metrics:                newUsage.Metrics,
+		Threads:                newUsage.Threads,
+		OpenFds:                newUsage.OpenFds,
+		IoReadBytes:            counterDelta(newUsage.IoReadBytes, s.LastUsage.IoReadBytes),
+		IoWriteBytes:           counterDelta(newUsage.IoWriteBytes, s.LastUsage.IoWriteBytes),
+		CtxSwitchesVoluntary:   counterDelta(newUsage.CtxSwitchesVoluntary, s.LastUsage.CtxSwitchesVoluntary),
+		CtxSwitchesInvoluntary: counterDelta(newUsage.CtxSwitchesInvoluntary, s.LastUsage.CtxSwitchesInvoluntary),
+		Children:               newUsage.Children,
+	}
+
 	s.LastUsage = newUsage
 
-	return &UsageRecord{
-		CpuUsage:        cpuUsageDelta,
-		CpuUsagePercent: (float64(cpuUsageDelta) / float64(totalCpuNano)) * 100.0,
-		MemUsage:        newUsage.MemUsage,
-		When:            newUsage.When,
-		Delta:           timeDelta,
-	}, nil
+	return record, nil
 }
 
 func (s *SubProcess) Cleanup() {
@@ -616,13 +772,15 @@ func (pr *ProcessRunner) NewSubProcess(program string, argsStr string, envVars m
 	// Unfortunately, when using StdoutPipe and StderrPipe, we can't force pipes to flush, so we can't get the output in real time.
 	// So we have to manage them ourselves (including closing them).
 
+	// the same writer is used for both, so exec doesn't create a second pipe.
+	var output io.Writer
 	if !pr.Process.Configuration.GetStoreLogs() {
-		cmd.Stdout = dummyWriter
-		cmd.Stderr = dummyWriter
+		output = io.MultiWriter(pr.recentOutput, dummyWriter)
 	} else {
-		cmd.Stdout = pr.procLog
-		cmd.Stderr = pr.procLog
+		output = io.MultiWriter(pr.recentOutput, pr.procLog)
 	}
+	cmd.Stdout = output
+	cmd.Stderr = output
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -677,7 +835,7 @@ func (pr *ProcessRunner) RecordUsage(subprocess *SubProcess) (bool, error) {
 		}
 	}
 
-	record, err := subprocess.getUsageRecording()
+	record, err := subprocess.getUsageRecording(*pr.Manager.Config.Metrics)
 	if err != nil {
 		return false, err
 	}
@@ -686,15 +844,46 @@ func (pr *ProcessRunner) RecordUsage(subprocess *SubProcess) (bool, error) {
 	}
 
 	roundedToThreeDecimals := float64(int(record.CpuUsagePercent*1000)) / 1000
+	pr.updateMetrics(func(m *RunnerMetrics) {
+		m.CpuUsagePercent = roundedToThreeDecimals
+		m.MemUsage = record.MemUsage
+	})
+	pr.evaluateThresholds(roundedToThreeDecimals, record.MemUsage, record.When)
+
+	busStats := &StatsSample{CpuUsagePercent: roundedToThreeDecimals, MemUsage: record.MemUsage}
+	if record.Metrics.Threads {
+		busStats.Threads = &record.Threads
+	}
+	if record.Metrics.FileDescriptors {
+		busStats.OpenFds = &record.OpenFds
+	}
+	if record.Metrics.Children {
+		busStats.Children = &record.Children
+	}
+	pr.publish(BusEvent{Kind: BusEventStats, Time: record.When.Unix(), Stats: busStats})
+
+	int4If := func(collected bool, v int64) pgtype.Int4 {
+		return pgtype.Int4{Int32: int32(v), Valid: collected}
+	}
+	int8If := func(collected bool, v int64) pgtype.Int8 {
+		return pgtype.Int8{Int64: v, Valid: collected}
+	}
 
 	_, err = pr.Manager.Queries.InsertProcessStats(context.Background(), db.InsertProcessStatsParams{
 		ProcessID: pgtype.Int4{
 			Int32: pr.Process.ID,
 			Valid: true,
 		},
-		CpuUsage:           record.CpuUsage.Nanoseconds(),
-		CpuUsagePercentage: roundedToThreeDecimals,
-		MemoryUsage:        record.MemUsage,
+		CpuUsage:               record.CpuUsage.Nanoseconds(),
+		CpuUsagePercentage:     roundedToThreeDecimals,
+		MemoryUsage:            record.MemUsage,
+		ThreadCount:            int4If(record.Metrics.Threads, record.Threads),
+		OpenFds:                int4If(record.Metrics.FileDescriptors, record.OpenFds),
+		IoReadBytes:            int8If(record.Metrics.Io, record.IoReadBytes),
+		IoWriteBytes:           int8If(record.Metrics.Io, record.IoWriteBytes),
+		CtxSwitchesVoluntary:   int8If(record.Metrics.ContextSwitches, record.CtxSwitchesVoluntary),
+		CtxSwitchesInvoluntary: int8If(record.Metrics.ContextSwitches, record.CtxSwitchesInvoluntary),
+		ChildCount:             int4If(record.Metrics.Children, record.Children),
 	})
 
 	return err == nil, err
@@ -725,7 +914,7 @@ func (pr *ProcessRunner) Work() {
 	}
 
 	if pr.Process.Enabled {
-		pr.SignalIn <- Start
+		pr.SignalIn <- SignalRequest{Signal: Start}
 	}
 
 	_ = pr.SetStatus(db.ProcessStatusUNKNOWN)
@@ -733,11 +922,13 @@ func (pr *ProcessRunner) Work() {
 
 	for {
 		select {
-		case signal := <-pr.SignalIn:
+		case request := <-pr.SignalIn:
+			signal := request.Signal
 			pr.Logger.Debugf("Received signal: %s\n", signal)
 			switch signal {
 			case Start:
 				if pr.status != db.ProcessStatusRUNNING && pr.status != db.ProcessStatusSTARTING {
+					pr.restartAttempts.Store(0)
 					_ = pr.SetStatus(db.ProcessStatusSTARTING)
 					stopIfExists()
 					subprocess, processErr = pr.startProcess(true)
@@ -761,19 +952,27 @@ func (pr *ProcessRunner) Work() {
 				}
 
 				if signal == Deleted {
+					pr.publish(BusEvent{Kind: BusEventConfig, Deleted: true})
 					if err := pr.procLog.FinishLogOnDelete(); err != nil {
 						pr.Logger.Errorf("Error finishing procLog: %v\n", err)
 					}
 					_ = os.RemoveAll(filepath.Join(pr.Manager.Config.LogsFolder, fmt.Sprintf("%d", pr.Process.ID)))
 					return
 				} else {
-					_ = pr.LogEvent(db.ProcessEventTypeMANUALLYSTOPPED, nil)
+					_ = pr.LogAuditedEvent(db.ProcessEventTypeMANUALLYSTOPPED, nil, request.AuditID)
 					_ = pr.SetStatus(db.ProcessStatusSTOPPED)
 				}
 
 			case Restart:
+				if pr.status != db.ProcessStatusSTOPPEDWILLRESTART && pr.status != db.ProcessStatusCRASHEDWILLRESTART {
+					// restarted by a user or a config change, not because the process exited.
+					pr.restartAttempts.Store(0)
+				}
 				_ = pr.SetStatus(db.ProcessStatusSTOPPING)
-				_ = pr.LogEvent(db.ProcessEventTypeRESTART, nil)
+				_ = pr.LogAuditedEvent(db.ProcessEventTypeRESTART, nil, request.AuditID)
+				pr.updateMetrics(func(m *RunnerMetrics) {
+					m.Restarts++
+				})
 				pr.stoppedByUser = true
 				stopIfExists()
 				sleepFor := pr.Process.Configuration.GetAutoRestartDelay()
@@ -792,17 +991,22 @@ func (pr *ProcessRunner) Work() {
 
 			case Refresh:
 				pr.stoppedByUser = true
-				proc, err := pr.Manager.Queries.GetProcess(context.Background(), pr.Process.ID)
+				err := pr.reloadProcess()
 				if err != nil {
 					pr.Logger.Errorf("Failed to refresh process: %v\n", err)
 					return
 				}
-				pr.Process = &proc
 				if pr.Process.Enabled {
 					if err = pr.procLog.cycle(); err != nil {
 						pr.Logger.Errorf("Error cycling procLog: %v\n", err)
 					}
-					pr.SignalIn <- Restart
+					// the restart is caused by the same API call as the refresh.
+					pr.SendSignal(Restart, request.AuditID)
+				}
+
+			case Reload:
+				if err := pr.reloadProcess(); err != nil {
+					pr.Logger.Errorf("Failed to reload process: %v\n", err)
 				}
 			}
 
@@ -810,7 +1014,7 @@ func (pr *ProcessRunner) Work() {
 		default:
 			if pr.status == db.ProcessStatusSTOPPEDWILLRESTART || pr.status == db.ProcessStatusCRASHEDWILLRESTART {
 				pr.Logger.Infof("Restarting process based on configuration\n")
-				pr.SignalIn <- Restart
+				pr.SignalIn <- SignalRequest{Signal: Restart}
 			}
 
 			// Implement procLog cycling and flushing based on conditions
@@ -845,6 +1049,12 @@ func (pr *ProcessRunner) startProcess(reportStart bool) (*SubProcess, error) {
 		return nil, err
 	}
 
+	pr.updateMetrics(func(m *RunnerMetrics) {
+		m.Pid = subprocess.Cmd.Process.Pid
+		m.StartedAt = UtcNow()
+		m.Starts++
+	})
+
 	// Start goroutines to handle subprocess stdout and stderr
 	go pr.handleStdIn(subprocess.Stdin)
 
@@ -867,6 +1077,12 @@ func (pr *ProcessRunner) handleStdIn(stdin io.WriteCloser) {
 
 func (pr *ProcessRunner) waitForProcessExit(subprocess *SubProcess) {
 	err := subprocess.Cmd.Wait()
+	uptime := UtcNow().Sub(pr.Metrics().StartedAt)
+	pr.updateMetrics(func(m *RunnerMetrics) {
+		m.Pid = 0
+		m.CpuUsagePercent = 0
+		m.MemUsage = 0
+	})
 
 	if pr.status == db.ProcessStatusSTOPPING {
 		pr.Logger.Debugln("Process is in stopping state, not doing anything in waitForProcessExit")
@@ -876,6 +1092,21 @@ func (pr *ProcessRunner) waitForProcessExit(subprocess *SubProcess) {
 	wasStoppedByUser := pr.stoppedByUser
 	pr.stoppedByUser = false
 
+	exitInfo := ExitEventInfo{ExitCode: -1, Uptime: int64(uptime.Round(time.Second).Seconds())}
+	if state := subprocess.Cmd.ProcessState; state != nil {
+		exitInfo.ExitCode = state.ExitCode()
+		exitInfo.Signal = exitSignal(state)
+	}
+	logExit := func(eventType db.ProcessEventType, restarting bool) {
+		if restarting {
+			exitInfo.RestartAttempt = pr.restartAttempts.Add(1)
+		} else {
+			exitInfo.RestartAttempt = pr.restartAttempts.Swap(0)
+		}
+		extra, _ := json.Marshal(exitInfo)
+		_ = pr.LogEvent(eventType, extra)
+	}
+
 	// Ensure thread-safe access to pr.status
 	pr.Manager.Logger.Debugln("Process exited, checking status and deciding on auto-restart...")
 	pr.procLog.flush()
@@ -884,18 +1115,21 @@ func (pr *ProcessRunner) waitForProcessExit(subprocess *SubProcess) {
 		if isStop {
 			if tryRestart && pr.Process.Configuration.GetAutoRestartOnStop() && pr.StopRestartFrameSatisfied() {
 				_ = pr.SetStatus(db.ProcessStatusSTOPPEDWILLRESTART)
-				_ = pr.LogEvent(db.ProcessEventTypeSTOP, nil)
+				logExit(db.ProcessEventTypeSTOP, true)
 			} else {
 				_ = pr.SetStatus(db.ProcessStatusSTOPPED)
-				_ = pr.LogEvent(db.ProcessEventTypeFULLSTOP, nil)
+				logExit(db.ProcessEventTypeFULLSTOP, false)
 			}
 		} else {
+			pr.updateMetrics(func(m *RunnerMetrics) {
+				m.Crashes++
+			})
 			if tryRestart && pr.Process.Configuration.GetAutoRestartOnCrash() && pr.StopRestartFrameSatisfied() {
 				_ = pr.SetStatus(db.ProcessStatusCRASHEDWILLRESTART)
-				_ = pr.LogEvent(db.ProcessEventTypeCRASH, nil)
+				logExit(db.ProcessEventTypeCRASH, true)
 			} else {
 				_ = pr.SetStatus(db.ProcessStatusCRASHED)
-				_ = pr.LogEvent(db.ProcessEventTypeFULLCRASH, nil)
+				logExit(db.ProcessEventTypeFULLCRASH, false)
 			}
 		}
 	}
@@ -6,8 +6,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
+	"procsman_backend/config"
 	"strconv"
 	"strings"
 	"syscall"
@@ -58,7 +60,14 @@ func killProcessTree(pid int) error {
 	return syscall.Kill(pid, syscall.SIGKILL)
 }
 
-func getUsageInfoUnixRecursive(current *UsageInfo, pid int) error {
+// skippableProcErr reports whether reading /proc failed because the process exited or its files can't be read.
+func skippableProcErr(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH) || errors.Is(err, fs.ErrPermission)
+}
+
+// getUsageInfoUnixRecursive adds the usage of pid and its children to current.
+// Children that exit in the meantime, or whose files can't be read, are left out instead of failing the sample.
+func getUsageInfoUnixRecursive(current *UsageInfo, pid int, child bool) error {
 	if current == nil {
 		return errors.New("current is nil")
 	}
@@ -68,14 +77,20 @@ func getUsageInfoUnixRecursive(current *UsageInfo, pid int) error {
 	}
 
 	for _, cp := range childPids {
-		err = getUsageInfoUnixRecursive(current, cp)
+		err = getUsageInfoUnixRecursive(current, cp, true)
 		if err != nil {
 			return err
 		}
 	}
+	if current.Metrics.Children {
+		current.Children += int64(len(childPids))
+	}
 
 	statBytes, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
 	if err != nil {
+		if child && skippableProcErr(err) {
+			return nil
+		}
 		return err
 	}
 	fields := strings.Fields(string(statBytes))
@@ -96,6 +111,9 @@ func getUsageInfoUnixRecursive(current *UsageInfo, pid int) error {
 	// Calculate memory usage from /proc/[pid]/statm
 	memBytes, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
 	if err != nil {
+		if child && skippableProcErr(err) {
+			return nil
+		}
 		return err
 	}
 	memPages, err := strconv.ParseInt(strings.Fields(string(memBytes))[1], 10, 64)
@@ -112,14 +130,143 @@ func getUsageInfoUnixRecursive(current *UsageInfo, pid int) error {
 	current.TotalCpuUsage += totalCpuTime
 	current.MemUsage += memPages * int64(os.Getpagesize())
 
+	if current.Metrics.Threads {
+		// num_threads is the 20th field of /proc/[pid]/stat
+		threads, err := strconv.ParseInt(fields[19], 10, 64)
+		if err != nil {
+			return err
+		}
+		current.Threads += threads
+	}
+
+	if current.Metrics.FileDescriptors {
+		fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+		if err == nil {
+			current.OpenFds += int64(len(fds))
+		} else if !child || !skippableProcErr(err) {
+			return err
+		}
+	}
+
+	if current.Metrics.Io {
+		ioStats, err := readProcKeyValues(fmt.Sprintf("/proc/%d/io", pid))
+		if err == nil {
+			current.IoReadBytes += ioStats["read_bytes"]
+			current.IoWriteBytes += ioStats["write_bytes"]
+		} else if !child || !skippableProcErr(err) {
+			return err
+		}
+	}
+
+	if current.Metrics.ContextSwitches {
+		status, err := readProcKeyValues(fmt.Sprintf("/proc/%d/status", pid))
+		if err == nil {
+			current.CtxSwitchesVoluntary += status["voluntary_ctxt_switches"]
+			current.CtxSwitchesInvoluntary += status["nonvoluntary_ctxt_switches"]
+		} else if !child || !skippableProcErr(err) {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (s *SubProcess) getUsageInfoInner() (*UsageInfo, error) {
+// readProcKeyValues reads "key: value" files like /proc/[pid]/io, /proc/[pid]/status and /proc/meminfo.
+// Only integer values are returned. Values in kB are returned without converting them.
+func readProcKeyValues(path string) (map[string]int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(b), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		v, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSpace(key)] = v
+	}
+	return values, nil
+}
+
+func (s *SubProcess) getUsageInfoInner(metrics config.MetricsConfig) (*UsageInfo, error) {
 	usageInfo := &UsageInfo{
+		When:    UtcNow(),
+		Metrics: metrics,
+	}
+	return usageInfo, getUsageInfoUnixRecursive(usageInfo, s.Cmd.Process.Pid, false)
+}
+
+func getHostUsageInfo(diskPath string) (*HostUsageInfo, error) {
+	usage := &HostUsageInfo{
 		When: UtcNow(),
 	}
-	return usageInfo, getUsageInfoUnixRecursive(usageInfo, s.Cmd.Process.Pid)
+
+	// the first line of /proc/stat is "cpu user nice system idle iowait irq softirq steal ..."
+	statBytes, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	cpuLine, _, _ := strings.Cut(string(statBytes), "\n")
+	cpuFields := strings.Fields(cpuLine)
+	if len(cpuFields) < 5 || cpuFields[0] != "cpu" {
+		return nil, errors.New("unexpected format of /proc/stat")
+	}
+	for i, field := range cpuFields[1:] {
+		ticks, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		// guest and guest_nice are already included in user and nice
+		if i >= 8 {
+			break
+		}
+		usage.CpuTotal += ticks
+		// idle and iowait
+		if i != 3 && i != 4 {
+			usage.CpuBusy += ticks
+		}
+	}
+
+	// values of /proc/meminfo are in kB
+	memInfo, err := readProcKeyValues("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	usage.MemoryTotal = memInfo["MemTotal"] * 1024
+	usage.MemoryUsed = usage.MemoryTotal - memInfo["MemAvailable"]*1024
+	usage.SwapTotal = memInfo["SwapTotal"] * 1024
+	usage.SwapUsed = usage.SwapTotal - memInfo["SwapFree"]*1024
+
+	loadBytes, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return nil, err
+	}
+	loadFields := strings.Fields(string(loadBytes))
+	if len(loadFields) < 3 {
+		return nil, errors.New("unexpected format of /proc/loadavg")
+	}
+	loads := make([]float64, 3)
+	for i := range loads {
+		loads[i], err = strconv.ParseFloat(loadFields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	usage.Load1, usage.Load5, usage.Load15 = loads[0], loads[1], loads[2]
+
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(diskPath, &fs); err != nil {
+		return nil, err
+	}
+	usage.DiskTotal = int64(fs.Blocks) * int64(fs.Bsize)
+	usage.DiskUsed = usage.DiskTotal - int64(fs.Bfree)*int64(fs.Bsize)
+
+	return usage, nil
 }
 
 func init() {
@@ -140,3 +287,32 @@ func init() {
 		panic(err)
 	}
 }
+
+// signalNames are the names of the signals that usually end a process.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// exitSignal returns the signal that killed the process, like SIGSEGV, or "" if it exited by itself.
+func exitSignal(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	if name, ok := signalNames[status.Signal()]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(status.Signal()))
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package procsmanager
+
+import (
+	"os"
+	"os/exec"
+	"procsman_backend/config"
+	"testing"
+)
+
+func TestUsageInfoSkipsExitedChildren(t *testing.T) {
+	metrics := config.MetricsConfig{Threads: true, FileDescriptors: true, Io: true, ContextSwitches: true, Children: true}
+
+	// a process that has exited is left out if it's a child, but fails the sample otherwise.
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	exited := cmd.Process.Pid
+	if err := getUsageInfoUnixRecursive(&UsageInfo{Metrics: metrics}, exited, true); err != nil {
+		t.Fatalf("exited child: %v", err)
+	}
+	if err := getUsageInfoUnixRecursive(&UsageInfo{Metrics: metrics}, exited, false); err == nil {
+		t.Fatal("exited process didn't fail the sample")
+	}
+
+	usage := &UsageInfo{Metrics: metrics}
+	if err := getUsageInfoUnixRecursive(usage, os.Getpid(), false); err != nil {
+		t.Fatal(err)
+	}
+	if usage.MemUsage == 0 || usage.Threads == 0 || usage.OpenFds == 0 {
+		t.Fatalf("usage of the running process is missing: %+v", usage)
+	}
+}
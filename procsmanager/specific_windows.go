@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/StackExchange/wmi"
+	"os"
 	"os/exec"
+	"procsman_backend/config"
 	"strconv"
 	"time"
 )
@@ -16,12 +18,16 @@ func killProcessTree(pid int) error {
 	return cmd.Run()
 }
 
+func getHostUsageInfo(diskPath string) (*HostUsageInfo, error) {
+	return nil, errHostStatsUnsupported
+}
+
 //goland:noinspection SqlResolve,SqlDialectInspection,SqlType
-func (s *SubProcess) getUsageInfoInner() (*UsageInfo, error) {
+func (s *SubProcess) getUsageInfoInner(metrics config.MetricsConfig) (*UsageInfo, error) {
 	var rootProcesses []Win32_Process
 
 	// Fetch root process
-	query := fmt.Sprintf("SELECT Name, ProcessID, ParentProcessId, UserModeTime, KernelModeTime, PageFileUsage FROM Win32_Process WHERE ProcessID "+"= %d", s.Cmd.Process.Pid)
+	query := fmt.Sprintf("SELECT Name, ProcessID, ParentProcessId, UserModeTime, KernelModeTime, PageFileUsage, ThreadCount, HandleCount, ReadTransferCount, WriteTransferCount FROM Win32_Process WHERE ProcessID "+"= %d", s.Cmd.Process.Pid)
 	err := wmi.Query(query, &rootProcesses)
 	if err != nil {
 		return nil, err
@@ -41,7 +47,7 @@ func (s *SubProcess) getUsageInfoInner() (*UsageInfo, error) {
 
 	fetchChildProcesses = func(parentPID uint32) error {
 		var childProcesses []Win32_Process
-		childQuery := fmt.Sprintf("SELECT Name, ProcessID, ParentProcessId, UserModeTime, KernelModeTime, PageFileUsage FROM Win32_Process WHERE ParentProcessId "+"= %d", parentPID)
+		childQuery := fmt.Sprintf("SELECT Name, ProcessID, ParentProcessId, UserModeTime, KernelModeTime, PageFileUsage, ThreadCount, HandleCount, ReadTransferCount, WriteTransferCount FROM Win32_Process WHERE ParentProcessId "+"= %d", parentPID)
 		childErr := wmi.Query(childQuery, &childProcesses)
 		if childErr != nil {
 			return childErr
@@ -65,17 +71,39 @@ func (s *SubProcess) getUsageInfoInner() (*UsageInfo, error) {
 
 	// Aggregate CPU and memory usage of all processes
 	var totalUserModeTime, totalKernelModeTime, totalPageFileUsage uint64
+	usage := &UsageInfo{
+		When:    UtcNow(),
+		Metrics: metrics,
+	}
 	for _, process := range allProcesses {
 		totalUserModeTime += uint64(process.UserModeTime)
 		totalKernelModeTime += uint64(process.KernelModeTime)
 		totalPageFileUsage += uint64(process.PageFileUsage)
+
+		if metrics.Threads {
+			usage.Threads += int64(process.ThreadCount)
+		}
+		if metrics.FileDescriptors {
+			usage.OpenFds += int64(process.HandleCount)
+		}
+		if metrics.Io {
+			usage.IoReadBytes += int64(process.ReadTransferCount)
+			usage.IoWriteBytes += int64(process.WriteTransferCount)
+		}
+	}
+	// context switches are not exposed by Win32_Process
+	usage.Metrics.ContextSwitches = false
+	if metrics.Children {
+		usage.Children = int64(len(allProcesses) - 1)
 	}
 
-	totalCpuUsageDuration := time.Duration(totalUserModeTime+totalKernelModeTime) * (time.Nanosecond * 100)
+	usage.TotalCpuUsage = time.Duration(totalUserModeTime+totalKernelModeTime) * (time.Nanosecond * 100)
+	usage.MemUsage = int64(totalPageFileUsage) * 1024
+
+	return usage, nil
+}
 
-	return &UsageInfo{
-		TotalCpuUsage: totalCpuUsageDuration,
-		MemUsage:      int64(totalPageFileUsage) * 1024,
-		When:          UtcNow(),
-	}, nil
+// exitSignal is always empty, there are no signals on windows.
+func exitSignal(state *os.ProcessState) string {
+	return ""
 }
@@ -0,0 +1,379 @@
+package procsmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/apepenkov/yalog"
+	"github.com/jackc/pgx/v5/pgtype"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// telegramIdleInterval is how often the settings are checked while commands are disabled, and the delay after a failed poll.
+	telegramIdleInterval = 10 * time.Second
+	// telegramConfirmTimeout is how long a destructive command waits for its confirmation.
+	telegramConfirmTimeout  = 2 * time.Minute
+	telegramDefaultLogLines = 20
+	// telegramMaxMessageLength is a bit less than the 4096 characters the Bot API allows.
+	telegramMaxMessageLength = 4000
+)
+
+const telegramHelp = `Commands:
+/status - summary of all processes
+/list - every process with its status
+/logs <name> [n] - last n lines of output
+/start <name> - start a process
+/stop <name> - stop a process, after a confirmation
+/restart <name> - restart a process, after a confirmation`
+
+// telegramSignalActions are the audit actions of the signals commands send, like the API records them.
+var telegramSignalActions = map[Signal]string{
+	Start:   "process.start",
+	Stop:    "process.stop",
+	Restart: "process.restart",
+}
+
+// pendingTelegramCommand is a destructive command that waits for its confirmation.
+type pendingTelegramCommand struct {
+	chatID    int64
+	command   string
+	signal    Signal
+	processID int32
+	expires   time.Time
+}
+
+// telegramBot handles the commands sent to the notifications bot. It's only used by telegramBotWorker.
+type telegramBot struct {
+	pm      *ProcessManager
+	logger  *yalog.Logger
+	nc      *config.NotificationsConfig
+	cfg     *config.TelegramCommandsConfig
+	pending map[string]*pendingTelegramCommand
+}
+
+// telegramBotWorker long-polls the notifications bot for commands while they are enabled.
+// Settings are re-read before every poll, so changes apply without a restart.
+func (pm *ProcessManager) telegramBotWorker() {
+	bot := &telegramBot{
+		pm:      pm,
+		logger:  pm.Logger.NewLogger("telegram"),
+		pending: make(map[string]*pendingTelegramCommand),
+	}
+	var offset int64
+	for {
+		bot.nc = pm.Notifications()
+		bot.cfg = bot.nc.TelegramCommands
+		if bot.cfg == nil || !bot.cfg.Enabled || bot.nc.TelegramBotToken == "" {
+			if !pm.sleep(telegramIdleInterval) {
+				return
+			}
+			continue
+		}
+
+		updates, err := bot.nc.GetTelegramUpdates(offset, bot.cfg.GetPollTimeout())
+		select {
+		case <-pm.stop:
+			return
+		default:
+		}
+		if err != nil {
+			bot.logger.Warningf("Failed to get telegram updates: %v\n", err)
+			if !pm.sleep(telegramIdleInterval) {
+				return
+			}
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			bot.handleUpdate(update)
+		}
+	}
+}
+
+// sleep waits for d and returns false if the manager is closed in the meantime.
+func (pm *ProcessManager) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-pm.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (b *telegramBot) allowed(chatID int64) bool {
+	return slices.Contains(b.cfg.AllowedChatIDs, chatID)
+}
+
+func (b *telegramBot) reply(chatID int64, text string) {
+	// long replies are output, so the end of them is kept.
+	if len(text) > telegramMaxMessageLength {
+		start := len(text) - telegramMaxMessageLength
+		for start < len(text) && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		text = "…" + text[start:]
+	}
+	if res := b.nc.SendTelegramMessage(text, chatID); !res.Success {
+		b.logger.Warningf("Failed to reply to chat %d: %s\n", chatID, res.Error)
+	}
+}
+
+func (b *telegramBot) handleUpdate(update config.TelegramUpdate) {
+	for token, pending := range b.pending {
+		if time.Now().After(pending.expires) {
+			delete(b.pending, token)
+		}
+	}
+
+	if update.CallbackQuery != nil {
+		b.handleCallback(update.CallbackQuery)
+		return
+	}
+	if update.Message == nil || !strings.HasPrefix(update.Message.Text, "/") {
+		return
+	}
+	chatID := update.Message.Chat.ID
+	if !b.allowed(chatID) {
+		b.logger.Warningf("Ignoring command from chat %d, which is not allowed: %q\n", chatID, update.Message.Text)
+		return
+	}
+	b.handleCommand(chatID, update.Message.Text)
+}
+
+// findRunner finds a process by its name, ignoring case if there is no exact match.
+func (b *telegramBot) findRunner(name string) *ProcessRunner {
+	var found *ProcessRunner
+	for _, runner := range b.pm.Runners() {
+		if runner.Process.Name == name {
+			return runner
+		}
+		if found == nil && strings.EqualFold(runner.Process.Name, name) {
+			found = runner
+		}
+	}
+	return found
+}
+
+// sortedRunners returns all runners, ordered by name.
+func (b *telegramBot) sortedRunners() []*ProcessRunner {
+	runners := b.pm.Runners()
+	slices.SortFunc(runners, func(a, b *ProcessRunner) int {
+		return strings.Compare(strings.ToLower(a.Process.Name), strings.ToLower(b.Process.Name))
+	})
+	return runners
+}
+
+func (b *telegramBot) handleCommand(chatID int64, text string) {
+	fields := strings.Fields(text)
+	// commands in groups can be addressed to a bot, like /status@procsman_bot.
+	command, _, _ := strings.Cut(strings.ToLower(fields[0]), "@")
+	args := fields[1:]
+
+	switch command {
+	case "/status":
+		b.reply(chatID, b.status())
+	case "/list":
+		b.reply(chatID, b.list())
+	case "/logs":
+		b.reply(chatID, b.logs(args))
+	case "/start", "/stop", "/restart":
+		if len(args) == 0 {
+			b.reply(chatID, fmt.Sprintf("Usage: %s <name>", command))
+			return
+		}
+		name := strings.Join(args, " ")
+		runner := b.findRunner(name)
+		if runner == nil {
+			b.reply(chatID, fmt.Sprintf("There is no process %q", name))
+			return
+		}
+		switch command {
+		case "/start":
+			b.reply(chatID, b.execute(chatID, text, Start, runner))
+		case "/stop":
+			b.confirm(chatID, text, Stop, runner)
+		case "/restart":
+			b.confirm(chatID, text, Restart, runner)
+		}
+	default:
+		b.reply(chatID, telegramHelp)
+	}
+}
+
+func (b *telegramBot) status() string {
+	runners := b.sortedRunners()
+	counts := make(map[db.ProcessStatus]int)
+	notRunning := make([]string, 0)
+	for _, runner := range runners {
+		status := runner.Status()
+		counts[status]++
+		if status != db.ProcessStatusRUNNING && runner.Process.Enabled {
+			notRunning = append(notRunning, fmt.Sprintf("%s: %s", runner.Process.Name, status))
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d processes, %d running", len(runners), counts[db.ProcessStatusRUNNING])
+	for _, status := range []db.ProcessStatus{db.ProcessStatusSTOPPED, db.ProcessStatusCRASHED} {
+		if counts[status] > 0 {
+			fmt.Fprintf(&sb, ", %d %s", counts[status], strings.ToLower(string(status)))
+		}
+	}
+	if len(notRunning) > 0 {
+		sb.WriteString("\n\nEnabled, but not running:\n")
+		sb.WriteString(strings.Join(notRunning, "\n"))
+	}
+	return sb.String()
+}
+
+func (b *telegramBot) list() string {
+	runners := b.sortedRunners()
+	if len(runners) == 0 {
+		return "There are no processes"
+	}
+	lines := make([]string, 0, len(runners))
+	for _, runner := range runners {
+		line := fmt.Sprintf("%s: %s", runner.Process.Name, runner.Status())
+		if metrics := runner.Metrics(); metrics.Pid != 0 {
+			line += fmt.Sprintf(", pid %d, up %s", metrics.Pid, time.Since(metrics.StartedAt).Round(time.Second))
+		}
+		if !runner.Process.Enabled {
+			line += ", disabled"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *telegramBot) logs(args []string) string {
+	n := telegramDefaultLogLines
+	if len(args) > 1 {
+		if parsed, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			n = parsed
+			args = args[:len(args)-1]
+		}
+	}
+	if len(args) == 0 || n <= 0 {
+		return "Usage: /logs <name> [n]"
+	}
+	name := strings.Join(args, " ")
+	runner := b.findRunner(name)
+	if runner == nil {
+		return fmt.Sprintf("There is no process %q", name)
+	}
+	lines := runner.recentOutput.Lines()
+	if len(lines) == 0 {
+		return fmt.Sprintf("%s has no recent output", runner.Process.Name)
+	}
+	lines = lines[len(lines)-min(n, len(lines)):]
+	return strings.Join(lines, "\n")
+}
+
+// confirm asks for a confirmation of a destructive command with an inline keyboard.
+func (b *telegramBot) confirm(chatID int64, command string, signal Signal, runner *ProcessRunner) {
+	tokenBytes := make([]byte, 8)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		b.logger.Errorf("Failed to generate confirmation token: %v\n", err)
+		b.reply(chatID, "Internal error, try again")
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	b.pending[token] = &pendingTelegramCommand{
+		chatID:    chatID,
+		command:   command,
+		signal:    signal,
+		processID: runner.Process.ID,
+		expires:   time.Now().Add(telegramConfirmTimeout),
+	}
+
+	text := fmt.Sprintf("%s %s?", signal, runner.Process.Name)
+	buttons := [][]config.TelegramButton{{
+		{Text: signal.String(), CallbackData: "confirm:" + token},
+		{Text: "Cancel", CallbackData: "cancel:" + token},
+	}}
+	if res := b.nc.SendTelegramKeyboard(text, chatID, buttons); !res.Success {
+		delete(b.pending, token)
+		b.logger.Warningf("Failed to ask chat %d for a confirmation: %s\n", chatID, res.Error)
+	}
+}
+
+func (b *telegramBot) handleCallback(query *config.TelegramCallbackQuery) {
+	if query.Message == nil || !b.allowed(query.Message.Chat.ID) {
+		b.logger.Warningf("Ignoring button pressed by user %d outside of the allowed chats\n", query.From.ID)
+		return
+	}
+	chatID := query.Message.Chat.ID
+	action, token, _ := strings.Cut(query.Data, ":")
+	pending := b.pending[token]
+
+	var answer, result string
+	switch {
+	case pending == nil || pending.chatID != chatID:
+		answer = "This confirmation has expired"
+		result = "Expired, send the command again"
+	case action == "cancel":
+		delete(b.pending, token)
+		answer = "Cancelled"
+		result = fmt.Sprintf("%s: cancelled", pending.command)
+	case action == "confirm":
+		delete(b.pending, token)
+		runner := b.pm.GetRunner(pending.processID)
+		if runner == nil {
+			answer = "The process no longer exists"
+			result = fmt.Sprintf("%s: the process no longer exists", pending.command)
+			break
+		}
+		answer = "Confirmed"
+		result = b.execute(chatID, pending.command, pending.signal, runner)
+	default:
+		return
+	}
+
+	if err := b.nc.AnswerTelegramCallback(query.ID, answer); err != nil {
+		b.logger.Warningf("Failed to answer button press: %v\n", err)
+	}
+	if err := b.nc.EditTelegramMessage(chatID, query.Message.MessageID, result); err != nil {
+		b.logger.Warningf("Failed to edit confirmation message: %v\n", err)
+		b.reply(chatID, result)
+	}
+}
+
+// execute sends a signal to a runner and records it in the audit log, like the API does for its users.
+func (b *telegramBot) execute(chatID int64, command string, signal Signal, runner *ProcessRunner) string {
+	entry, err := b.pm.Queries.InsertAuditLog(context.Background(), db.InsertAuditLogParams{
+		Actor:  fmt.Sprintf("telegram:%d", chatID),
+		Method: "TELEGRAM",
+		Route:  command,
+		Action: telegramSignalActions[signal],
+		// the target type of processes in the API.
+		TargetType: "process",
+		TargetID:   pgtype.Int4{Int32: runner.Process.ID, Valid: true},
+	})
+	auditID := pgtype.Int4{}
+	if err != nil {
+		b.logger.Errorf("Error writing audit log: %v\n", err)
+	} else {
+		auditID = pgtype.Int4{Int32: entry.ID, Valid: true}
+	}
+	b.logger.Infof("Chat %d sent %s to %s\n", chatID, signal, runner.Process.Name)
+	runner.SendSignal(signal, auditID)
+
+	switch signal {
+	case Start:
+		return fmt.Sprintf("Starting %s", runner.Process.Name)
+	case Stop:
+		return fmt.Sprintf("Stopping %s", runner.Process.Name)
+	default:
+		return fmt.Sprintf("Restarting %s", runner.Process.Name)
+	}
+}
@@ -0,0 +1,332 @@
+package procsmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/apepenkov/yalog"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"net/http"
+	"net/http/httptest"
+	"procsman_backend/config"
+	"procsman_backend/db"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testBotToken = "123:secret"
+
+type telegramCall struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeBotApi is a stand-in for the Bot API that records the calls it gets.
+type fakeBotApi struct {
+	mu    sync.Mutex
+	calls []telegramCall
+}
+
+func (f *fakeBotApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testBotToken+"/")
+	if !ok {
+		http.Error(w, `{"ok":false,"description":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	params := make(map[string]interface{})
+	_ = json.NewDecoder(r.Body).Decode(&params)
+
+	f.mu.Lock()
+	f.calls = append(f.calls, telegramCall{method: method, params: params})
+	messageID := len(f.calls)
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if method == "answerCallbackQuery" {
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":true}`)
+		return
+	}
+	_, _ = fmt.Fprintf(w, `{"ok":true,"result":{"message_id":%d}}`, messageID)
+}
+
+// takeCalls returns the calls since the last time it was called.
+func (f *fakeBotApi) takeCalls() []telegramCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// fakeAuditDb accepts audit log entries, it fails every other query.
+type fakeAuditDb struct {
+	entries [][]interface{}
+}
+
+func (f *fakeAuditDb) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("not supported")
+}
+
+func (f *fakeAuditDb) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (f *fakeAuditDb) QueryRow(_ context.Context, sql string, args ...interface{}) pgx.Row {
+	if !strings.Contains(sql, "INSERT INTO audit_log") {
+		return errorRow{}
+	}
+	f.entries = append(f.entries, args)
+	return auditRow{id: int32(len(f.entries))}
+}
+
+type errorRow struct{}
+
+func (errorRow) Scan(...interface{}) error {
+	return errors.New("not supported")
+}
+
+// auditRow is a row returned by InsertAuditLog, only its id is set.
+type auditRow struct {
+	id int32
+}
+
+func (r auditRow) Scan(dest ...interface{}) error {
+	*dest[0].(*int32) = r.id
+	return nil
+}
+
+type testTelegramBot struct {
+	bot   *telegramBot
+	api   *fakeBotApi
+	audit *fakeAuditDb
+	web   *ProcessRunner
+}
+
+func newTestTelegramBot(t *testing.T) *testTelegramBot {
+	api := &fakeBotApi{}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	audit := &fakeAuditDb{}
+	logger := yalog.NewLogger("test")
+	logger.SetVerboseLevel(yalog.VerboseLevelError)
+	pm := &ProcessManager{
+		Queries: db.New(audit),
+		Logger:  logger,
+		runners: make(map[int32]*ProcessRunner),
+	}
+	for _, process := range []db.Process{
+		{ID: 1, Name: "web", Enabled: true, Status: db.ProcessStatusRUNNING},
+		{ID: 2, Name: "my worker", Enabled: true, Status: db.ProcessStatusSTOPPED},
+	} {
+		p := process
+		pm.runners[p.ID] = NewProcessRunner(pm, &p)
+	}
+	web := pm.runners[1]
+	for i := 1; i <= 30; i++ {
+		_, _ = fmt.Fprintf(web.recentOutput, "line %d\n", i)
+	}
+
+	nc := &config.NotificationsConfig{NotificationSettings: config.NotificationSettings{
+		TelegramBotToken: testBotToken,
+		TelegramApiUrl:   server.URL,
+	}}
+	return &testTelegramBot{
+		bot: &telegramBot{
+			pm:      pm,
+			logger:  logger,
+			nc:      nc,
+			cfg:     &config.TelegramCommandsConfig{Enabled: true, AllowedChatIDs: []int64{42, 43}},
+			pending: make(map[string]*pendingTelegramCommand),
+		},
+		api:   api,
+		audit: audit,
+		web:   web,
+	}
+}
+
+func (tb *testTelegramBot) message(chatID int64, text string) {
+	tb.bot.handleUpdate(config.TelegramUpdate{Message: &config.TelegramMessage{
+		MessageID: 1,
+		Chat:      config.TelegramChat{ID: chatID},
+		Text:      text,
+	}})
+}
+
+func (tb *testTelegramBot) press(chatID int64, data string) {
+	tb.bot.handleUpdate(config.TelegramUpdate{CallbackQuery: &config.TelegramCallbackQuery{
+		ID:      "query",
+		From:    config.TelegramUser{ID: chatID},
+		Message: &config.TelegramMessage{MessageID: 5, Chat: config.TelegramChat{ID: chatID}},
+		Data:    data,
+	}})
+}
+
+// reply returns the text of the only call, which must be a sendMessage to chatID.
+func (tb *testTelegramBot) reply(t *testing.T, chatID int64) string {
+	t.Helper()
+	calls := tb.api.takeCalls()
+	if len(calls) != 1 || calls[0].method != "sendMessage" || calls[0].params["chat_id"] != float64(chatID) {
+		t.Fatalf("expected a reply to %d, got %v", chatID, calls)
+	}
+	return calls[0].params["text"].(string)
+}
+
+// keyboard returns the callback data of the buttons of a confirmation sent to chatID.
+func (tb *testTelegramBot) keyboard(t *testing.T, chatID int64) (confirm, cancel string) {
+	t.Helper()
+	calls := tb.api.takeCalls()
+	if len(calls) != 1 || calls[0].method != "sendMessage" || calls[0].params["chat_id"] != float64(chatID) {
+		t.Fatalf("expected a confirmation to %d, got %v", chatID, calls)
+	}
+	markup, _ := calls[0].params["reply_markup"].(map[string]interface{})
+	rows, _ := markup["inline_keyboard"].([]interface{})
+	if len(rows) != 1 {
+		t.Fatalf("expected a keyboard, got %v", calls[0].params)
+	}
+	buttons := rows[0].([]interface{})
+	confirm = buttons[0].(map[string]interface{})["callback_data"].(string)
+	cancel = buttons[1].(map[string]interface{})["callback_data"].(string)
+	return confirm, cancel
+}
+
+// pressResult returns the answer to a pressed button and the text its message was edited to.
+func (tb *testTelegramBot) pressResult(t *testing.T) (answer, edited string) {
+	t.Helper()
+	calls := tb.api.takeCalls()
+	if len(calls) != 2 || calls[0].method != "answerCallbackQuery" || calls[1].method != "editMessageText" {
+		t.Fatalf("expected an answer and an edit, got %v", calls)
+	}
+	return calls[0].params["text"].(string), calls[1].params["text"].(string)
+}
+
+func (tb *testTelegramBot) signal(t *testing.T) (SignalRequest, bool) {
+	t.Helper()
+	select {
+	case request := <-tb.web.SignalIn:
+		return request, true
+	default:
+		return SignalRequest{}, false
+	}
+}
+
+func TestTelegramBotAllowedChats(t *testing.T) {
+	tb := newTestTelegramBot(t)
+
+	tb.message(7, "/start web")
+	tb.message(7, "/status")
+	if calls := tb.api.takeCalls(); len(calls) != 0 {
+		t.Fatalf("replied to a chat that is not allowed: %v", calls)
+	}
+	if _, ok := tb.signal(t); ok {
+		t.Fatal("a chat that is not allowed started a process")
+	}
+
+	tb.message(42, "hello")
+	if calls := tb.api.takeCalls(); len(calls) != 0 {
+		t.Fatalf("replied to a message that is not a command: %v", calls)
+	}
+
+	tb.message(43, "/status@procsman_bot")
+	if reply := tb.reply(t, 43); !strings.HasPrefix(reply, "2 processes, 1 running, 1 stopped") {
+		t.Fatalf("status is %q", reply)
+	}
+}
+
+func TestTelegramBotLogs(t *testing.T) {
+	lastLines := func(from, to int) string {
+		lines := make([]string, 0)
+		for i := from; i <= to; i++ {
+			lines = append(lines, fmt.Sprintf("line %d", i))
+		}
+		return strings.Join(lines, "\n")
+	}
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"/logs web 3", lastLines(28, 30)},
+		{"/logs web", lastLines(11, 30)},
+		{"/logs WEB 1", lastLines(30, 30)},
+		// only the last 20 lines are kept.
+		{"/logs web 100", lastLines(11, 30)},
+		{"/logs my worker 5", "my worker has no recent output"},
+		{"/logs web x", `There is no process "web x"`},
+		{"/logs nope 5", `There is no process "nope"`},
+		{"/logs web 0", "Usage: /logs <name> [n]"},
+		{"/logs", "Usage: /logs <name> [n]"},
+	}
+	tb := newTestTelegramBot(t)
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			tb.message(42, tt.command)
+			if reply := tb.reply(t, 42); reply != tt.want {
+				t.Fatalf("got %q, want %q", reply, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelegramBotStart(t *testing.T) {
+	tb := newTestTelegramBot(t)
+
+	tb.message(42, "/start web")
+	if reply := tb.reply(t, 42); reply != "Starting web" {
+		t.Fatalf("reply is %q", reply)
+	}
+	request, ok := tb.signal(t)
+	if !ok || request.Signal != Start || !request.AuditID.Valid || request.AuditID.Int32 != 1 {
+		t.Fatalf("got signal %+v, %v", request, ok)
+	}
+	if entry := tb.audit.entries[0]; entry[0] != "telegram:42" || entry[3] != "TELEGRAM" || entry[5] != "process.start" {
+		t.Fatalf("audit entry is %v", entry)
+	}
+}
+
+func TestTelegramBotConfirmation(t *testing.T) {
+	tb := newTestTelegramBot(t)
+
+	// cancelled.
+	tb.message(42, "/stop web")
+	confirm, cancel := tb.keyboard(t, 42)
+	tb.press(7, cancel)
+	if calls := tb.api.takeCalls(); len(calls) != 0 {
+		t.Fatalf("handled a button pressed in a chat that is not allowed: %v", calls)
+	}
+	tb.press(42, cancel)
+	if answer, edited := tb.pressResult(t); answer != "Cancelled" || edited != "/stop web: cancelled" {
+		t.Fatalf("got %q, %q", answer, edited)
+	}
+	tb.press(42, confirm)
+	if answer, _ := tb.pressResult(t); answer != "This confirmation has expired" {
+		t.Fatalf("confirmed after cancelling: %q", answer)
+	}
+	if _, ok := tb.signal(t); ok {
+		t.Fatal("a cancelled command sent a signal")
+	}
+
+	// confirmed, only in the chat that asked for it.
+	tb.message(42, "/restart web")
+	confirm, _ = tb.keyboard(t, 42)
+	tb.press(43, confirm)
+	if answer, _ := tb.pressResult(t); answer != "This confirmation has expired" {
+		t.Fatalf("confirmed from another chat: %q", answer)
+	}
+	if _, ok := tb.signal(t); ok {
+		t.Fatal("another chat confirmed a command")
+	}
+	tb.press(42, confirm)
+	if answer, edited := tb.pressResult(t); answer != "Confirmed" || edited != "Restarting web" {
+		t.Fatalf("got %q, %q", answer, edited)
+	}
+	request, ok := tb.signal(t)
+	if !ok || request.Signal != Restart || !request.AuditID.Valid {
+		t.Fatalf("got signal %+v, %v", request, ok)
+	}
+	if len(tb.audit.entries) != 1 || tb.audit.entries[0][5] != "process.restart" {
+		t.Fatalf("audit entries are %v", tb.audit.entries)
+	}
+}
@@ -0,0 +1,132 @@
+package procsmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"procsman_backend/db"
+	"time"
+)
+
+// thresholdState tracks a single db.ThresholdRule between recordings.
+type thresholdState struct {
+	breached bool
+	// count and since describe the current streak of recordings above the threshold.
+	count int
+	since time.Time
+}
+
+// ThresholdEventInfo is stored in additional_info of THRESHOLD events.
+type ThresholdEventInfo struct {
+	Metric       db.ThresholdMetric `json:"metric"`
+	Value        float64            `json:"value"`
+	Above        float64            `json:"above"`
+	RecoverBelow float64            `json:"recover_below"`
+	Recovered    bool               `json:"recovered"`
+	Restarted    bool               `json:"restarted"`
+}
+
+// evaluateThresholds checks the threshold rules of the process against the latest recording.
+// Rules are keyed by value, so editing a rule starts it from scratch.
+func (pr *ProcessRunner) evaluateThresholds(cpuPercent float64, memUsage int64, now time.Time) {
+	rules := pr.Process.Configuration.GetThresholdRules()
+	if len(rules) == 0 {
+		pr.thresholdStates = nil
+		return
+	}
+	if pr.thresholdStates == nil {
+		pr.thresholdStates = make(map[db.ThresholdRule]*thresholdState)
+	}
+
+	active := make(map[db.ThresholdRule]bool, len(rules))
+	for _, rule := range rules {
+		active[rule] = true
+		state, ok := pr.thresholdStates[rule]
+		if !ok {
+			state = &thresholdState{}
+			pr.thresholdStates[rule] = state
+		}
+
+		value := cpuPercent
+		if rule.Metric == db.ThresholdMetricMemory {
+			value = float64(memUsage)
+		}
+
+		if state.breached {
+			if value <= rule.GetRecoverBelow() {
+				state.breached = false
+				pr.onThreshold(rule, value, true)
+			}
+			continue
+		}
+
+		if value <= rule.Above {
+			state.count = 0
+			continue
+		}
+		if state.count == 0 {
+			state.since = now
+		}
+		state.count++
+		if state.count >= rule.Samples && now.Sub(state.since) >= rule.GetDuration() {
+			state.breached = true
+			state.count = 0
+			pr.onThreshold(rule, value, false)
+		}
+	}
+
+	for rule := range pr.thresholdStates {
+		if !active[rule] {
+			delete(pr.thresholdStates, rule)
+		}
+	}
+}
+
+// onThreshold runs the actions of a rule when it's breached. Recovery only notifies and logs.
+func (pr *ProcessRunner) onThreshold(rule db.ThresholdRule, value float64, recovered bool) {
+	restart := rule.Restart && !recovered
+
+	var text string
+	if recovered {
+		pr.Logger.Infof("Threshold recovered: %s is %s\n", rule.Metric, formatThresholdValue(rule.Metric, value))
+		text = fmt.Sprintf("Process %s %s is back to %s", pr.Process.Name, rule.Metric, formatThresholdValue(rule.Metric, value))
+	} else {
+		pr.Logger.Warningf("Threshold breached: %s is %s, above %s\n", rule.Metric, formatThresholdValue(rule.Metric, value), formatThresholdValue(rule.Metric, rule.Above))
+		text = fmt.Sprintf("Process %s %s is %s, above %s", pr.Process.Name, rule.Metric, formatThresholdValue(rule.Metric, value), formatThresholdValue(rule.Metric, rule.Above))
+		if restart {
+			text += ", restarting"
+		}
+	}
+
+	info, _ := json.Marshal(ThresholdEventInfo{
+		Metric:       rule.Metric,
+		Value:        value,
+		Above:        rule.Above,
+		RecoverBelow: rule.GetRecoverBelow(),
+		Recovered:    recovered,
+		Restarted:    restart,
+	})
+
+	if rule.Notify {
+		go pr.notify(db.ProcessEventTypeTHRESHOLD, info, text)
+	}
+
+	if rule.LogEvent {
+		_ = pr.LogEvent(db.ProcessEventTypeTHRESHOLD, info)
+	}
+
+	if restart {
+		// this is called from the Work loop, which is the only reader of SignalIn, so it must not block.
+		select {
+		case pr.SignalIn <- SignalRequest{Signal: Restart}:
+		default:
+			pr.Logger.Warningf("Could not restart process after threshold breach, signal queue is full\n")
+		}
+	}
+}
+
+func formatThresholdValue(metric db.ThresholdMetric, value float64) string {
+	if metric == db.ThresholdMetricCpu {
+		return fmt.Sprintf("%.1f%%", value)
+	}
+	return fmt.Sprintf("%.1f MiB", value/1024/1024)
+}
@@ -95,8 +95,13 @@ FROM process_group
 WHERE name = $1;
 
 -- name: InsertProcessEvent :one
-INSERT INTO process_event (process_id, event, additional_info)
-VALUES ($1, $2, $3) RETURNING *;
+INSERT INTO process_event (process_id, event, additional_info, audit_id)
+VALUES ($1, $2, $3, $4) RETURNING *;
+
+-- name: GetProcessEvent :one
+SELECT *
+FROM process_event
+WHERE id = $1;
 
 -- name: GetProcessEvents :many
 SELECT *
@@ -120,6 +125,21 @@ WHERE process_id = $1
     ORDER BY id DESC
     LIMIT $4;
 
+-- name: GetEvents :many
+-- events of all processes, newest first. Empty filter arrays match everything.
+-- before_id is the keyset cursor: only events older than it are returned.
+SELECT e.*
+FROM process_event e
+         LEFT JOIN process p ON p.id = e.process_id
+WHERE (cardinality(sqlc.arg(process_ids)::integer[]) = 0 OR e.process_id = ANY (sqlc.arg(process_ids)::integer[]))
+  AND (cardinality(sqlc.arg(group_ids)::integer[]) = 0 OR p.process_group_id = ANY (sqlc.arg(group_ids)::integer[]))
+  AND (cardinality(sqlc.arg(event_types)::text[]) = 0 OR e.event::text = ANY (sqlc.arg(event_types)::text[]))
+  AND e.created_at >= sqlc.arg(range_from)
+  AND e.created_at <= sqlc.arg(range_to)
+  AND (sqlc.narg(before_id)::integer IS NULL OR e.id < sqlc.narg(before_id)::integer)
+ORDER BY e.id DESC
+LIMIT sqlc.arg(row_limit);
+
 
 
 -- name: GetAllProcessEvents :many
@@ -128,8 +148,10 @@ FROM process_event
 ORDER BY id ASC;
 
 -- name: InsertProcessStats :one
-INSERT INTO process_stats (process_id, cpu_usage, cpu_usage_percentage, memory_usage)
-VALUES ($1, $2, $3, $4) RETURNING *;
+INSERT INTO process_stats (process_id, cpu_usage, cpu_usage_percentage, memory_usage, thread_count, open_fds,
+                           io_read_bytes, io_write_bytes, ctx_switches_voluntary, ctx_switches_involuntary,
+                           child_count)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING *;
 
 -- name: GetProcessStats :many
 SELECT *
@@ -147,6 +169,94 @@ WHERE process_id = $1
 ORDER BY id;
 
 
+-- name: RollupRawStats :exec
+-- aggregates process_stats in [range_from, range_to) into buckets of the given resolution (in seconds).
+INSERT INTO process_stats_rollup (process_id, resolution, bucket, samples, cpu_usage, cpu_avg, cpu_min, cpu_max, cpu_p95,
+                                  memory_avg, memory_min, memory_max, memory_p95)
+SELECT process_id,
+       sqlc.arg(resolution)::integer,
+       to_timestamp(floor(extract(epoch FROM created_at) / sqlc.arg(resolution)::integer) *
+                    sqlc.arg(resolution)::integer) AT TIME ZONE 'UTC'                        AS bucket,
+       count(*)::integer,
+       sum(cpu_usage)::bigint,
+       avg(cpu_usage_percentage)::float,
+       min(cpu_usage_percentage)::float,
+       max(cpu_usage_percentage)::float,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY cpu_usage_percentage)::float,
+       avg(memory_usage)::bigint,
+       min(memory_usage)::bigint,
+       max(memory_usage)::bigint,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY memory_usage)::bigint
+FROM process_stats
+WHERE created_at >= sqlc.arg(range_from)
+  AND created_at < sqlc.arg(range_to)
+  AND process_id IS NOT NULL
+GROUP BY process_id, bucket
+ON CONFLICT (process_id, resolution, bucket) DO UPDATE SET samples=excluded.samples,
+                                                           cpu_usage=excluded.cpu_usage,
+                                                           cpu_avg=excluded.cpu_avg,
+                                                           cpu_min=excluded.cpu_min,
+                                                           cpu_max=excluded.cpu_max,
+                                                           cpu_p95=excluded.cpu_p95,
+                                                           memory_avg=excluded.memory_avg,
+                                                           memory_min=excluded.memory_min,
+                                                           memory_max=excluded.memory_max,
+                                                           memory_p95=excluded.memory_p95;
+
+-- name: RollupStats :exec
+-- aggregates rollups of source_resolution in [range_from, range_to) into buckets of the given resolution.
+INSERT INTO process_stats_rollup (process_id, resolution, bucket, samples, cpu_usage, cpu_avg, cpu_min, cpu_max, cpu_p95,
+                                  memory_avg, memory_min, memory_max, memory_p95)
+SELECT process_id,
+       sqlc.arg(resolution)::integer,
+       to_timestamp(floor(extract(epoch FROM r.bucket) / sqlc.arg(resolution)::integer) *
+                    sqlc.arg(resolution)::integer) AT TIME ZONE 'UTC'                         AS new_bucket,
+       sum(samples)::integer,
+       sum(cpu_usage)::bigint,
+       (sum(cpu_avg * samples) / sum(samples))::float,
+       min(cpu_min)::float,
+       max(cpu_max)::float,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY cpu_p95)::float,
+       (sum(memory_avg * samples) / sum(samples))::bigint,
+       min(memory_min)::bigint,
+       max(memory_max)::bigint,
+       percentile_cont(0.95) WITHIN GROUP (ORDER BY memory_p95)::bigint
+FROM process_stats_rollup r
+WHERE r.resolution = sqlc.arg(source_resolution)::integer
+  AND r.bucket >= sqlc.arg(range_from)
+  AND r.bucket < sqlc.arg(range_to)
+GROUP BY process_id, new_bucket
+ON CONFLICT (process_id, resolution, bucket) DO UPDATE SET samples=excluded.samples,
+                                                           cpu_usage=excluded.cpu_usage,
+                                                           cpu_avg=excluded.cpu_avg,
+                                                           cpu_min=excluded.cpu_min,
+                                                           cpu_max=excluded.cpu_max,
+                                                           cpu_p95=excluded.cpu_p95,
+                                                           memory_avg=excluded.memory_avg,
+                                                           memory_min=excluded.memory_min,
+                                                           memory_max=excluded.memory_max,
+                                                           memory_p95=excluded.memory_p95;
+
+-- name: GetProcessStatsRollupFromTo :many
+SELECT *
+FROM process_stats_rollup
+WHERE process_id = $1
+  AND resolution = $2
+  AND bucket >= $3
+  AND bucket <= $4
+ORDER BY bucket;
+
+-- name: DeleteProcessStatsBefore :execrows
+DELETE
+FROM process_stats
+WHERE created_at < $1;
+
+-- name: DeleteProcessStatsRollupBefore :execrows
+DELETE
+FROM process_stats_rollup
+WHERE resolution = $1
+  AND bucket < $2;
+
 -- name: GetProcessesByGroup :many
 SELECT *
 FROM process
@@ -177,14 +287,338 @@ WHERE process_id=$1
 ORDER BY id;
 
 -- name: GetLogFilesFromTo :many
+-- selects every file that overlaps [range_from, range_to], plus the file that is currently being written to, if any.
 SELECT *
 FROM logs
-WHERE process_id = $1
-  AND start_time >= $2
-  AND (end_time <= $3 OR end_time IS NULL)
+WHERE process_id = sqlc.arg(process_id)
+  AND ((start_time <= sqlc.arg(range_to) AND (end_time >= sqlc.arg(range_from) OR end_time IS NULL))
+    OR id = sqlc.narg(current_log_id))
 ORDER BY id;
 
 -- name: SetLogEndTime :exec
 UPDATE logs
 SET end_time=$2
 WHERE id = $1;
+
+-- name: GetLastRollupBucket :one
+SELECT coalesce(max(bucket), '1970-01-01 00:00:00')::timestamp AS last_bucket
+FROM process_stats_rollup
+WHERE resolution = $1;
+
+-- name: GetGroupStatsFromTo :many
+-- sums stats of every process in the group. Samples of each process are averaged within a bucket first.
+SELECT per_process.bucket::timestamp         AS bucket,
+       sum(per_process.cpu)::float           AS cpu_usage_percentage,
+       sum(per_process.memory)::bigint       AS memory_usage,
+       count(*)::integer                     AS processes
+FROM (SELECT s.process_id,
+             to_timestamp(floor(extract(epoch FROM s.created_at) / sqlc.arg(bucket_size)::integer) *
+                          sqlc.arg(bucket_size)::integer) AT TIME ZONE 'UTC' AS bucket,
+             avg(s.cpu_usage_percentage)                                     AS cpu,
+             avg(s.memory_usage)                                             AS memory
+      FROM process_stats s
+               JOIN process p ON p.id = s.process_id
+      WHERE p.process_group_id = sqlc.arg(group_id)
+        AND s.created_at >= sqlc.arg(range_from)
+        AND s.created_at <= sqlc.arg(range_to)
+      GROUP BY s.process_id, bucket) per_process
+GROUP BY per_process.bucket
+ORDER BY per_process.bucket;
+
+-- name: GetGroupStatsRollupFromTo :many
+SELECT r.bucket,
+       sum(r.cpu_avg)::float     AS cpu_usage_percentage,
+       sum(r.memory_avg)::bigint AS memory_usage,
+       count(*)::integer         AS processes
+FROM process_stats_rollup r
+         JOIN process p ON p.id = r.process_id
+WHERE p.process_group_id = sqlc.arg(group_id)
+  AND r.resolution = sqlc.arg(resolution)
+  AND r.bucket >= sqlc.arg(range_from)
+  AND r.bucket <= sqlc.arg(range_to)
+GROUP BY r.bucket
+ORDER BY r.bucket;
+
+-- name: InsertHostStats :one
+INSERT INTO host_stats (cpu_usage_percentage, memory_total, memory_used, swap_total, swap_used, load_1, load_5, load_15,
+                        disk_total, disk_used)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING *;
+
+-- name: GetHostStatsFromTo :many
+-- averages host_stats over buckets of bucket_size seconds.
+SELECT (to_timestamp(floor(extract(epoch FROM created_at) / sqlc.arg(bucket_size)::integer) *
+                     sqlc.arg(bucket_size)::integer) AT TIME ZONE 'UTC')::timestamp AS bucket,
+       avg(cpu_usage_percentage)::float                                            AS cpu_usage_percentage,
+       max(memory_total)::bigint                                                   AS memory_total,
+       avg(memory_used)::bigint                                                    AS memory_used,
+       max(swap_total)::bigint                                                     AS swap_total,
+       avg(swap_used)::bigint                                                      AS swap_used,
+       avg(load_1)::float                                                          AS load_1,
+       avg(load_5)::float                                                          AS load_5,
+       avg(load_15)::float                                                         AS load_15,
+       max(disk_total)::bigint                                                     AS disk_total,
+       avg(disk_used)::bigint                                                      AS disk_used
+FROM host_stats
+WHERE created_at >= sqlc.arg(range_from)
+  AND created_at <= sqlc.arg(range_to)
+GROUP BY bucket
+ORDER BY bucket;
+
+-- name: DeleteHostStatsBefore :execrows
+DELETE
+FROM host_stats
+WHERE created_at < $1;
+
+-- name: InsertAuditLog :one
+INSERT INTO audit_log (actor, remote_addr, request_id, method, route, action, target_type, target_id, changes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING *;
+
+-- name: GetAuditLog :many
+-- newest first. Empty filters match everything, before_id is the keyset cursor.
+SELECT *
+FROM audit_log
+WHERE (sqlc.narg(actor)::varchar IS NULL OR actor = sqlc.narg(actor)::varchar)
+  AND (sqlc.narg(action)::varchar IS NULL OR action = sqlc.narg(action)::varchar)
+  AND (sqlc.narg(target_type)::varchar IS NULL OR target_type = sqlc.narg(target_type)::varchar)
+  AND (sqlc.narg(target_id)::integer IS NULL OR target_id = sqlc.narg(target_id)::integer)
+  AND created_at >= sqlc.arg(range_from)
+  AND created_at <= sqlc.arg(range_to)
+  AND (sqlc.narg(before_id)::integer IS NULL OR id < sqlc.narg(before_id)::integer)
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (name, key_hash, scope, group_ids, expires_at)
+VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: GetApiKeyByHash :one
+SELECT *
+FROM api_keys
+WHERE key_hash = $1
+  AND revoked_at IS NULL;
+
+-- name: GetApiKeys :many
+SELECT *
+FROM api_keys
+ORDER BY id;
+
+-- name: GetApiKey :one
+SELECT *
+FROM api_keys
+WHERE id = $1;
+
+-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND revoked_at IS NULL RETURNING *;
+
+-- name: TouchApiKey :exec
+-- last_used_at is only updated once a minute, so every request doesn't result in a write.
+UPDATE api_keys
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute');
+
+-- name: CreateUser :one
+INSERT INTO users (username, password_hash, role)
+VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetUser :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: GetUserByUsername :one
+SELECT *
+FROM users
+WHERE username = $1;
+
+-- name: GetUsers :many
+SELECT *
+FROM users
+ORDER BY id;
+
+-- name: CountUsers :one
+SELECT count(*)
+FROM users;
+
+-- name: DeleteUser :exec
+DELETE
+FROM users
+WHERE id = $1;
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET password_hash       = $2,
+    password_changed_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- name: CreateUserSession :one
+INSERT INTO user_sessions (user_id, refresh_hash, expires_at)
+VALUES ($1, $2, $3) RETURNING *;
+
+-- name: GetUserSession :one
+SELECT *
+FROM user_sessions
+WHERE id = $1
+  AND revoked_at IS NULL;
+
+-- name: RotateUserSession :one
+UPDATE user_sessions
+SET refresh_hash = sqlc.arg(new_refresh_hash),
+    expires_at   = sqlc.arg(expires_at)
+WHERE refresh_hash = sqlc.arg(refresh_hash)
+  AND revoked_at IS NULL
+  AND user_sessions.expires_at > sqlc.arg(now) RETURNING *;
+
+-- name: RevokeUserSession :exec
+UPDATE user_sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1
+  AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserSessions :exec
+UPDATE user_sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1
+  AND id != $2
+  AND revoked_at IS NULL;
+
+-- name: GetNotificationRoutes :many
+SELECT *
+FROM notification_routes
+ORDER BY is_default, position, id;
+
+-- name: GetNotificationRoute :one
+SELECT *
+FROM notification_routes
+WHERE id = $1;
+
+-- name: CreateNotificationRoute :one
+INSERT INTO notification_routes (name, position, is_default, process_ids, group_ids, events, targets, continue_matching)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *;
+
+-- name: UpdateNotificationRoute :one
+UPDATE notification_routes
+SET name              = $2,
+    position          = $3,
+    is_default        = $4,
+    process_ids       = $5,
+    group_ids         = $6,
+    events            = $7,
+    targets           = $8,
+    continue_matching = $9
+WHERE id = $1 RETURNING *;
+
+-- name: DeleteNotificationRoute :exec
+DELETE
+FROM notification_routes
+WHERE id = $1;
+
+-- name: ClearDefaultNotificationRoute :exec
+-- there can only be one default route.
+UPDATE notification_routes
+SET is_default = FALSE
+WHERE is_default
+  AND id != $1;
+
+-- name: InsertNotificationOutbox :one
+INSERT INTO notification_outbox (process_id, event, channel, recipients, notification)
+VALUES ($1, $2, $3, $4, $5) RETURNING *;
+
+-- name: GetDueNotifications :many
+SELECT *
+FROM notification_outbox
+WHERE status = 'pending'
+  AND next_attempt_at <= CURRENT_TIMESTAMP
+ORDER BY id
+LIMIT $1;
+
+-- name: UpdateNotificationOutbox :exec
+UPDATE notification_outbox
+SET status          = $2,
+    attempts        = $3,
+    recipients      = $4,
+    next_attempt_at = $5,
+    finished_at     = $6
+WHERE id = $1;
+
+-- name: InsertNotificationAttempt :exec
+INSERT INTO notification_attempts (outbox_id, attempt, success, recipient, chat_id, message_id, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetNotificationHistory :many
+-- newest first. Empty filters match everything, before_id is the keyset cursor.
+SELECT *
+FROM notification_outbox
+WHERE (sqlc.narg(process_id)::integer IS NULL OR process_id = sqlc.narg(process_id)::integer)
+  AND (sqlc.narg(channel)::varchar IS NULL OR channel = sqlc.narg(channel)::varchar)
+  AND (sqlc.narg(status)::varchar IS NULL OR status = sqlc.narg(status)::varchar)
+  AND created_at >= sqlc.arg(range_from)
+  AND created_at <= sqlc.arg(range_to)
+  AND (sqlc.narg(before_id)::integer IS NULL OR id < sqlc.narg(before_id)::integer)
+ORDER BY id DESC
+LIMIT sqlc.arg(row_limit);
+
+-- name: GetNotificationAttempts :many
+SELECT *
+FROM notification_attempts
+WHERE outbox_id = ANY (sqlc.arg(outbox_ids)::integer[])
+ORDER BY id;
+
+-- name: DeleteFinishedNotificationsBefore :exec
+DELETE
+FROM notification_outbox
+WHERE status != 'pending'
+  AND finished_at < $1;
+
+-- name: GetNotificationSettings :one
+SELECT settings
+FROM notification_settings
+WHERE id = 1;
+
+-- name: CreateNotificationSettings :execrows
+-- nothing is inserted if the settings exist, so only one instance imports notifications.json.
+INSERT INTO notification_settings (settings)
+VALUES ($1)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: UpdateNotificationSettings :exec
+UPDATE notification_settings
+SET settings   = $1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = 1;
+
+-- name: GetNotificationChannels :many
+SELECT *
+FROM notification_channels
+ORDER BY id;
+
+-- name: GetNotificationChannel :one
+SELECT *
+FROM notification_channels
+WHERE id = $1;
+
+-- name: CreateNotificationChannel :one
+INSERT INTO notification_channels (name, type, enabled, events, templates, settings)
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;
+
+-- name: UpdateNotificationChannel :one
+UPDATE notification_channels
+SET name      = $2,
+    type      = $3,
+    enabled   = $4,
+    events    = $5,
+    templates = $6,
+    settings  = $7
+WHERE id = $1 RETURNING *;
+
+-- name: DeleteNotificationChannel :exec
+DELETE
+FROM notification_channels
+WHERE id = $1;
+
+-- name: DeleteAllNotificationChannels :exec
+DELETE
+FROM notification_channels;
@@ -1,5 +1,5 @@
 CREATE TYPE process_status AS ENUM ('RUNNING', 'STOPPED', 'CRASHED', 'STARTING', 'STOPPING', 'STOPPED_WILL_RESTART', 'CRASHED_WILL_RESTART', 'UNKNOWN');
-CREATE TYPE process_event_type AS ENUM ('UNKNOWN', 'START', 'STOP', 'CRASH', 'FULL_STOP', 'FULL_CRASH', 'MANUALLY_STOPPED', 'RESTART');
+CREATE TYPE process_event_type AS ENUM ('UNKNOWN', 'START', 'STOP', 'CRASH', 'FULL_STOP', 'FULL_CRASH', 'MANUALLY_STOPPED', 'RESTART', 'THRESHOLD');
 
 CREATE TABLE IF NOT EXISTS process_group
 (
@@ -59,3 +59,195 @@ CREATE TABLE IF NOT EXISTS logs
     end_time   TIMESTAMP DEFAULT NULL,
     path       VARCHAR(512) NOT NULL
 );
+
+
+-- process_stats aggregated into fixed buckets. resolution is the bucket size in seconds (60, 3600 or 86400).
+-- 1-minute buckets are computed from process_stats, coarser ones from the next finer resolution,
+-- so their p95 values are approximations.
+CREATE TABLE IF NOT EXISTS process_stats_rollup
+(
+    process_id  INTEGER   NOT NULL REFERENCES process (id) ON DELETE CASCADE,
+    resolution  INTEGER   NOT NULL,
+    bucket      TIMESTAMP NOT NULL,
+    samples     INTEGER   NOT NULL,
+    cpu_usage   BIGINT    NOT NULL,
+    cpu_avg     FLOAT     NOT NULL,
+    cpu_min     FLOAT     NOT NULL,
+    cpu_max     FLOAT     NOT NULL,
+    cpu_p95     FLOAT     NOT NULL,
+    memory_avg  BIGINT    NOT NULL,
+    memory_min  BIGINT    NOT NULL,
+    memory_max  BIGINT    NOT NULL,
+    memory_p95  BIGINT    NOT NULL,
+    PRIMARY KEY (process_id, resolution, bucket)
+);
+
+-- host-wide usage, recorded every process_stats_interval. disk_* is the file system of logs_folder.
+CREATE TABLE IF NOT EXISTS host_stats
+(
+    id                   SERIAL PRIMARY KEY,
+    created_at           TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    cpu_usage_percentage FLOAT     NOT NULL,
+    memory_total         BIGINT    NOT NULL,
+    memory_used          BIGINT    NOT NULL,
+    swap_total           BIGINT    NOT NULL,
+    swap_used            BIGINT    NOT NULL,
+    load_1               FLOAT     NOT NULL,
+    load_5               FLOAT     NOT NULL,
+    load_15              FLOAT     NOT NULL,
+    disk_total           BIGINT    NOT NULL,
+    disk_used            BIGINT    NOT NULL
+);
+
+-- every mutating API call. changes holds the fields of the target that changed, as {"field": {"before": .., "after": ..}}.
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          SERIAL PRIMARY KEY,
+    created_at  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor       VARCHAR(255) NOT NULL,
+    remote_addr VARCHAR(255) NOT NULL,
+    request_id  VARCHAR(32)  NOT NULL,
+    method      VARCHAR(16)  NOT NULL,
+    route       VARCHAR(255) NOT NULL,
+    action      VARCHAR(64)  NOT NULL,
+    target_type VARCHAR(32)  NOT NULL,
+    target_id   INTEGER,
+    changes     JSONB
+);
+
+-- API keys. Only the sha256 of the key is stored. scope is 'read', 'operate' or 'admin',
+-- group_ids restricts the key to processes of those groups, NULL means all of them.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           SERIAL PRIMARY KEY,
+    name         VARCHAR(255) NOT NULL,
+    key_hash     BYTEA        NOT NULL UNIQUE,
+    scope        VARCHAR(16)  NOT NULL,
+    group_ids    INTEGER[]             DEFAULT NULL,
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at   TIMESTAMP             DEFAULT NULL,
+    last_used_at TIMESTAMP             DEFAULT NULL,
+    revoked_at   TIMESTAMP             DEFAULT NULL
+);
+
+-- local users. role is one of the API key scopes.
+CREATE TABLE IF NOT EXISTS users
+(
+    id                  SERIAL PRIMARY KEY,
+    username            VARCHAR(255) NOT NULL UNIQUE,
+    password_hash       VARCHAR(255) NOT NULL,
+    role                VARCHAR(16)  NOT NULL,
+    created_at          TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    password_changed_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- login sessions. Only the sha256 of the refresh token is stored, it changes on every refresh.
+CREATE TABLE IF NOT EXISTS user_sessions
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_hash BYTEA     NOT NULL UNIQUE,
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at   TIMESTAMP NOT NULL,
+    revoked_at   TIMESTAMP          DEFAULT NULL
+);
+
+-- notification routing rules, matched by position. Empty process_ids, group_ids and events match everything.
+-- targets is a JSON array of {"channel": .., "recipients": [..]}, an empty array drops matching notifications.
+-- The is_default route is used if no other route matches, without one notifications go to all channels.
+CREATE TABLE IF NOT EXISTS notification_routes
+(
+    id                SERIAL PRIMARY KEY,
+    name              VARCHAR(255)  NOT NULL,
+    position          INTEGER       NOT NULL DEFAULT 0,
+    is_default        BOOLEAN       NOT NULL DEFAULT FALSE,
+    process_ids       INTEGER[]     NOT NULL DEFAULT '{}',
+    group_ids         INTEGER[]     NOT NULL DEFAULT '{}',
+    events            VARCHAR(32)[] NOT NULL DEFAULT '{}',
+    targets           JSONB         NOT NULL DEFAULT '[]',
+    -- continue_matching also applies the routes after this one, instead of stopping at the first match.
+    continue_matching BOOLEAN       NOT NULL DEFAULT FALSE,
+    created_at        TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- notifications to deliver and their delivery history, one row per notification and channel.
+-- status is 'pending', 'sent' or 'failed'. recipients are the ones left to deliver to, empty means all of the channel.
+-- notification is the rendered config.Notification.
+CREATE TABLE IF NOT EXISTS notification_outbox
+(
+    id              SERIAL PRIMARY KEY,
+    created_at      TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    process_id      INTEGER        REFERENCES process (id) ON DELETE SET NULL,
+    event           VARCHAR(32)    NOT NULL,
+    channel         VARCHAR(255)   NOT NULL,
+    recipients      VARCHAR(255)[] NOT NULL DEFAULT '{}',
+    notification    JSONB          NOT NULL,
+    status          VARCHAR(16)    NOT NULL DEFAULT 'pending',
+    attempts        INTEGER        NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at     TIMESTAMP               DEFAULT NULL
+);
+
+-- the results of delivery attempts, one row per recipient.
+CREATE TABLE IF NOT EXISTS notification_attempts
+(
+    id         SERIAL PRIMARY KEY,
+    outbox_id  INTEGER      NOT NULL REFERENCES notification_outbox (id) ON DELETE CASCADE,
+    attempt    INTEGER      NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    success    BOOLEAN      NOT NULL,
+    recipient  VARCHAR(255) NOT NULL DEFAULT '',
+    chat_id    BIGINT                DEFAULT NULL,
+    message_id INTEGER               DEFAULT NULL,
+    error      TEXT         NOT NULL DEFAULT ''
+);
+
+-- notification settings, in a single row. settings is a config.NotificationSettings.
+-- The row is created on the first start, from notifications.json if the file exists.
+CREATE TABLE IF NOT EXISTS notification_settings
+(
+    id         INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    settings   JSONB     NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- notification channels. settings are the settings of the type, e.g. a config.WebhookChannelConfig for webhooks.
+-- "telegram" is the bot of notification_settings, it's not a valid name.
+CREATE TABLE IF NOT EXISTS notification_channels
+(
+    id         SERIAL PRIMARY KEY,
+    name       VARCHAR(255)  NOT NULL UNIQUE,
+    type       VARCHAR(32)   NOT NULL,
+    enabled    BOOLEAN       NOT NULL DEFAULT TRUE,
+    events     VARCHAR(32)[] NOT NULL DEFAULT '{}',
+    templates  JSONB         NOT NULL DEFAULT '{}',
+    settings   JSONB         NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS process_stats_created_at_idx ON process_stats (created_at);
+CREATE INDEX IF NOT EXISTS process_stats_rollup_bucket_idx ON process_stats_rollup (resolution, bucket);
+CREATE INDEX IF NOT EXISTS host_stats_created_at_idx ON host_stats (created_at);
+CREATE INDEX IF NOT EXISTS process_event_created_at_idx ON process_event (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target_type, target_id);
+CREATE INDEX IF NOT EXISTS notification_outbox_pending_idx ON notification_outbox (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS notification_attempts_outbox_idx ON notification_attempts (outbox_id);
+CREATE UNIQUE INDEX IF NOT EXISTS notification_routes_default_idx ON notification_routes (is_default) WHERE is_default;
+
+-- Migrations for existing databases. Every statement below must be safe to run more than once.
+
+-- extended per-process metrics. NULL means the metric group was not collected.
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS thread_count             INTEGER DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS open_fds                 INTEGER DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS io_read_bytes            BIGINT  DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS io_write_bytes           BIGINT  DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS ctx_switches_voluntary   BIGINT  DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS ctx_switches_involuntary BIGINT  DEFAULT NULL;
+ALTER TABLE process_stats ADD COLUMN IF NOT EXISTS child_count              INTEGER DEFAULT NULL;
+
+-- resource threshold rules log THRESHOLD events.
+ALTER TYPE process_event_type ADD VALUE IF NOT EXISTS 'THRESHOLD';
+
+-- events caused by an API call reference its audit entry.
+ALTER TABLE process_event ADD COLUMN IF NOT EXISTS audit_id INTEGER REFERENCES audit_log (id) ON DELETE SET NULL;